@@ -0,0 +1,67 @@
+// Package blob stores payloads too large to travel through the broker, so
+// messages can carry a reference to them instead (the claim-check pattern).
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Supported store kinds for New.
+const (
+	KindFS     = "fs"
+	KindGridFS = "gridfs"
+	KindMemory = "memory"
+)
+
+// ErrNotFound is returned when reading a blob that does not exist.
+var ErrNotFound = errors.New("blob: not found")
+
+// Store keeps blobs by key. Deleting a missing blob is not an error.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+	// DeleteOlder deletes the blobs put before t, returning how many were
+	// deleted.
+	DeleteOlder(ctx context.Context, t time.Time) (int, error)
+	Close(ctx context.Context) error
+}
+
+// Config selects and configures a store implementation.
+type Config struct {
+	// Kind is one of KindFS, KindGridFS or KindMemory.
+	Kind string
+	// Dir is the directory of a KindFS store.
+	Dir string
+	// MongoURI, MongoDB and Bucket locate a KindGridFS store.
+	MongoURI string
+	MongoDB  string
+	Bucket   string
+}
+
+// New returns the store described by cfg.
+func New(ctx context.Context, cfg Config) (Store, error) {
+	switch cfg.Kind {
+	case KindFS:
+		return NewFS(cfg.Dir)
+	case KindGridFS:
+		return NewGridFS(ctx, cfg.MongoURI, cfg.MongoDB, cfg.Bucket)
+	case KindMemory:
+		return NewMemory(), nil
+	default:
+		return nil, fmt.Errorf("blob: unknown kind %q", cfg.Kind)
+	}
+}
+
+// checkKey rejects keys that can't be used as file names.
+func checkKey(key string) error {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return fmt.Errorf("blob: invalid key %q", key)
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// implementations returns an empty store of every kind that runs without a
+// server.
+var implementations = map[string]func(t *testing.T) Store{
+	KindMemory: func(t *testing.T) Store {
+		return NewMemory()
+	},
+	KindFS: func(t *testing.T) Store {
+		s, err := NewFS(t.TempDir())
+		if err != nil {
+			t.Fatalf("NewFS: %v", err)
+		}
+		return s
+	},
+}
+
+func TestStores(t *testing.T) {
+	for kind, newStore := range implementations {
+		t.Run(kind, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+
+			if err := s.Put(ctx, "a", []byte("payload")); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			if data, err := s.Get(ctx, "a"); err != nil || string(data) != "payload" {
+				t.Fatalf("Get = %q, %v, want payload", data, err)
+			}
+
+			if err := s.Delete(ctx, "a"); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if err := s.Delete(ctx, "a"); err != nil {
+				t.Fatalf("Delete of a missing blob: %v", err)
+			}
+
+			if _, err := s.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get after Delete: %v, want ErrNotFound", err)
+			}
+
+			if err := s.Put(ctx, "../a", nil); err == nil {
+				t.Fatal("Put with a path as key succeeded")
+			}
+		})
+	}
+}
+
+func TestDeleteOlder(t *testing.T) {
+	for kind, newStore := range implementations {
+		t.Run(kind, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStore(t)
+
+			s.Put(ctx, "old", []byte("old"))
+			time.Sleep(20 * time.Millisecond)
+			cutoff := time.Now()
+			time.Sleep(20 * time.Millisecond)
+			s.Put(ctx, "new", []byte("new"))
+
+			deleted, err := s.DeleteOlder(ctx, cutoff)
+			if err != nil || deleted != 1 {
+				t.Fatalf("DeleteOlder = %d, %v, want 1", deleted, err)
+			}
+
+			if _, err := s.Get(ctx, "old"); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Get(old): %v, want ErrNotFound", err)
+			}
+			if _, err := s.Get(ctx, "new"); err != nil {
+				t.Errorf("Get(new): %v", err)
+			}
+		})
+	}
+}
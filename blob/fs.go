@@ -0,0 +1,129 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FS is a Store keeping one file per blob in a directory. Services sharing
+// it must mount the same directory.
+type FS struct {
+	dir string
+}
+
+// NewFS returns a store in dir, creating it if needed.
+func NewFS(dir string) (*FS, error) {
+	if dir == "" {
+		return nil, errors.New("blob: no directory")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FS{dir: dir}, nil
+}
+
+func (s *FS) Put(ctx context.Context, key string, data []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial blob.
+	tmp, err := os.CreateTemp(s.dir, ".put-*")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (s *FS) Get(ctx context.Context, key string) ([]byte, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+func (s *FS) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(s.dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// DeleteOlder deletes the files last written before t, including the
+// temporary files of interrupted puts.
+func (s *FS) DeleteOlder(ctx context.Context, t time.Time) (int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+
+		if !info.Mode().IsRegular() || !info.ModTime().Before(t) {
+			continue
+		}
+
+		err = os.Remove(filepath.Join(s.dir, entry.Name()))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+func (s *FS) Close(ctx context.Context) error {
+	return nil
+}
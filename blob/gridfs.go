@@ -0,0 +1,107 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFS is a Store in a Mongo GridFS bucket, using the key as file ID.
+type GridFS struct {
+	client *mongo.Client
+	bucket *gridfs.Bucket
+}
+
+// NewGridFS connects to uri and returns a store in the named bucket of
+// dbName, "payloads" by default.
+func NewGridFS(ctx context.Context, uri, dbName, bucketName string) (*GridFS, error) {
+	if bucketName == "" {
+		bucketName = "payloads"
+	}
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, err := gridfs.NewBucket(client.Database(dbName), options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	return &GridFS{client: client, bucket: bucket}, nil
+}
+
+func (s *GridFS) Put(ctx context.Context, key string, data []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	// A blob put again replaces the previous one.
+	if err := s.Delete(ctx, key); err != nil {
+		return err
+	}
+
+	return s.bucket.UploadFromStreamWithID(key, key, bytes.NewReader(data))
+}
+
+func (s *GridFS) Get(ctx context.Context, key string) ([]byte, error) {
+	var buf bytes.Buffer
+
+	_, err := s.bucket.DownloadToStream(key, &buf)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *GridFS) Delete(ctx context.Context, key string) error {
+	err := s.bucket.DeleteContext(ctx, key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// DeleteOlder deletes the files uploaded before t.
+func (s *GridFS) DeleteOlder(ctx context.Context, t time.Time) (int, error) {
+	cursor, err := s.bucket.FindContext(ctx, bson.M{"uploadDate": bson.M{"$lt": t}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var deleted int
+	for cursor.Next(ctx) {
+		var file struct {
+			ID string `bson:"_id"`
+		}
+		if err := cursor.Decode(&file); err != nil {
+			return deleted, err
+		}
+
+		if err := s.Delete(ctx, file.ID); err != nil {
+			return deleted, err
+		}
+
+		deleted++
+	}
+
+	return deleted, cursor.Err()
+}
+
+func (s *GridFS) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
@@ -0,0 +1,84 @@
+package blob
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Store.
+type Memory struct {
+	mu    sync.Mutex
+	blobs map[string]memoryBlob
+}
+
+type memoryBlob struct {
+	data  []byte
+	putAt time.Time
+}
+
+// NewMemory returns an empty in-memory store.
+func NewMemory() *Memory {
+	return &Memory{blobs: make(map[string]memoryBlob)}
+}
+
+func (m *Memory) Put(ctx context.Context, key string, data []byte) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.blobs[key] = memoryBlob{data: append([]byte(nil), data...), putAt: time.Now()}
+
+	return nil
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	blob, ok := m.blobs[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return append([]byte(nil), blob.data...), nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.blobs, key)
+
+	return nil
+}
+
+func (m *Memory) DeleteOlder(ctx context.Context, t time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int
+	for key, blob := range m.blobs {
+		if blob.putAt.Before(t) {
+			delete(m.blobs, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// Len returns the number of blobs held.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.blobs)
+}
+
+func (m *Memory) Close(ctx context.Context) error {
+	return nil
+}
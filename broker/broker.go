@@ -0,0 +1,115 @@
+// Package broker abstracts the message bus used between the sandbox services so
+// they can run against Kafka or an in-process bus.
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Start offsets accepted by SubscribeOptions. They match the Kafka constants.
+const (
+	LastOffset  int64 = -1
+	FirstOffset int64 = -2
+)
+
+// Supported broker kinds for New.
+const (
+	KindKafka  = "kafka"
+	KindMemory = "memory"
+	KindNATS   = "nats"
+)
+
+// ErrClosed is returned when using a closed broker or subscription.
+var ErrClosed = errors.New("broker: closed")
+
+// ErrStartTimeWithGroup is returned when subscribing to a group from a time.
+var ErrStartTimeWithGroup = errors.New("broker: start time is not supported with a group")
+
+// ErrInvalidStartOffset is returned when subscribing from a negative offset
+// other than FirstOffset and LastOffset.
+var ErrInvalidStartOffset = errors.New("broker: invalid start offset")
+
+// Header is a single message header.
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Message is a message published to or fetched from a topic.
+type Message struct {
+	Topic     string
+	Key       []byte
+	Value     []byte
+	Headers   []Header
+	Partition int
+	Offset    int64
+	Time      time.Time
+}
+
+// SubscribeOptions controls where a subscription starts reading.
+type SubscribeOptions struct {
+	// Group shares the topic between subscribers and tracks committed
+	// offsets. Without a group every subscriber sees every message.
+	Group string
+	// StartOffset is used when there is no committed offset for the group.
+	// Defaults to FirstOffset. Other negative values are rejected.
+	StartOffset int64
+	// StartTime, when set, starts at the first message published at or after
+	// it instead of StartOffset. Only supported without a group.
+	StartTime time.Time
+}
+
+// Subscription reads messages from one topic.
+type Subscription interface {
+	// Fetch blocks until the next message is available or ctx is done.
+	Fetch(ctx context.Context) (Message, error)
+	// Commit marks messages as processed for the subscription's group.
+	Commit(ctx context.Context, msgs ...Message) error
+	Close() error
+}
+
+// Broker publishes messages to topics and subscribes to them.
+type Broker interface {
+	Publish(ctx context.Context, topic string, msgs ...Message) error
+	Subscribe(topic string, opts SubscribeOptions) (Subscription, error)
+	Close() error
+}
+
+// Config selects and configures a broker implementation.
+type Config struct {
+	// Kind is one of KindKafka, KindMemory or KindNATS. Defaults to KindKafka.
+	Kind         string
+	KafkaAddress string
+	Memory       MemoryConfig
+	NATS         NATSConfig
+}
+
+// New returns the broker described by cfg.
+func New(cfg Config) (Broker, error) {
+	switch cfg.Kind {
+	case KindKafka, "":
+		return NewKafka(cfg.KafkaAddress), nil
+	case KindMemory:
+		return NewMemory(cfg.Memory), nil
+	case KindNATS:
+		return NewNATS(cfg.NATS)
+	default:
+		return nil, fmt.Errorf("broker: unknown kind %q", cfg.Kind)
+	}
+}
+
+// checkSubscribe validates the options every implementation supports.
+func checkSubscribe(opts SubscribeOptions) error {
+	if !opts.StartTime.IsZero() && opts.Group != "" {
+		return ErrStartTimeWithGroup
+	}
+
+	if opts.StartOffset < 0 && opts.StartOffset != FirstOffset && opts.StartOffset != LastOffset {
+		return fmt.Errorf("%w %d", ErrInvalidStartOffset, opts.StartOffset)
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// implementations returns a fresh broker of every kind that runs in process.
+var implementations = map[string]func(t *testing.T) Broker{
+	KindMemory: func(t *testing.T) Broker {
+		return NewMemory(MemoryConfig{})
+	},
+	KindNATS: func(t *testing.T) Broker {
+		n, err := NewNATS(NATSConfig{Embedded: true, StoreDir: t.TempDir()})
+		if err != nil {
+			t.Fatalf("NewNATS: %v", err)
+		}
+		return n
+	},
+}
+
+// TestConformance runs the same behaviour checks against every broker.
+func TestConformance(t *testing.T) {
+	tests := map[string]func(t *testing.T, bus Broker){
+		"delivers in order":            testDeliversInOrder,
+		"starts from last offset":      testStartsFromLast,
+		"groups resume after commit":   testGroupResumes,
+		"groups read independently":    testGroupsIndependent,
+		"starts from a time":           testStartsFromTime,
+		"rejects invalid start offset": testRejectsInvalidStart,
+		"fetch honours context":        testFetchHonoursContext,
+	}
+
+	for kind, newBroker := range implementations {
+		for name, test := range tests {
+			t.Run(kind+"/"+name, func(t *testing.T) {
+				bus := newBroker(t)
+				t.Cleanup(func() { bus.Close() })
+
+				test(t, bus)
+			})
+		}
+	}
+}
+
+func publish(t *testing.T, bus Broker, topic string, values ...string) {
+	t.Helper()
+
+	for _, value := range values {
+		msg := Message{
+			Key:     []byte("key-" + value),
+			Value:   []byte(value),
+			Headers: []Header{{Key: "value", Value: []byte(value)}},
+		}
+		if err := bus.Publish(context.Background(), topic, msg); err != nil {
+			t.Fatalf("Publish(%s): %v", value, err)
+		}
+	}
+}
+
+func subscribe(t *testing.T, bus Broker, topic string, opts SubscribeOptions) Subscription {
+	t.Helper()
+
+	sub, err := bus.Subscribe(topic, opts)
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	t.Cleanup(func() { sub.Close() })
+
+	return sub
+}
+
+// fetch reads n messages, failing when they take too long.
+func fetch(t *testing.T, sub Subscription, n int) []Message {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	msgs := make([]Message, n)
+	for i := range msgs {
+		msg, err := sub.Fetch(ctx)
+		if err != nil {
+			t.Fatalf("Fetch %d of %d: %v", i+1, n, err)
+		}
+		msgs[i] = msg
+	}
+
+	return msgs
+}
+
+// expectNothing checks no message is fetched for a while.
+func expectNothing(t *testing.T, sub Subscription) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	if msg, err := sub.Fetch(ctx); err == nil {
+		t.Fatalf("fetched %q, want nothing", msg.Value)
+	}
+}
+
+func values(msgs []Message) string {
+	var s []string
+	for _, msg := range msgs {
+		s = append(s, string(msg.Value))
+	}
+	return fmt.Sprint(s)
+}
+
+func testDeliversInOrder(t *testing.T, bus Broker) {
+	publish(t, bus, "orders", "a", "b", "c")
+
+	msgs := fetch(t, subscribe(t, bus, "orders", SubscribeOptions{}), 3)
+
+	if got := values(msgs); got != "[a b c]" {
+		t.Fatalf("values = %s, want [a b c]", got)
+	}
+
+	for i, msg := range msgs {
+		value := string(msg.Value)
+
+		if msg.Topic != "orders" {
+			t.Errorf("%s: topic = %q", value, msg.Topic)
+		}
+		if string(msg.Key) != "key-"+value {
+			t.Errorf("%s: key = %q", value, msg.Key)
+		}
+		if len(msg.Headers) != 1 || msg.Headers[0].Key != "value" || string(msg.Headers[0].Value) != value {
+			t.Errorf("%s: headers = %v", value, msg.Headers)
+		}
+		if msg.Time.IsZero() {
+			t.Errorf("%s: no time", value)
+		}
+		if i > 0 && msg.Offset <= msgs[i-1].Offset {
+			t.Errorf("%s: offset %d not after %d", value, msg.Offset, msgs[i-1].Offset)
+		}
+	}
+}
+
+func testStartsFromLast(t *testing.T, bus Broker) {
+	publish(t, bus, "orders", "old")
+
+	sub := subscribe(t, bus, "orders", SubscribeOptions{StartOffset: LastOffset})
+	publish(t, bus, "orders", "new")
+
+	if got := values(fetch(t, sub, 1)); got != "[new]" {
+		t.Fatalf("values = %s, want [new]", got)
+	}
+}
+
+func testGroupResumes(t *testing.T, bus Broker) {
+	publish(t, bus, "orders", "a", "b")
+
+	sub, err := bus.Subscribe("orders", SubscribeOptions{Group: "billing"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	msgs := fetch(t, sub, 2)
+	if err := sub.Commit(context.Background(), msgs...); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	sub.Close()
+
+	publish(t, bus, "orders", "c")
+
+	sub = subscribe(t, bus, "orders", SubscribeOptions{Group: "billing"})
+	if got := values(fetch(t, sub, 1)); got != "[c]" {
+		t.Fatalf("values after rejoining = %s, want [c]", got)
+	}
+}
+
+func testGroupsIndependent(t *testing.T, bus Broker) {
+	publish(t, bus, "orders", "a", "b")
+
+	billing := subscribe(t, bus, "orders", SubscribeOptions{Group: "billing"})
+	msgs := fetch(t, billing, 2)
+	if err := billing.Commit(context.Background(), msgs...); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	shipping := subscribe(t, bus, "orders", SubscribeOptions{Group: "shipping"})
+	if got := values(fetch(t, shipping, 2)); got != "[a b]" {
+		t.Fatalf("second group values = %s, want [a b]", got)
+	}
+}
+
+func testStartsFromTime(t *testing.T, bus Broker) {
+	publish(t, bus, "orders", "old")
+	time.Sleep(50 * time.Millisecond)
+	from := time.Now()
+	publish(t, bus, "orders", "new")
+
+	if got := values(fetch(t, subscribe(t, bus, "orders", SubscribeOptions{StartTime: from}), 1)); got != "[new]" {
+		t.Fatalf("values = %s, want [new]", got)
+	}
+
+	if _, err := bus.Subscribe("orders", SubscribeOptions{Group: "billing", StartTime: from}); !errors.Is(err, ErrStartTimeWithGroup) {
+		t.Fatalf("Subscribe with a group from a time: %v, want ErrStartTimeWithGroup", err)
+	}
+}
+
+func testRejectsInvalidStart(t *testing.T, bus Broker) {
+	for _, opts := range []SubscribeOptions{{StartOffset: -3}, {Group: "billing", StartOffset: -10}} {
+		if _, err := bus.Subscribe("orders", opts); !errors.Is(err, ErrInvalidStartOffset) {
+			t.Errorf("Subscribe(%+v): %v, want ErrInvalidStartOffset", opts, err)
+		}
+	}
+}
+
+func testFetchHonoursContext(t *testing.T, bus Broker) {
+	sub := subscribe(t, bus, "orders", SubscribeOptions{})
+	expectNothing(t, sub)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := sub.Fetch(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("Fetch with a cancelled context: %v, want context.Canceled", err)
+	}
+}
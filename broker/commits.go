@@ -0,0 +1,92 @@
+package broker
+
+import (
+	"sync"
+)
+
+// Commits holds back the commits of messages processed out of order, such
+// as concurrently. Committing an offset on Kafka commits every earlier one
+// too, so a message is only committed once every message read before it on
+// its partition has completed, up to the partition's low-water mark.
+type Commits struct {
+	mu         sync.Mutex
+	partitions map[int]*partitionCommits
+}
+
+// partitionCommits holds the messages of a partition not committed yet, in
+// the order they were read.
+type partitionCommits struct {
+	pending []*pendingCommit
+	// byOffset indexes pending, to notice redeliveries.
+	byOffset map[int64]*pendingCommit
+}
+
+type pendingCommit struct {
+	msg  Message
+	done bool
+}
+
+func NewCommits() *Commits {
+	return &Commits{partitions: make(map[int]*partitionCommits)}
+}
+
+// Track records msg as read. It must be called in the order messages are
+// read, before they are processed.
+func (c *Commits) Track(msg Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.partitions[msg.Partition]
+	if !ok {
+		p = &partitionCommits{byOffset: make(map[int64]*pendingCommit)}
+		c.partitions[msg.Partition] = p
+	}
+
+	// A redelivered message keeps its place.
+	if _, ok := p.byOffset[msg.Offset]; ok {
+		return
+	}
+
+	pending := &pendingCommit{msg: msg}
+	p.pending = append(p.pending, pending)
+	p.byOffset[msg.Offset] = pending
+}
+
+// Pending returns the number of messages tracked but not committed yet.
+func (c *Commits) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int
+	for _, p := range c.partitions {
+		n += len(p.pending)
+	}
+
+	return n
+}
+
+// Complete records whether msg may be committed, and returns the messages
+// that can now be, as no earlier message of their partition is pending.
+func (c *Commits) Complete(msg Message, acked bool) []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.partitions[msg.Partition]
+	if !ok {
+		return nil
+	}
+
+	if pending, ok := p.byOffset[msg.Offset]; ok && acked {
+		pending.done = true
+	}
+
+	var commits []Message
+	for len(p.pending) > 0 && p.pending[0].done {
+		commits = append(commits, p.pending[0].msg)
+		delete(p.byOffset, p.pending[0].msg.Offset)
+		p.pending[0] = nil
+		p.pending = p.pending[1:]
+	}
+
+	return commits
+}
@@ -0,0 +1,60 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func offsetsOf(msgs []Message) string {
+	var s []string
+	for _, msg := range msgs {
+		s = append(s, fmt.Sprintf("%d/%d", msg.Partition, msg.Offset))
+	}
+	return fmt.Sprint(s)
+}
+
+func TestCommitsLowWaterMark(t *testing.T) {
+	commits := NewCommits()
+
+	msg := func(partition int, offset int64) Message {
+		return Message{Partition: partition, Offset: offset}
+	}
+
+	for _, m := range []Message{msg(0, 0), msg(0, 1), msg(0, 2), msg(1, 0)} {
+		commits.Track(m)
+	}
+
+	steps := []struct {
+		msg   Message
+		acked bool
+		want  string
+	}{
+		// Completed before an earlier event, held back.
+		{msg(0, 1), true, "[]"},
+		// Other partitions aren't held back.
+		{msg(1, 0), true, "[1/0]"},
+		{msg(0, 0), true, "[0/0 0/1]"},
+		// Failed, held until redelivered.
+		{msg(0, 2), false, "[]"},
+	}
+
+	for _, step := range steps {
+		if got := offsetsOf(commits.Complete(step.msg, step.acked)); got != step.want {
+			t.Errorf("Complete(%d/%d, %t) = %s, want %s", step.msg.Partition, step.msg.Offset, step.acked, got, step.want)
+		}
+	}
+
+	// The redelivered message keeps its place.
+	commits.Track(msg(0, 3))
+	commits.Track(msg(0, 2))
+
+	if got := offsetsOf(commits.Complete(msg(0, 3), true)); got != "[]" {
+		t.Errorf("Complete(0/3) = %s, want [], 0/2 is still pending", got)
+	}
+	if got := offsetsOf(commits.Complete(msg(0, 2), true)); got != "[0/2 0/3]" {
+		t.Errorf("Complete(0/2) = %s, want [0/2 0/3]", got)
+	}
+	if got := commits.Pending(); got != 0 {
+		t.Errorf("Pending() = %d, want 0", got)
+	}
+}
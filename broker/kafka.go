@@ -0,0 +1,181 @@
+package broker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka is a Broker backed by a Kafka cluster.
+type Kafka struct {
+	address string
+
+	mu      sync.Mutex
+	writers map[string]*kafka.Writer
+	closed  bool
+}
+
+// NewKafka returns a broker connected to the Kafka broker at address.
+func NewKafka(address string) *Kafka {
+	return &Kafka{
+		address: address,
+		writers: make(map[string]*kafka.Writer),
+	}
+}
+
+func (k *Kafka) writer(topic string) (*kafka.Writer, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.closed {
+		return nil, ErrClosed
+	}
+
+	w, ok := k.writers[topic]
+	if !ok {
+		w = kafka.NewWriter(kafka.WriterConfig{
+			Brokers: []string{k.address},
+			Dialer:  kafka.DefaultDialer,
+			Topic:   topic,
+		})
+		k.writers[topic] = w
+	}
+
+	return w, nil
+}
+
+func (k *Kafka) Publish(ctx context.Context, topic string, msgs ...Message) error {
+	w, err := k.writer(topic)
+	if err != nil {
+		return err
+	}
+
+	kmsgs := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		kmsgs[i] = toKafka(msg)
+	}
+
+	return w.WriteMessages(ctx, kmsgs...)
+}
+
+func (k *Kafka) Subscribe(topic string, opts SubscribeOptions) (Subscription, error) {
+	if err := checkSubscribe(opts); err != nil {
+		return nil, err
+	}
+
+	startOffset := opts.StartOffset
+	if startOffset == 0 {
+		startOffset = FirstOffset
+	}
+
+	cfg := kafka.ReaderConfig{
+		Brokers:     []string{k.address},
+		Topic:       topic,
+		Dialer:      kafka.DefaultDialer,
+		GroupID:     opts.Group,
+		StartOffset: startOffset,
+	}
+
+	if opts.Group == "" {
+		cfg.Partition = 0
+	}
+
+	reader := kafka.NewReader(cfg)
+
+	if opts.Group == "" {
+		var err error
+		if opts.StartTime.IsZero() {
+			err = reader.SetOffset(startOffset)
+		} else {
+			err = reader.SetOffsetAt(context.Background(), opts.StartTime)
+		}
+
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+	}
+
+	return &kafkaSubscription{reader: reader, grouped: opts.Group != ""}, nil
+}
+
+func (k *Kafka) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.closed = true
+
+	var firstErr error
+	for topic, w := range k.writers {
+		if err := w.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(k.writers, topic)
+	}
+
+	return firstErr
+}
+
+type kafkaSubscription struct {
+	reader  *kafka.Reader
+	grouped bool
+}
+
+func (s *kafkaSubscription) Fetch(ctx context.Context) (Message, error) {
+	msg, err := s.reader.FetchMessage(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return fromKafka(msg), nil
+}
+
+func (s *kafkaSubscription) Commit(ctx context.Context, msgs ...Message) error {
+	// Offsets can only be committed for consumer groups.
+	if !s.grouped {
+		return nil
+	}
+
+	kmsgs := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		kmsgs[i] = kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset}
+	}
+
+	return s.reader.CommitMessages(ctx, kmsgs...)
+}
+
+func (s *kafkaSubscription) Close() error {
+	return s.reader.Close()
+}
+
+func toKafka(msg Message) kafka.Message {
+	headers := make([]kafka.Header, len(msg.Headers))
+	for i, h := range msg.Headers {
+		headers[i] = kafka.Header{Key: h.Key, Value: h.Value}
+	}
+
+	return kafka.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+		Time:    msg.Time,
+	}
+}
+
+func fromKafka(msg kafka.Message) Message {
+	headers := make([]Header, len(msg.Headers))
+	for i, h := range msg.Headers {
+		headers[i] = Header{Key: h.Key, Value: h.Value}
+	}
+
+	return Message{
+		Topic:     msg.Topic,
+		Key:       msg.Key,
+		Value:     msg.Value,
+		Headers:   headers,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Time:      msg.Time,
+	}
+}
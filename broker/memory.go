@@ -0,0 +1,282 @@
+package broker
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryConfig configures the retention of an in-memory broker.
+type MemoryConfig struct {
+	// MaxMessages is how many messages a topic keeps, dropping the oldest
+	// ones first. Defaults to 100000.
+	MaxMessages int
+	// MaxAge drops the messages older than it, when set.
+	MaxAge time.Duration
+}
+
+// Memory is an in-process Broker. Topics keep the published messages within
+// their retention so subscribers can start from any retained offset, and
+// groups track committed offsets the same way Kafka consumer groups do.
+type Memory struct {
+	cfg    MemoryConfig
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	closed chan struct{}
+}
+
+type memoryTopic struct {
+	// base is the offset of messages[0], the oldest retained message.
+	base     int64
+	messages []Message
+	groups   map[string]*memoryGroup
+	// notify is closed and replaced whenever a message is published.
+	notify chan struct{}
+}
+
+type memoryGroup struct {
+	next      int64
+	committed int64
+	members   int
+}
+
+// NewMemory returns an empty in-memory broker.
+func NewMemory(cfg MemoryConfig) *Memory {
+	if cfg.MaxMessages <= 0 {
+		cfg.MaxMessages = 100000
+	}
+
+	return &Memory{
+		cfg:    cfg,
+		topics: make(map[string]*memoryTopic),
+		closed: make(chan struct{}),
+	}
+}
+
+// topic returns the named topic, creating it if needed. m.mu must be held.
+func (m *Memory) topic(name string) *memoryTopic {
+	t, ok := m.topics[name]
+	if !ok {
+		t = &memoryTopic{
+			groups: make(map[string]*memoryGroup),
+			notify: make(chan struct{}),
+		}
+		m.topics[name] = t
+	}
+
+	return t
+}
+
+func (m *Memory) isClosed() bool {
+	select {
+	case <-m.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m *Memory) Publish(ctx context.Context, topic string, msgs ...Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.isClosed() {
+		return ErrClosed
+	}
+
+	t := m.topic(topic)
+
+	for _, msg := range msgs {
+		msg.Topic = topic
+		msg.Partition = 0
+		msg.Offset = t.end()
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		t.messages = append(t.messages, msg)
+	}
+
+	m.expire(t, time.Now())
+
+	close(t.notify)
+	t.notify = make(chan struct{})
+
+	return nil
+}
+
+// expire drops the messages of t past the retention. m.mu must be held.
+func (m *Memory) expire(t *memoryTopic, now time.Time) {
+	drop := max(len(t.messages)-m.cfg.MaxMessages, 0)
+
+	if m.cfg.MaxAge > 0 {
+		for drop < len(t.messages) && now.Sub(t.messages[drop].Time) > m.cfg.MaxAge {
+			drop++
+		}
+	}
+
+	if drop == 0 {
+		return
+	}
+
+	// Clear the dropped messages so their data can be collected before the
+	// backing array is reallocated.
+	clear(t.messages[:drop])
+	t.messages = t.messages[drop:]
+	t.base += int64(drop)
+}
+
+// end returns the offset of the next message published to t.
+func (t *memoryTopic) end() int64 {
+	return t.base + int64(len(t.messages))
+}
+
+func (m *Memory) Subscribe(topic string, opts SubscribeOptions) (Subscription, error) {
+	if err := checkSubscribe(opts); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.isClosed() {
+		return nil, ErrClosed
+	}
+
+	t := m.topic(topic)
+
+	sub := &memorySubscription{
+		broker: m,
+		topic:  t,
+		closed: make(chan struct{}),
+	}
+
+	m.expire(t, time.Now())
+
+	start := opts.StartOffset
+	switch start {
+	case 0, FirstOffset:
+		start = t.base
+	case LastOffset:
+		start = t.end()
+	}
+
+	if !opts.StartTime.IsZero() {
+		start = t.base + int64(sort.Search(len(t.messages), func(i int) bool {
+			return !t.messages[i].Time.Before(opts.StartTime)
+		}))
+	}
+
+	if opts.Group == "" {
+		sub.next = start
+		return sub, nil
+	}
+
+	g, ok := t.groups[opts.Group]
+	if !ok {
+		g = &memoryGroup{next: start, committed: start}
+		t.groups[opts.Group] = g
+	}
+
+	// A group rejoining after all its members left resumes from its last
+	// committed offset, redelivering anything that was never committed.
+	if g.members == 0 {
+		g.next = g.committed
+	}
+	g.members++
+	sub.group = g
+
+	return sub, nil
+}
+
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.isClosed() {
+		close(m.closed)
+	}
+
+	return nil
+}
+
+type memorySubscription struct {
+	broker *Memory
+	topic  *memoryTopic
+	group  *memoryGroup
+	next   int64
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (s *memorySubscription) Fetch(ctx context.Context) (Message, error) {
+	for {
+		s.broker.mu.Lock()
+
+		cursor := &s.next
+		if s.group != nil {
+			cursor = &s.group.next
+		}
+
+		// Messages dropped by the retention are skipped.
+		if *cursor < s.topic.base {
+			*cursor = s.topic.base
+		}
+
+		if *cursor < s.topic.end() {
+			msg := s.topic.messages[*cursor-s.topic.base]
+			*cursor++
+			s.broker.mu.Unlock()
+			return msg, nil
+		}
+
+		notify := s.topic.notify
+		s.broker.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-s.closed:
+			return Message{}, ErrClosed
+		case <-s.broker.closed:
+			return Message{}, ErrClosed
+		}
+	}
+}
+
+func (s *memorySubscription) Commit(ctx context.Context, msgs ...Message) error {
+	if s.group == nil {
+		return nil
+	}
+
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	for _, msg := range msgs {
+		if msg.Offset+1 > s.group.committed {
+			s.group.committed = msg.Offset + 1
+		}
+	}
+
+	return nil
+}
+
+func (s *memorySubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+
+		if s.group != nil {
+			s.broker.mu.Lock()
+			s.group.members--
+			s.broker.mu.Unlock()
+		}
+	})
+
+	return nil
+}
@@ -0,0 +1,43 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryRetainsMaxMessages(t *testing.T) {
+	bus := NewMemory(MemoryConfig{MaxMessages: 2})
+	t.Cleanup(func() { bus.Close() })
+
+	behind := subscribe(t, bus, "orders", SubscribeOptions{})
+
+	publish(t, bus, "orders", "a", "b", "c")
+
+	// A subscriber behind the retention skips the dropped messages.
+	msgs := fetch(t, behind, 2)
+	if got := values(msgs); got != "[b c]" {
+		t.Fatalf("values = %s, want [b c]", got)
+	}
+	if msgs[0].Offset != 1 {
+		t.Errorf("offset = %d, want 1, offsets are kept across drops", msgs[0].Offset)
+	}
+
+	if got := values(fetch(t, subscribe(t, bus, "orders", SubscribeOptions{StartOffset: FirstOffset}), 2)); got != "[b c]" {
+		t.Fatalf("values from first = %s, want [b c]", got)
+	}
+}
+
+func TestMemoryRetainsMaxAge(t *testing.T) {
+	bus := NewMemory(MemoryConfig{MaxAge: time.Minute})
+	t.Cleanup(func() { bus.Close() })
+
+	old := Message{Value: []byte("old"), Time: time.Now().Add(-time.Hour)}
+	if err := bus.Publish(context.Background(), "orders", old, Message{Value: []byte("new")}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	if got := values(fetch(t, subscribe(t, bus, "orders", SubscribeOptions{}), 1)); got != "[new]" {
+		t.Fatalf("values = %s, want [new]", got)
+	}
+}
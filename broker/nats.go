@@ -0,0 +1,469 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// keyHeader carries the message key, which NATS has no native field for.
+const keyHeader = "Rtd-Key"
+
+// fetchWait bounds a single pull request so Fetch can notice ctx cancellation.
+const fetchWait = 5 * time.Second
+
+// ErrNATSURLRequired is returned by NewNATS without a URL, unless an
+// embedded server is asked for.
+var ErrNATSURLRequired = errors.New("broker: nats url is required")
+
+// NATSConfig configures a NATS JetStream broker.
+type NATSConfig struct {
+	// URL of the NATS server. Required unless Embedded is set.
+	URL string
+	// Embedded starts an embedded server when URL is empty, such as for the
+	// single process sandbox. Standalone services require a URL instead, so
+	// they don't each end up with a private server.
+	Embedded bool
+	// Listen is the host:port the embedded server accepts clients on, so a
+	// sidecar-less setup can still share it between processes. When empty the
+	// embedded server is only reachable in-process.
+	Listen string
+	// StoreDir is where the embedded server keeps JetStream data.
+	StoreDir string
+	// Stream holding every topic. Defaults to "RTD".
+	Stream string
+	// SubjectPrefix is prepended to topic names to build subjects, e.g.
+	// "rtd" maps the control topic to "rtd.control". Defaults to "rtd".
+	SubjectPrefix string
+	// MaxAge and MaxBytes limit the stream, dropping the oldest messages
+	// first. They default to 7 days and 1GiB, and are applied when the
+	// stream is created or found without limits.
+	MaxAge   time.Duration
+	MaxBytes int64
+	// AckWait is how long a group's message may stay uncommitted before it
+	// is redelivered, 30s by default. Fetched messages awaiting a commit are
+	// kept alive meanwhile, so only those of a lost subscriber come back.
+	AckWait time.Duration
+	// MaxAckPending bounds the messages a group has fetched and not yet
+	// committed, after which fetching waits for commits. Defaults to 1000.
+	MaxAckPending int
+}
+
+// NATS is a Broker backed by NATS JetStream. Topics map to subjects of a
+// single stream, groups map to durable pull consumers.
+type NATS struct {
+	cfg    NATSConfig
+	server *server.Server
+	conn   *nats.Conn
+	js     nats.JetStreamContext
+}
+
+// NewNATS connects to the configured NATS server, or starts an embedded one,
+// and makes sure the stream exists.
+func NewNATS(cfg NATSConfig) (*NATS, error) {
+	if cfg.Stream == "" {
+		cfg.Stream = "RTD"
+	}
+	if cfg.SubjectPrefix == "" {
+		cfg.SubjectPrefix = "rtd"
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = 7 * 24 * time.Hour
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = 1 << 30
+	}
+	if cfg.AckWait <= 0 {
+		cfg.AckWait = 30 * time.Second
+	}
+	if cfg.MaxAckPending <= 0 {
+		cfg.MaxAckPending = 1000
+	}
+
+	if cfg.URL == "" && !cfg.Embedded {
+		return nil, ErrNATSURLRequired
+	}
+
+	n := &NATS{cfg: cfg}
+
+	url := cfg.URL
+	var opts []nats.Option
+
+	if url == "" {
+		ns, err := startEmbeddedNATS(cfg)
+		if err != nil {
+			return nil, err
+		}
+		n.server = ns
+
+		if cfg.Listen == "" {
+			opts = append(opts, nats.InProcessServer(ns))
+		} else {
+			url = ns.ClientURL()
+		}
+	}
+
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		n.Close()
+		return nil, err
+	}
+	n.conn = conn
+
+	js, err := conn.JetStream()
+	if err != nil {
+		n.Close()
+		return nil, err
+	}
+	n.js = js
+
+	if err := n.ensureStream(); err != nil {
+		n.Close()
+		return nil, err
+	}
+
+	return n, nil
+}
+
+func startEmbeddedNATS(cfg NATSConfig) (*server.Server, error) {
+	opts := &server.Options{
+		JetStream:  true,
+		StoreDir:   cfg.StoreDir,
+		DontListen: cfg.Listen == "",
+		NoSigs:     true,
+	}
+
+	if cfg.Listen != "" {
+		host, port, err := splitHostPort(cfg.Listen)
+		if err != nil {
+			return nil, err
+		}
+		opts.Host, opts.Port = host, port
+	}
+
+	ns, err := server.NewServer(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	go ns.Start()
+
+	if !ns.ReadyForConnections(10 * time.Second) {
+		ns.Shutdown()
+		return nil, errors.New("broker: embedded nats server not ready")
+	}
+
+	return ns, nil
+}
+
+func splitHostPort(address string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, fmt.Errorf("broker: invalid listen address %q: %w", address, err)
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, fmt.Errorf("broker: invalid listen port %q", portStr)
+	}
+
+	return host, port, nil
+}
+
+// ensureStream creates the stream, or adds the retention limits to an
+// existing stream without any. Limits set otherwise, such as by an operator,
+// are left alone.
+func (n *NATS) ensureStream() error {
+	info, err := n.js.StreamInfo(n.cfg.Stream)
+	if err == nil {
+		if info.Config.MaxAge > 0 || info.Config.MaxBytes > 0 {
+			return nil
+		}
+
+		cfg := info.Config
+		cfg.MaxAge, cfg.MaxBytes = n.cfg.MaxAge, n.cfg.MaxBytes
+		_, err = n.js.UpdateStream(&cfg)
+
+		return err
+	}
+
+	if !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+
+	_, err = n.js.AddStream(&nats.StreamConfig{
+		Name:     n.cfg.Stream,
+		Subjects: []string{n.cfg.SubjectPrefix + ".>"},
+		Storage:  nats.FileStorage,
+		MaxAge:   n.cfg.MaxAge,
+		MaxBytes: n.cfg.MaxBytes,
+		Discard:  nats.DiscardOld,
+	})
+
+	return err
+}
+
+func (n *NATS) subject(topic string) string {
+	return n.cfg.SubjectPrefix + "." + topic
+}
+
+func (n *NATS) Publish(ctx context.Context, topic string, msgs ...Message) error {
+	for _, msg := range msgs {
+		nmsg := nats.NewMsg(n.subject(topic))
+		nmsg.Data = msg.Value
+
+		for _, h := range msg.Headers {
+			nmsg.Header.Add(h.Key, string(h.Value))
+		}
+
+		if len(msg.Key) > 0 {
+			nmsg.Header.Set(keyHeader, string(msg.Key))
+		}
+
+		if _, err := n.js.PublishMsg(nmsg, nats.Context(ctx)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *NATS) Subscribe(topic string, opts SubscribeOptions) (Subscription, error) {
+	if err := checkSubscribe(opts); err != nil {
+		return nil, err
+	}
+
+	cfg := nats.ConsumerConfig{
+		FilterSubject: n.subject(topic),
+		AckPolicy:     nats.AckNonePolicy,
+		DeliverPolicy: nats.DeliverAllPolicy,
+	}
+
+	switch {
+	case !opts.StartTime.IsZero():
+		cfg.DeliverPolicy = nats.DeliverByStartTimePolicy
+		cfg.OptStartTime = &opts.StartTime
+	case opts.StartOffset == LastOffset:
+		cfg.DeliverPolicy = nats.DeliverNewPolicy
+	case opts.StartOffset > 0:
+		cfg.DeliverPolicy = nats.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = uint64(opts.StartOffset)
+	}
+
+	if opts.Group == "" {
+		sub, err := n.js.PullSubscribe(cfg.FilterSubject, "", nats.BindStream(n.cfg.Stream), deliverOpt(cfg), nats.AckNone())
+		if err != nil {
+			return nil, err
+		}
+
+		return &natsSubscription{topic: topic, sub: sub, pending: make(map[int64]*nats.Msg)}, nil
+	}
+
+	// Durable consumers are created up front rather than by PullSubscribe,
+	// since the client deletes consumers it created when the subscription
+	// is closed, which would lose the group's position.
+	cfg.Durable = durableName(opts.Group, topic)
+	cfg.AckPolicy = nats.AckExplicitPolicy
+	cfg.AckWait = n.cfg.AckWait
+	cfg.MaxAckPending = n.cfg.MaxAckPending
+
+	info, err := n.js.ConsumerInfo(n.cfg.Stream, cfg.Durable)
+	switch {
+	case errors.Is(err, nats.ErrConsumerNotFound):
+		if _, err := n.js.AddConsumer(n.cfg.Stream, &cfg); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case info.Config.AckWait != cfg.AckWait || info.Config.MaxAckPending != cfg.MaxAckPending:
+		// Consumers created before these were set, or with other values,
+		// are brought in line.
+		updated := info.Config
+		updated.AckWait, updated.MaxAckPending = cfg.AckWait, cfg.MaxAckPending
+		if _, err := n.js.UpdateConsumer(n.cfg.Stream, &updated); err != nil {
+			return nil, err
+		}
+	}
+
+	sub, err := n.js.PullSubscribe(cfg.FilterSubject, cfg.Durable, nats.Bind(n.cfg.Stream, cfg.Durable))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &natsSubscription{topic: topic, sub: sub, grouped: true, pending: make(map[int64]*nats.Msg), done: make(chan struct{})}
+	go s.keepAlive(max(n.cfg.AckWait/3, 10*time.Millisecond))
+
+	return s, nil
+}
+
+func deliverOpt(cfg nats.ConsumerConfig) nats.SubOpt {
+	switch cfg.DeliverPolicy {
+	case nats.DeliverNewPolicy:
+		return nats.DeliverNew()
+	case nats.DeliverByStartSequencePolicy:
+		return nats.StartSequence(cfg.OptStartSeq)
+	case nats.DeliverByStartTimePolicy:
+		return nats.StartTime(*cfg.OptStartTime)
+	default:
+		return nats.DeliverAll()
+	}
+}
+
+// durableName builds a valid durable consumer name for a group on a topic.
+func durableName(group, topic string) string {
+	return strings.NewReplacer(".", "_", "*", "_", ">", "_", " ", "_").Replace(group + "-" + topic)
+}
+
+func (n *NATS) Close() error {
+	if n.conn != nil {
+		n.conn.Close()
+	}
+
+	if n.server != nil {
+		n.server.Shutdown()
+		n.server.WaitForShutdown()
+	}
+
+	return nil
+}
+
+type natsSubscription struct {
+	topic   string
+	sub     *nats.Subscription
+	grouped bool
+
+	mu      sync.Mutex
+	pending map[int64]*nats.Msg
+	// done stops keepAlive once closed.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (s *natsSubscription) Fetch(ctx context.Context) (Message, error) {
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, fetchWait)
+		msgs, err := s.sub.Fetch(1, nats.Context(fetchCtx))
+		cancel()
+
+		if ctx.Err() != nil {
+			return Message{}, ctx.Err()
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, nats.ErrTimeout) {
+			continue
+		}
+
+		if errors.Is(err, nats.ErrConnectionClosed) || errors.Is(err, nats.ErrBadSubscription) {
+			return Message{}, ErrClosed
+		}
+
+		if err != nil {
+			return Message{}, err
+		}
+
+		if len(msgs) == 0 {
+			continue
+		}
+
+		return s.toMessage(msgs[0])
+	}
+}
+
+func (s *natsSubscription) toMessage(nmsg *nats.Msg) (Message, error) {
+	meta, err := nmsg.Metadata()
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{
+		Topic:  s.topic,
+		Value:  nmsg.Data,
+		Offset: int64(meta.Sequence.Stream),
+		Time:   meta.Timestamp,
+	}
+
+	for key, values := range nmsg.Header {
+		if key == keyHeader {
+			msg.Key = []byte(nmsg.Header.Get(keyHeader))
+			continue
+		}
+
+		for _, value := range values {
+			msg.Headers = append(msg.Headers, Header{Key: key, Value: []byte(value)})
+		}
+	}
+
+	if s.grouped {
+		s.mu.Lock()
+		s.pending[msg.Offset] = nmsg
+		s.mu.Unlock()
+	}
+
+	return msg, nil
+}
+
+func (s *natsSubscription) Commit(ctx context.Context, msgs ...Message) error {
+	if !s.grouped {
+		return nil
+	}
+
+	for _, msg := range msgs {
+		s.mu.Lock()
+		nmsg, ok := s.pending[msg.Offset]
+		delete(s.pending, msg.Offset)
+		s.mu.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		if err := nmsg.AckSync(nats.Context(ctx)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// keepAlive tells the server the fetched messages are still in progress
+// every interval, so those waiting behind a slow or failed one to be
+// committed aren't redelivered after AckWait.
+func (s *natsSubscription) keepAlive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+
+		s.mu.Lock()
+		pending := make([]*nats.Msg, 0, len(s.pending))
+		for _, nmsg := range s.pending {
+			pending = append(pending, nmsg)
+		}
+		s.mu.Unlock()
+
+		for _, nmsg := range pending {
+			nmsg.InProgress()
+		}
+	}
+}
+
+func (s *natsSubscription) Close() error {
+	if s.done != nil {
+		s.closeOnce.Do(func() { close(s.done) })
+	}
+
+	return s.sub.Unsubscribe()
+}
@@ -0,0 +1,142 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNATSRequiresURL(t *testing.T) {
+	if _, err := NewNATS(NATSConfig{}); !errors.Is(err, ErrNATSURLRequired) {
+		t.Fatalf("NewNATS without a URL: %v, want ErrNATSURLRequired", err)
+	}
+}
+
+func TestNATSStreamLimits(t *testing.T) {
+	n, err := NewNATS(NATSConfig{Embedded: true, StoreDir: t.TempDir(), MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("NewNATS: %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	info, err := n.js.StreamInfo(n.cfg.Stream)
+	if err != nil {
+		t.Fatalf("StreamInfo: %v", err)
+	}
+	if info.Config.MaxAge != time.Hour || info.Config.MaxBytes != 1<<30 {
+		t.Errorf("created with max age %s and max bytes %d, want 1h and 1GiB", info.Config.MaxAge, info.Config.MaxBytes)
+	}
+
+	// A stream created without limits gets them.
+	unlimited := info.Config
+	unlimited.MaxAge, unlimited.MaxBytes = 0, -1
+	if _, err := n.js.UpdateStream(&unlimited); err != nil {
+		t.Fatalf("UpdateStream: %v", err)
+	}
+
+	if err := n.ensureStream(); err != nil {
+		t.Fatalf("ensureStream: %v", err)
+	}
+
+	info, err = n.js.StreamInfo(n.cfg.Stream)
+	if err != nil {
+		t.Fatalf("StreamInfo: %v", err)
+	}
+	if info.Config.MaxAge != time.Hour {
+		t.Errorf("updated with max age %s, want 1h", info.Config.MaxAge)
+	}
+
+	// Limits set otherwise are kept.
+	custom := info.Config
+	custom.MaxAge = 2 * time.Hour
+	if _, err := n.js.UpdateStream(&custom); err != nil {
+		t.Fatalf("UpdateStream: %v", err)
+	}
+
+	if err := n.ensureStream(); err != nil {
+		t.Fatalf("ensureStream: %v", err)
+	}
+
+	if info, _ = n.js.StreamInfo(n.cfg.Stream); info.Config.MaxAge != 2*time.Hour {
+		t.Errorf("max age %s, want the 2h set by the operator", info.Config.MaxAge)
+	}
+}
+
+func newTestNATS(t *testing.T, cfg NATSConfig) *NATS {
+	t.Helper()
+
+	cfg.Embedded, cfg.StoreDir = true, t.TempDir()
+
+	n, err := NewNATS(cfg)
+	if err != nil {
+		t.Fatalf("NewNATS: %v", err)
+	}
+	t.Cleanup(func() { n.Close() })
+
+	return n
+}
+
+func TestNATSKeepsPendingMessages(t *testing.T) {
+	n := newTestNATS(t, NATSConfig{AckWait: 300 * time.Millisecond})
+
+	publish(t, n, "orders", "a", "b")
+
+	sub, err := n.Subscribe("orders", SubscribeOptions{Group: "billing"})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	// b is done but a, held back behind it, isn't redelivered past the
+	// ack wait while the subscriber is alive.
+	msgs := fetch(t, sub, 2)
+	if err := sub.Commit(context.Background(), msgs[1]); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	time.Sleep(time.Second)
+	expectNothing(t, sub)
+
+	// Once the subscriber is gone, a is redelivered to the group.
+	sub.Close()
+
+	sub = subscribe(t, n, "orders", SubscribeOptions{Group: "billing"})
+	if got := values(fetch(t, sub, 1)); got != "[a]" {
+		t.Fatalf("values after rejoining = %s, want [a]", got)
+	}
+}
+
+func TestNATSBoundsPendingMessages(t *testing.T) {
+	n := newTestNATS(t, NATSConfig{MaxAckPending: 2})
+
+	publish(t, n, "orders", "a", "b", "c")
+
+	sub := subscribe(t, n, "orders", SubscribeOptions{Group: "billing"})
+
+	msgs := fetch(t, sub, 2)
+	expectNothing(t, sub)
+
+	if err := sub.Commit(context.Background(), msgs[0]); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	if got := values(fetch(t, sub, 1)); got != "[c]" {
+		t.Fatalf("values after a commit = %s, want [c]", got)
+	}
+}
+
+func TestNATSUpdatesConsumerLimits(t *testing.T) {
+	n := newTestNATS(t, NATSConfig{})
+
+	subscribe(t, n, "orders", SubscribeOptions{Group: "billing"})
+
+	n.cfg.AckWait, n.cfg.MaxAckPending = time.Minute, 10
+	subscribe(t, n, "orders", SubscribeOptions{Group: "billing"})
+
+	info, err := n.js.ConsumerInfo(n.cfg.Stream, durableName("billing", "orders"))
+	if err != nil {
+		t.Fatalf("ConsumerInfo: %v", err)
+	}
+	if info.Config.AckWait != time.Minute || info.Config.MaxAckPending != 10 {
+		t.Errorf("ack wait %s and max ack pending %d, want 1m and 10", info.Config.AckWait, info.Config.MaxAckPending)
+	}
+}
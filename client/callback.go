@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+)
+
+// Callback is the monitor calling back for the decision on a request.
+type Callback struct {
+	CorrelationID string
+	ExecutionID   string
+}
+
+// Decision is what a DecideFunc decided for a callback.
+type Decision int
+
+const (
+	// Commit commits the request and answers the callback once done.
+	Commit Decision = iota + 1
+	// Cancel cancels the request and answers the callback once done.
+	Cancel
+	// Later answers the callback with 202 Accepted. The decision is sent
+	// afterwards with Client.Commit or Client.Cancel.
+	Later
+	// Decided answers the callback with 200 OK: the DecideFunc sent the
+	// decision itself.
+	Decided
+)
+
+// DecideFunc decides on a callback. An error, a CallbackError for a
+// specific status code, answers the callback without a decision.
+type DecideFunc func(ctx context.Context, cb Callback) (Decision, error)
+
+// CallbackHandler returns the handler for CallbackURL. It checks the
+// callback carries its IDs, asks decide, and sends the decision. The IDs
+// and trace ID of the callback are carried by the context passed to decide.
+func (c *Client) CallbackHandler(decide DecideFunc) http.Handler {
+	return logger.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cb := Callback{
+			CorrelationID: r.URL.Query().Get("correlation_id"),
+			ExecutionID:   r.URL.Query().Get("execution_id"),
+		}
+
+		if cb.CorrelationID == "" || cb.ExecutionID == "" {
+			c.log(r.Context(), "error", fmt.Sprintf("Rejected callback: %v", ErrMissingID), ErrMissingID)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		decision, err := decide(r.Context(), cb)
+		if err != nil {
+			var callbackErr *CallbackError
+			if errors.As(err, &callbackErr) {
+				w.WriteHeader(callbackErr.StatusCode)
+				return
+			}
+
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		switch decision {
+		case Commit, Cancel:
+			if err := c.Decide(r.Context(), cb.CorrelationID, cb.ExecutionID, decision == Commit); err != nil {
+				c.log(r.Context(), "error", fmt.Sprintf("error sending decision to forwarder: %v", err), err)
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case Later:
+			w.WriteHeader(http.StatusAccepted)
+		case Decided:
+			w.WriteHeader(http.StatusOK)
+		default:
+			c.log(r.Context(), "error", fmt.Sprintf("unknown decision %d", decision), nil)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+}
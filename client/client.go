@@ -0,0 +1,232 @@
+// Package client lets services submit data requests to the forwarder and
+// commit or cancel them when the monitor calls back, without reimplementing
+// the HTTP contract.
+//
+//	c := client.New(client.Config{
+//		ForwarderURL: "http://forwarder:3000",
+//		ServiceName:  "payments",
+//		CallbackURL:  "http://payments:8888/callback",
+//	})
+//
+//	http.Handle("/callback", c.CallbackHandler(func(ctx context.Context, cb client.Callback) (client.Decision, error) {
+//		return client.Commit, nil
+//	}))
+//
+//	receipt, err := c.Submit(ctx, shared.DataRequest{UserID: "42"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+	"github.com/google/uuid"
+)
+
+// Config configures a Client.
+type Config struct {
+	// ForwarderURL is the base URL of the forwarder.
+	ForwarderURL string
+	// ServiceName identifies the service in its data requests.
+	ServiceName string
+	// CallbackURL is where the monitor calls back for decisions. It must
+	// route to CallbackHandler.
+	CallbackURL string
+	// Timeout bounds each request to the forwarder, 10s by default.
+	Timeout time.Duration
+	// MaxAttempts is how many times a request is sent before giving up, 3
+	// by default. Only errors for which Retryable is true are retried.
+	MaxAttempts int
+	// RetryBackoff is the wait before the first retry, doubling after each
+	// one, 200ms by default.
+	RetryBackoff time.Duration
+	// HTTPClient sends the requests, a client with Timeout by default.
+	HTTPClient *http.Client
+	// CustomLogger, when set, logs responses, retries and rejected callbacks.
+	CustomLogger *logger.CustomLogger
+}
+
+// Client talks to the forwarder on behalf of one service.
+type Client struct {
+	cfg  Config
+	http *http.Client
+}
+
+func New(cfg Config) *Client {
+	cfg.ForwarderURL = strings.TrimSuffix(cfg.ForwarderURL, "/")
+
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 3
+	}
+
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 200 * time.Millisecond
+	}
+
+	httpClient := cfg.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	return &Client{cfg: cfg, http: httpClient}
+}
+
+// Receipt identifies a submitted data request.
+type Receipt struct {
+	ExecutionID   string `json:"execution_id"`
+	CorrelationID string `json:"correlation_id"`
+}
+
+// Submit sends a data request to the forwarder. The service name, callback
+// URL, execution ID and timestamp are filled in when empty.
+func (c *Client) Submit(ctx context.Context, req shared.DataRequest) (Receipt, error) {
+	if req.ServiceName == "" {
+		req.ServiceName = c.cfg.ServiceName
+	}
+
+	if req.Callback == "" {
+		req.Callback = c.cfg.CallbackURL
+	}
+
+	if req.ExecutionID == "" {
+		req.ExecutionID = uuid.NewString()
+	}
+
+	if req.Timestamp.IsZero() {
+		req.Timestamp = time.Now()
+	}
+
+	receipt := Receipt{ExecutionID: req.ExecutionID}
+
+	ctx = logger.WithIDs(ctx, logger.IDs{ExecutionID: req.ExecutionID})
+
+	var dataRes shared.DataResponse
+	if err := c.post(ctx, "/request", req, &dataRes); err != nil {
+		return receipt, err
+	}
+
+	receipt.CorrelationID = dataRes.CorrelationID
+
+	return receipt, nil
+}
+
+// Commit tells the forwarder to publish the event of a request.
+func (c *Client) Commit(ctx context.Context, correlationID, executionID string) error {
+	return c.Decide(ctx, correlationID, executionID, true)
+}
+
+// Cancel tells the forwarder to drop a request.
+func (c *Client) Cancel(ctx context.Context, correlationID, executionID string) error {
+	return c.Decide(ctx, correlationID, executionID, false)
+}
+
+// Decide commits or cancels a request.
+func (c *Client) Decide(ctx context.Context, correlationID, executionID string, commit bool) error {
+	ctx = logger.WithIDs(ctx, logger.IDs{CorrelationID: correlationID, ExecutionID: executionID})
+
+	return c.post(ctx, "/commit", shared.CommitRequest{
+		CorrelationID: correlationID,
+		ExecutionID:   executionID,
+		OriginService: c.cfg.ServiceName,
+		Commit:        commit,
+	}, nil)
+}
+
+// post sends v as JSON to path on the forwarder, retrying with backoff, and
+// decodes the response into out when not nil. The IDs carried by ctx are
+// sent along.
+func (c *Client) post(ctx context.Context, path string, v, out interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	backoff := c.cfg.RetryBackoff
+
+	for attempt := 1; ; attempt++ {
+		err = c.postOnce(ctx, path, body, out)
+		if err == nil || !Retryable(err) || attempt >= c.cfg.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		c.log(ctx, "forwarder", fmt.Sprintf("Attempt %d to %s failed, retrying in %s: %v", attempt, path, backoff, err), err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+// postOnce sends body to path on the forwarder once, with a StatusError for
+// a non-2xx status.
+func (c *Client) postOnce(ctx context.Context, path string, body []byte, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.cfg.ForwarderURL+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	logger.SetHTTPHeaders(ctx, req.Header)
+
+	// Retried data requests reuse their execution ID as idempotency key, so
+	// a request that timed out after reaching the forwarder isn't started
+	// twice.
+	if executionID := logger.IDsFrom(ctx).ExecutionID; path == "/request" && executionID != "" {
+		req.Header.Set(shared.HTTPHeaderIdempotencyKey, executionID)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		c.log(ctx, "forwarder", fmt.Sprintf("Response code from forwarder: %s", res.Status), nil)
+
+		message, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+
+		return &StatusError{Path: path, StatusCode: res.StatusCode, Message: strings.TrimSpace(string(message))}
+	}
+
+	if out != nil {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+
+		if dataRes, ok := out.(*shared.DataResponse); ok {
+			ctx = logger.WithIDs(ctx, logger.IDs{CorrelationID: dataRes.CorrelationID})
+		}
+	}
+
+	c.log(ctx, "forwarder", fmt.Sprintf("Response code from forwarder: %s", res.Status), nil)
+
+	return nil
+}
+
+// PostOnce sends an already encoded request body to path on the forwarder
+// once, without retrying, such as a request saved while the forwarder was
+// unreachable. The response is decoded into out when not nil.
+func (c *Client) PostOnce(ctx context.Context, path string, body []byte, out interface{}) error {
+	return c.postOnce(ctx, path, body, out)
+}
+
+func (c *Client) log(ctx context.Context, target, msg string, err error) {
+	if c.cfg.CustomLogger != nil {
+		c.cfg.CustomLogger.LogContext(ctx, target, msg, err)
+	}
+}
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+func TestSubmitRetriesWithIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	var keys []string
+
+	forwarder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		keys = append(keys, r.Header.Get(shared.HTTPHeaderIdempotencyKey))
+		attempt := len(keys)
+		mu.Unlock()
+
+		if attempt == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		json.NewEncoder(w).Encode(shared.DataResponse{Status: "OK", CorrelationID: "corr-1"})
+	}))
+	t.Cleanup(forwarder.Close)
+
+	c := New(Config{ForwarderURL: forwarder.URL, RetryBackoff: time.Millisecond})
+
+	receipt, err := c.Submit(context.Background(), shared.DataRequest{ExecutionID: "exec-1"})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+
+	if receipt.CorrelationID != "corr-1" {
+		t.Errorf("correlation ID = %q, want corr-1", receipt.CorrelationID)
+	}
+
+	if len(keys) != 2 || keys[0] != "exec-1" || keys[1] != "exec-1" {
+		t.Errorf("idempotency keys %v, want exec-1 on both attempts", keys)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(release) })
+
+	secure := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	secure.Config.ErrorLog = log.New(io.Discard, "", 0)
+	secure.StartTLS()
+	t.Cleanup(secure.Close)
+
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// send returns the error of a single data request to url.
+	send := func(ctx context.Context, url string) error {
+		c := New(Config{ForwarderURL: url, Timeout: 100 * time.Millisecond})
+		return c.postOnce(ctx, "/request", []byte("{}"), nil)
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", &StatusError{StatusCode: http.StatusServiceUnavailable}, true},
+		{"too many requests", &StatusError{StatusCode: http.StatusTooManyRequests}, true},
+		{"bad request", &StatusError{StatusCode: http.StatusBadRequest}, false},
+		{"timeout", send(context.Background(), slow.URL), true},
+		{"connection refused", send(context.Background(), closed.URL), true},
+		{"cancelled", send(cancelled, slow.URL), false},
+		{"untrusted certificate", send(context.Background(), secure.URL), false},
+		{"unsupported scheme", send(context.Background(), "ftp://forwarder"), false},
+		{"invalid url", send(context.Background(), "http://forwarder:port"), false},
+	}
+
+	for _, test := range tests {
+		if test.err == nil {
+			t.Errorf("%s: no error", test.name)
+			continue
+		}
+		if got := Retryable(test.err); got != test.want {
+			t.Errorf("%s: Retryable(%v) = %v, want %v", test.name, test.err, got, test.want)
+		}
+	}
+}
+
+func TestSubmitStopsOnNonRetryableErrors(t *testing.T) {
+	var mu sync.Mutex
+	attempts := 0
+
+	forwarder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		attempts++
+		mu.Unlock()
+
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	t.Cleanup(forwarder.Close)
+
+	c := New(Config{ForwarderURL: forwarder.URL, RetryBackoff: time.Millisecond})
+
+	if _, err := c.Submit(context.Background(), shared.DataRequest{ExecutionID: "exec-1"}); err == nil {
+		t.Fatal("Submit succeeded")
+	}
+
+	if attempts != 1 {
+		t.Errorf("%d attempts, want 1", attempts)
+	}
+}
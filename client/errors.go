@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+)
+
+// ErrMissingID is returned for a callback without a correlation or
+// execution ID.
+var ErrMissingID = errors.New("client: callback without correlation or execution id")
+
+// StatusError is returned when the forwarder answers with a non-2xx status.
+type StatusError struct {
+	Path       string
+	StatusCode int
+	// Message is the start of the response body, if any.
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("forwarder answered %d %s to %s", e.StatusCode, http.StatusText(e.StatusCode), e.Path)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// HTTPStatus returns the status code, for the logger.
+func (e *StatusError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// Retryable reports whether sending the request again may succeed: the
+// forwarder timed out, failed, asked to slow down or couldn't be reached.
+// Cancelled requests, invalid URLs and TLS failures are not retried. Errors
+// once the request's own context is done are never retried by the client,
+// whatever Retryable says.
+func Retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	// Refused, reset or dropped connections, such as while the forwarder
+	// restarts.
+	var opErr *net.OpError
+	return errors.As(err, &opErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// CallbackError is returned by a DecideFunc to answer the callback with a
+// specific status code. Other errors are answered with 500.
+type CallbackError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *CallbackError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CallbackError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatus returns the status code, for the logger.
+func (e *CallbackError) HTTPStatus() int {
+	return e.StatusCode
+}
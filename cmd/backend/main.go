@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/shared"
+	"github.com/assimoes/rtd-sandbox/web/backend"
+)
+
+var (
+	mongoURI       = shared.GetEnv("MONGO_URI", "mongodb://localhost:27017")
+	dbName         = shared.GetEnv("MONGO_DB", "logsdb")
+	collectionName = shared.GetEnv("MONGO_COLLECTION", "logs")
+	staticDir      = shared.GetEnv("STATIC_DIR", "web/frontend/public")
+	listenAddress  = shared.GetEnv("LISTEN_ADDRESS", ":3000")
+	// brokerKind, when set, has the log lines of the services' broker sinks
+	// consumed from logTopic.
+	brokerKind    = shared.GetEnv("BROKER_KIND", "")
+	brokerAddress = shared.GetEnv("KAFKA_BROKER", "localhost:9092")
+	natsURL       = shared.GetEnv("NATS_URL", "")
+	logTopic      = shared.GetEnv("LOG_TOPIC", "logs")
+	consumerGroup = shared.GetEnv("CONSUMER_GROUP", "backend")
+)
+
+func main() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	store, err := backend.NewMongoStore(ctx, mongoURI, dbName, collectionName)
+	if err != nil {
+		log.Fatalf("error creating mongodb client: %v", err)
+	}
+	defer store.Close(ctx)
+
+	if brokerKind != "" {
+		bus, err := broker.New(broker.Config{
+			Kind:         brokerKind,
+			KafkaAddress: brokerAddress,
+			NATS:         broker.NATSConfig{URL: natsURL},
+		})
+		if err != nil {
+			log.Fatalf("error creating broker: %v", err)
+		}
+		defer bus.Close()
+
+		go func() {
+			if err := backend.NewLogConsumer(bus, logTopic, consumerGroup, store).Run(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("error consuming logs: %v", err)
+			}
+		}()
+	}
+
+	app := backend.New(store, staticDir)
+
+	// Starts the server
+	log.Fatal(app.Listen(listenAddress))
+}
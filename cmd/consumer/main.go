@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/blob"
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/consumer"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+var (
+	brokerKind      = shared.GetEnv("BROKER_KIND", broker.KindKafka)
+	brokerAddress   = shared.GetEnv("KAFKA_BROKER", "localhost:9099")
+	natsURL         = shared.GetEnv("NATS_URL", "")
+	friendlyName    = shared.GetEnv("FRIENDLY_NAME", "consumer_a")
+	consumerGroup   = shared.GetEnv("CONSUMER_GROUP", friendlyName)
+	eventTopic      = shared.GetEnv("EVENT_TOPIC", "e_topic")
+	concurrency     = shared.GetEnv("CONSUMER_CONCURRENCY", "1")
+	maxAttempts     = shared.GetEnv("CONSUMER_MAX_ATTEMPTS", "3")
+	retryBackoff    = shared.GetEnv("CONSUMER_RETRY_BACKOFF", "500ms")
+	deadLetterTopic = shared.GetEnv("DEAD_LETTER_TOPIC", "")
+	maxPending      = shared.GetEnv("CONSUMER_MAX_PENDING", "10000")
+	ackTopic        = shared.GetEnv("ACK_TOPIC", "acks")
+	sinkKind        = shared.GetEnv("SINK", "")
+	mongoURI        = shared.GetEnv("MONGO_URI", "mongodb://localhost:27017")
+	mongoDB         = shared.GetEnv("MONGO_DB", "readmodel")
+	mongoCollection = shared.GetEnv("MONGO_COLLECTION", "correlations")
+	apiAddress      = shared.GetEnv("API_ADDRESS", ":8090")
+	blobKind        = shared.GetEnv("BLOB_STORE", "")
+	blobDir         = shared.GetEnv("BLOB_DIR", "/var/lib/rtd/blobs")
+	blobDB          = shared.GetEnv("BLOB_MONGO_DB", "blobs")
+	blobBucket      = shared.GetEnv("BLOB_BUCKET", "payloads")
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the service until it stops, returning the exit code. The sinks
+// are flushed by the deferred closes before the process exits.
+func run() int {
+
+	customLogger := logger.New(friendlyName)
+
+	workers, err := strconv.Atoi(concurrency)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid CONSUMER_CONCURRENCY: %v", err), err, "", "")
+		return 1
+	}
+
+	attempts, err := strconv.Atoi(maxAttempts)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid CONSUMER_MAX_ATTEMPTS: %v", err), err, "", "")
+		return 1
+	}
+
+	backoff, err := time.ParseDuration(retryBackoff)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid CONSUMER_RETRY_BACKOFF: %v", err), err, "", "")
+		return 1
+	}
+
+	pending, err := strconv.Atoi(maxPending)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid CONSUMER_MAX_PENDING: %v", err), err, "", "")
+		return 1
+	}
+
+	bus, err := broker.New(broker.Config{
+		Kind:         brokerKind,
+		KafkaAddress: brokerAddress,
+		NATS:         broker.NATSConfig{URL: natsURL},
+	})
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("error creating broker: %v", err), err, "", "")
+		return 1
+	}
+	defer bus.Close()
+
+	sinks, err := logger.SinksFromEnv(bus)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("error creating log sinks: %v", err), err, "", "")
+		return 1
+	}
+	defer sinks.Close()
+	customLogger.SetSinks(sinks...)
+
+	var sink consumer.Sink
+
+	switch sinkKind {
+	case "":
+	case "memory":
+		sink = consumer.NewMemorySink()
+	case "mongo":
+		mongoSink, err := consumer.NewMongoSink(context.Background(), mongoURI, mongoDB, mongoCollection)
+		if err != nil {
+			customLogger.Log("error", fmt.Sprintf("error connecting to mongo: %v", err), err, "", "")
+			return 1
+		}
+		defer mongoSink.Close(context.Background())
+		sink = mongoSink
+	default:
+		err := fmt.Errorf("unknown sink %q", sinkKind)
+		customLogger.Log("error", fmt.Sprintf("invalid SINK: %v", err), err, "", "")
+		return 1
+	}
+
+	var blobs blob.Store
+	if blobKind != "" {
+		blobs, err = blob.New(context.Background(), blob.Config{
+			Kind:     blobKind,
+			Dir:      blobDir,
+			MongoURI: mongoURI,
+			MongoDB:  blobDB,
+			Bucket:   blobBucket,
+		})
+		if err != nil {
+			customLogger.Log("error", fmt.Sprintf("error creating blob store: %v", err), err, "", "")
+			return 1
+		}
+		defer blobs.Close(context.Background())
+	}
+
+	c := consumer.New(consumer.Config{
+		FriendlyName:    friendlyName,
+		Group:           consumerGroup,
+		EventTopic:      eventTopic,
+		Handlers:        consumer.DefaultRegistry(customLogger),
+		Concurrency:     workers,
+		MaxAttempts:     attempts,
+		RetryBackoff:    backoff,
+		DeadLetterTopic: deadLetterTopic,
+		MaxPending:      pending,
+		AckTopic:        ackTopic,
+		Sink:            sink,
+		Blobs:           blobs,
+	}, bus, customLogger)
+
+	go func() {
+		if err := http.ListenAndServe(apiAddress, c.Handler()); err != nil {
+			customLogger.Log("error", fmt.Sprintf("api stopped: %v", err), err, "", "")
+		}
+	}()
+
+	if err := c.Run(context.Background()); err != nil {
+		customLogger.Log("error", fmt.Sprintf("consumer stopped: %v", err), err, "", "")
+		return 1
+	}
+
+	return 0
+}
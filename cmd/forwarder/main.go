@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/blob"
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/forwarder"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+var (
+	brokerKind    = shared.GetEnv("BROKER_KIND", broker.KindKafka)
+	brokerAddress = shared.GetEnv("KAFKA_BROKER", "localhost:9092")
+	natsURL       = shared.GetEnv("NATS_URL", "")
+	friendlyName  = shared.GetEnv("FRIENDLY_NAME", "forwarder")
+	consumerGroup = shared.GetEnv("CONSUMER_GROUP", "forwarder")
+	ackTopic      = shared.GetEnv("ACK_TOPIC", "acks")
+	maxPayload    = shared.GetEnv("MAX_PAYLOAD_BYTES", "262144")
+	maxAttributes = shared.GetEnv("MAX_ATTRIBUTES", "32")
+	blobKind      = shared.GetEnv("BLOB_STORE", "")
+	blobDir       = shared.GetEnv("BLOB_DIR", "/var/lib/rtd/blobs")
+	mongoURI      = shared.GetEnv("MONGO_URI", "mongodb://localhost:27017")
+	blobDB        = shared.GetEnv("BLOB_MONGO_DB", "blobs")
+	blobBucket    = shared.GetEnv("BLOB_BUCKET", "payloads")
+	offloadBytes  = shared.GetEnv("OFFLOAD_BYTES", "65536")
+	blobTTL       = shared.GetEnv("BLOB_RETENTION", "168h")
+	requestTTL    = shared.GetEnv("IDEMPOTENCY_TTL", "10m")
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the service until it stops, returning the exit code. The sinks
+// are flushed by the deferred closes before the process exits.
+func run() int {
+
+	customLogger := logger.New(friendlyName)
+
+	payloadLimit, err := strconv.Atoi(maxPayload)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid MAX_PAYLOAD_BYTES: %v", err), err, "", "")
+		return 1
+	}
+
+	attributeLimit, err := strconv.Atoi(maxAttributes)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid MAX_ATTRIBUTES: %v", err), err, "", "")
+		return 1
+	}
+
+	offloadLimit, err := strconv.Atoi(offloadBytes)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid OFFLOAD_BYTES: %v", err), err, "", "")
+		return 1
+	}
+
+	idempotencyTTL, err := time.ParseDuration(requestTTL)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid IDEMPOTENCY_TTL: %v", err), err, "", "")
+		return 1
+	}
+
+	blobRetention, err := time.ParseDuration(blobTTL)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid BLOB_RETENTION: %v", err), err, "", "")
+		return 1
+	}
+
+	// The blobs must outlive their executions, and the event topics.
+	if blobRetention < time.Minute {
+		customLogger.Log("error", fmt.Sprintf("invalid BLOB_RETENTION: %s is under a minute", blobRetention), nil, "", "")
+		return 1
+	}
+
+	var blobs blob.Store
+	if blobKind != "" {
+		blobs, err = blob.New(context.Background(), blob.Config{
+			Kind:     blobKind,
+			Dir:      blobDir,
+			MongoURI: mongoURI,
+			MongoDB:  blobDB,
+			Bucket:   blobBucket,
+		})
+		if err != nil {
+			customLogger.Log("error", fmt.Sprintf("error creating blob store: %v", err), err, "", "")
+			return 1
+		}
+		defer blobs.Close(context.Background())
+	}
+
+	bus, err := broker.New(broker.Config{
+		Kind:         brokerKind,
+		KafkaAddress: brokerAddress,
+		NATS:         broker.NATSConfig{URL: natsURL},
+	})
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("error creating broker: %v", err), err, "", "")
+		return 1
+	}
+	defer bus.Close()
+
+	sinks, err := logger.SinksFromEnv(bus)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("error creating log sinks: %v", err), err, "", "")
+		return 1
+	}
+	defer sinks.Close()
+	customLogger.SetSinks(sinks...)
+
+	f := forwarder.New(forwarder.Config{
+		FriendlyName:    friendlyName,
+		Group:           consumerGroup,
+		AckTopic:        ackTopic,
+		MaxPayloadBytes: payloadLimit,
+		MaxAttributes:   attributeLimit,
+		Blobs:           blobs,
+		OffloadBytes:    offloadLimit,
+		BlobRetention:   blobRetention,
+		IdempotencyTTL:  idempotencyTTL,
+	}, bus, customLogger)
+
+	go func() {
+		if err := f.Run(context.Background()); err != nil {
+			customLogger.Log("error", fmt.Sprintf("error consuming acks: %v", err), err, "", "")
+		}
+	}()
+
+	serverAddress := ":3000"
+
+	if err := http.ListenAndServe(serverAddress, f.Handler()); err != nil {
+		customLogger.Log("error", fmt.Sprintf("error starting forwarder: %v", err), err, "", "")
+	}
+
+	return 1
+}
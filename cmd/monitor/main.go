@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/monitor"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+var (
+	brokerKind        = shared.GetEnv("BROKER_KIND", broker.KindKafka)
+	brokerAddress     = shared.GetEnv("KAFKA_BROKER", "localhost:9092")
+	natsURL           = shared.GetEnv("NATS_URL", "")
+	friendlyName      = shared.GetEnv("FRIENDLY_NAME", "monitor")
+	consumerGroup     = shared.GetEnv("CONSUMER_GROUP", "monitor")
+	eventRoutes       = shared.GetEnv("EVENT_ROUTES", "")
+	defaultEventTopic = shared.GetEnv("EVENT_DEFAULT_TOPIC", "e_topic")
+	originTTL         = shared.GetEnv("ORIGIN_TTL", "10m")
+	maxOrigins        = shared.GetEnv("MAX_ORIGINS", "100000")
+	maxOriginBytes    = shared.GetEnv("MAX_ORIGIN_BYTES", "67108864")
+	callbackTimeout   = shared.GetEnv("CALLBACK_TIMEOUT", "10s")
+	callbackWorkers   = shared.GetEnv("CALLBACK_CONCURRENCY", "16")
+	apiAddress        = shared.GetEnv("API_ADDRESS", ":8091")
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the service until it stops, returning the exit code. The sinks
+// are flushed by the deferred closes before the process exits.
+func run() int {
+
+	customLogger := logger.New(friendlyName)
+
+	ttl, err := time.ParseDuration(originTTL)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid ORIGIN_TTL: %v", err), err, "", "")
+		return 1
+	}
+
+	originLimit, err := strconv.Atoi(maxOrigins)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid MAX_ORIGINS: %v", err), err, "", "")
+		return 1
+	}
+
+	originBytes, err := strconv.ParseInt(maxOriginBytes, 10, 64)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid MAX_ORIGIN_BYTES: %v", err), err, "", "")
+		return 1
+	}
+
+	timeout, err := time.ParseDuration(callbackTimeout)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid CALLBACK_TIMEOUT: %v", err), err, "", "")
+		return 1
+	}
+
+	workers, err := strconv.Atoi(callbackWorkers)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid CALLBACK_CONCURRENCY: %v", err), err, "", "")
+		return 1
+	}
+
+	bus, err := broker.New(broker.Config{
+		Kind:         brokerKind,
+		KafkaAddress: brokerAddress,
+		NATS:         broker.NATSConfig{URL: natsURL},
+	})
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("error creating broker: %v", err), err, "", "")
+		return 1
+	}
+	defer bus.Close()
+
+	sinks, err := logger.SinksFromEnv(bus)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("error creating log sinks: %v", err), err, "", "")
+		return 1
+	}
+	defer sinks.Close()
+	customLogger.SetSinks(sinks...)
+
+	m, err := monitor.New(monitor.Config{
+		FriendlyName:        friendlyName,
+		Group:               consumerGroup,
+		EventRoutes:         eventRoutes,
+		DefaultEventTopic:   defaultEventTopic,
+		OriginTTL:           ttl,
+		MaxOrigins:          originLimit,
+		MaxOriginBytes:      originBytes,
+		CallbackTimeout:     timeout,
+		CallbackConcurrency: workers,
+	}, bus, customLogger)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("error creating monitor: %v", err), err, "", "")
+		return 1
+	}
+
+	go func() {
+		if err := http.ListenAndServe(apiAddress, m.Handler()); err != nil {
+			customLogger.Log("error", fmt.Sprintf("api stopped: %v", err), err, "", "")
+		}
+	}()
+
+	if err := m.Run(context.Background()); err != nil {
+		customLogger.Log("error", fmt.Sprintf("monitor stopped: %v", err), err, "", "")
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/producer"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+var (
+	forwarderURL = shared.GetEnv("FORWARDER_URL", "http://localhost:3000")
+	externalName = shared.GetEnv("EXTERNAL_NAME", "localhost")
+	externalPort = shared.GetEnv("EXTERNAL_PORT", "8888")
+	friendlyName = shared.GetEnv("FRIENDLY_NAME", "producer_a")
+	interval     = shared.GetEnv("PRODUCER_INTERVAL", "5s")
+	scenarioFile = shared.GetEnv("SCENARIO_FILE", "")
+	// Setting PERSONAS_FILE hosts every persona it lists instead of a
+	// single EXTERNAL_NAME service.
+	personasFile   = shared.GetEnv("PERSONAS_FILE", "")
+	manualApproval = shared.GetEnv("MANUAL_APPROVAL", "false")
+	requestTTL     = shared.GetEnv("OUTSTANDING_TTL", "5m")
+	timeout        = shared.GetEnv("FORWARDER_TIMEOUT", "10s")
+	maxAttempts    = shared.GetEnv("FORWARDER_MAX_ATTEMPTS", "3")
+	retryBackoff   = shared.GetEnv("FORWARDER_RETRY_BACKOFF", "200ms")
+	spoolDir       = shared.GetEnv("SPOOL_DIR", "")
+	spoolSize      = shared.GetEnv("SPOOL_SIZE", "1000")
+
+	// Setting LOAD_RPS runs a single load test instead of the ticker, then
+	// prints its report and exits.
+	loadRPS           = shared.GetEnv("LOAD_RPS", "")
+	loadProfile       = shared.GetEnv("LOAD_PROFILE", producer.ProfileConstant)
+	loadConcurrency   = shared.GetEnv("LOAD_CONCURRENCY", "10")
+	loadDuration      = shared.GetEnv("LOAD_DURATION", "1m")
+	loadRampUp        = shared.GetEnv("LOAD_RAMP_UP", "0s")
+	loadSteps         = shared.GetEnv("LOAD_STEPS", "5")
+	loadSpikeRPS      = shared.GetEnv("LOAD_SPIKE_RPS", "0")
+	loadSpikeAt       = shared.GetEnv("LOAD_SPIKE_AT", "0s")
+	loadSpikeDuration = shared.GetEnv("LOAD_SPIKE_DURATION", "0s")
+	loadDrain         = shared.GetEnv("LOAD_DRAIN", "10s")
+	loadReport        = shared.GetEnv("LOAD_REPORT", "")
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// run runs the service until it stops, returning the exit code. The sinks
+// are flushed by the deferred closes before the process exits.
+func run() int {
+
+	// Initialize the custom logger with the friendly name.
+	customLogger := logger.New(friendlyName)
+
+	sinks, err := logger.SinksFromEnv(nil)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("error creating log sinks: %v", err), err, "", "")
+		return 1
+	}
+	defer sinks.Close()
+	customLogger.SetSinks(sinks...)
+
+	tick, err := time.ParseDuration(interval)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid PRODUCER_INTERVAL: %v", err), err, "", "")
+		return 1
+	}
+
+	ttl, err := time.ParseDuration(requestTTL)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid OUTSTANDING_TTL: %v", err), err, "", "")
+		return 1
+	}
+
+	requestTimeout, err := time.ParseDuration(timeout)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid FORWARDER_TIMEOUT: %v", err), err, "", "")
+		return 1
+	}
+
+	attempts, err := strconv.Atoi(maxAttempts)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid FORWARDER_MAX_ATTEMPTS: %v", err), err, "", "")
+		return 1
+	}
+
+	backoff, err := time.ParseDuration(retryBackoff)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid FORWARDER_RETRY_BACKOFF: %v", err), err, "", "")
+		return 1
+	}
+
+	spoolEntries, err := strconv.Atoi(spoolSize)
+	if err != nil {
+		customLogger.Log("error", fmt.Sprintf("invalid SPOOL_SIZE: %v", err), err, "", "")
+		return 1
+	}
+
+	var scenario *producer.Scenario
+	if scenarioFile != "" {
+		scenario, err = producer.LoadScenario(scenarioFile)
+		if err != nil {
+			customLogger.Log("error", fmt.Sprintf("invalid SCENARIO_FILE: %v", err), err, "", "")
+			return 1
+		}
+	}
+
+	if personasFile != "" {
+		personas, err := producer.LoadPersonas(personasFile)
+		if err != nil {
+			customLogger.Log("error", fmt.Sprintf("invalid PERSONAS_FILE: %v", err), err, "", "")
+			return 1
+		}
+
+		fleet, err := producer.NewFleet(producer.Config{
+			ForwarderURL:   forwarderURL,
+			CallbackURL:    fmt.Sprintf("http://%s:%s", externalName, externalPort),
+			Interval:       tick,
+			OutstandingTTL: ttl,
+			RequestTimeout: requestTimeout,
+			MaxAttempts:    attempts,
+			RetryBackoff:   backoff,
+			SpoolDir:       spoolDir,
+			SpoolSize:      spoolEntries,
+		}, personas, func(name string) *logger.CustomLogger {
+			personaLogger := logger.New(name)
+			personaLogger.SetSinks(sinks...)
+			return personaLogger
+		})
+		if err != nil {
+			customLogger.Log("error", fmt.Sprintf("error creating personas: %v", err), err, "", "")
+			return 1
+		}
+
+		go fleet.Run(context.Background())
+
+		err = http.ListenAndServe(":"+externalPort, fleet.Handler())
+		customLogger.Log("forwarder", fmt.Sprintf("Error starting HTTP server: %v", err), err, "", "")
+		return 1
+	}
+
+	p := producer.New(producer.Config{
+		ForwarderURL:   forwarderURL,
+		ServiceName:    externalName,
+		CallbackURL:    fmt.Sprintf("http://%s:%s/callback", externalName, externalPort),
+		Interval:       tick,
+		Scenario:       scenario,
+		OutstandingTTL: ttl,
+		ManualApproval: manualApproval == "true",
+		RequestTimeout: requestTimeout,
+		MaxAttempts:    attempts,
+		RetryBackoff:   backoff,
+		SpoolDir:       spoolDir,
+		SpoolSize:      spoolEntries,
+	}, customLogger)
+
+	if loadRPS != "" {
+		go func() {
+			err := http.ListenAndServe(":"+externalPort, p.Handler())
+			customLogger.Log("forwarder", fmt.Sprintf("Error starting HTTP server: %v", err), err, "", "")
+			sinks.Close()
+			os.Exit(1)
+		}()
+
+		if err := runLoad(p); err != nil {
+			customLogger.Log("error", fmt.Sprintf("load run failed: %v", err), err, "", "")
+			return 1
+		}
+		return 0
+	}
+
+	go p.Run(context.Background())
+
+	err = http.ListenAndServe(":"+externalPort, p.Handler())
+	customLogger.Log("forwarder", fmt.Sprintf("Error starting HTTP server: %v", err), err, "", "")
+
+	return 1
+}
+
+func runLoad(p *producer.Producer) error {
+	cfg := producer.LoadConfig{Profile: loadProfile}
+
+	var err error
+	parse := func(name, value string, parse func(string) error) {
+		if err == nil {
+			if perr := parse(value); perr != nil {
+				err = fmt.Errorf("invalid %s: %w", name, perr)
+			}
+		}
+	}
+
+	parse("LOAD_RPS", loadRPS, func(s string) (err error) { cfg.RPS, err = strconv.ParseFloat(s, 64); return })
+	parse("LOAD_CONCURRENCY", loadConcurrency, func(s string) (err error) { cfg.Concurrency, err = strconv.Atoi(s); return })
+	parse("LOAD_DURATION", loadDuration, func(s string) (err error) { cfg.Duration, err = time.ParseDuration(s); return })
+	parse("LOAD_RAMP_UP", loadRampUp, func(s string) (err error) { cfg.RampUp, err = time.ParseDuration(s); return })
+	parse("LOAD_STEPS", loadSteps, func(s string) (err error) { cfg.Steps, err = strconv.Atoi(s); return })
+	parse("LOAD_SPIKE_RPS", loadSpikeRPS, func(s string) (err error) { cfg.SpikeRPS, err = strconv.ParseFloat(s, 64); return })
+	parse("LOAD_SPIKE_AT", loadSpikeAt, func(s string) (err error) { cfg.SpikeAt, err = time.ParseDuration(s); return })
+	parse("LOAD_SPIKE_DURATION", loadSpikeDuration, func(s string) (err error) { cfg.SpikeDuration, err = time.ParseDuration(s); return })
+	parse("LOAD_DRAIN", loadDrain, func(s string) (err error) { cfg.Drain, err = time.ParseDuration(s); return })
+	if err != nil {
+		return err
+	}
+
+	report, err := p.RunLoad(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+
+	report.WriteText(os.Stdout)
+
+	if loadReport == "" {
+		return nil
+	}
+
+	f, err := os.Create(loadReport)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return report.WriteJSON(f)
+}
@@ -0,0 +1,310 @@
+// Command replay re-delivers past messages of a pipeline topic, selected by
+// offset or time range and optionally by execution or correlation ID, either
+// to the consumer handlers or to a target topic.
+//
+//	replay -topic e_topic -from-time 2023-10-01T10:00:00Z -execution <id> -handlers
+//	replay -topic e_topic -from-offset 120 -target-topic e_topic.replay -dry-run
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/blob"
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/consumer"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+var (
+	brokerKind    = shared.GetEnv("BROKER_KIND", broker.KindKafka)
+	brokerAddress = shared.GetEnv("KAFKA_BROKER", "localhost:9099")
+	natsURL       = shared.GetEnv("NATS_URL", "")
+	friendlyName  = shared.GetEnv("FRIENDLY_NAME", "replay")
+	blobKind      = shared.GetEnv("BLOB_STORE", "")
+	blobDir       = shared.GetEnv("BLOB_DIR", "/var/lib/rtd/blobs")
+	mongoURI      = shared.GetEnv("MONGO_URI", "mongodb://localhost:27017")
+	blobDB        = shared.GetEnv("BLOB_MONGO_DB", "blobs")
+	blobBucket    = shared.GetEnv("BLOB_BUCKET", "payloads")
+
+	// The handlers update the consumer's read model when SINK is set.
+	sinkKind        = shared.GetEnv("SINK", "")
+	mongoDB         = shared.GetEnv("MONGO_DB", "readmodel")
+	mongoCollection = shared.GetEnv("MONGO_COLLECTION", "correlations")
+)
+
+type options struct {
+	topic         string
+	fromOffset    int64
+	fromTime      time.Time
+	toTime        time.Time
+	executionID   string
+	correlationID string
+	targetTopic   string
+	handlers      bool
+	dryRun        bool
+	limit         int
+	idle          time.Duration
+}
+
+// replayed is printed for every matching message.
+type replayed struct {
+	Topic         string    `json:"topic"`
+	Offset        int64     `json:"offset"`
+	Time          time.Time `json:"time"`
+	Key           string    `json:"key"`
+	CorrelationID string    `json:"correlation_id"`
+	ExecutionID   string    `json:"execution_id"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+}
+
+func main() {
+	opts, err := parseFlags()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	bus, err := broker.New(broker.Config{
+		Kind:         brokerKind,
+		KafkaAddress: brokerAddress,
+		NATS:         broker.NATSConfig{URL: natsURL},
+	})
+	if err != nil {
+		log.Fatalf("error creating broker: %v", err)
+	}
+	defer bus.Close()
+
+	// Offloaded payloads are resolved for the handlers when a blob store is
+	// configured. The forwarder keeps them for BLOB_RETENTION.
+	var blobs blob.Store
+	if blobKind != "" {
+		blobs, err = blob.New(ctx, blob.Config{
+			Kind:     blobKind,
+			Dir:      blobDir,
+			MongoURI: mongoURI,
+			MongoDB:  blobDB,
+			Bucket:   blobBucket,
+		})
+		if err != nil {
+			log.Fatalf("error creating blob store: %v", err)
+		}
+		defer blobs.Close(context.Background())
+	}
+
+	var c *consumer.Consumer
+	if opts.handlers {
+		// Keep stdout for the replayed message listing.
+		customLogger := logger.New(friendlyName)
+		customLogger.SetOutput(os.Stderr)
+
+		var sink consumer.Sink
+
+		switch sinkKind {
+		case "":
+		case "mongo":
+			mongoSink, err := consumer.NewMongoSink(ctx, mongoURI, mongoDB, mongoCollection)
+			if err != nil {
+				log.Fatalf("error connecting to mongo: %v", err)
+			}
+			defer mongoSink.Close(context.Background())
+			sink = mongoSink
+		default:
+			log.Fatalf("invalid SINK: unknown sink %q", sinkKind)
+		}
+
+		c = consumer.New(consumer.Config{
+			FriendlyName: friendlyName,
+			EventTopic:   opts.topic,
+			Handlers:     consumer.DefaultRegistry(customLogger),
+			Sink:         sink,
+			Blobs:        blobs,
+		}, bus, customLogger)
+	}
+
+	if err := replay(ctx, bus, c, opts, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseFlags() (options, error) {
+	var opts options
+	var fromTime, toTime string
+
+	flag.StringVar(&opts.topic, "topic", "e_topic", "topic to read from")
+	flag.Int64Var(&opts.fromOffset, "from-offset", broker.FirstOffset, "offset to start from, -2 for the first message")
+	flag.StringVar(&fromTime, "from-time", "", "RFC3339 time to start from, overrides -from-offset")
+	flag.StringVar(&toTime, "to-time", "", "RFC3339 time to stop at")
+	flag.StringVar(&opts.executionID, "execution", "", "only replay messages of this execution ID")
+	flag.StringVar(&opts.correlationID, "correlation", "", "only replay messages of this correlation ID")
+	flag.StringVar(&opts.targetTopic, "target-topic", "", "re-publish matching messages to this topic")
+	flag.BoolVar(&opts.handlers, "handlers", false, "run matching events through the consumer handlers")
+	flag.BoolVar(&opts.dryRun, "dry-run", false, "only list the messages that would be replayed")
+	flag.IntVar(&opts.limit, "limit", 0, "stop after this many matching messages, 0 for no limit")
+	flag.DurationVar(&opts.idle, "idle", 5*time.Second, "stop once no message arrived for this long")
+	flag.Parse()
+
+	var err error
+
+	if fromTime != "" {
+		if opts.fromTime, err = time.Parse(time.RFC3339, fromTime); err != nil {
+			return opts, fmt.Errorf("invalid -from-time: %w", err)
+		}
+	}
+
+	if toTime != "" {
+		if opts.toTime, err = time.Parse(time.RFC3339, toTime); err != nil {
+			return opts, fmt.Errorf("invalid -to-time: %w", err)
+		}
+	}
+
+	if !opts.dryRun && (opts.targetTopic == "") == !opts.handlers {
+		return opts, fmt.Errorf("exactly one of -target-topic or -handlers is required unless -dry-run is set")
+	}
+
+	return opts, nil
+}
+
+// replay delivers the matching messages of opts.topic to c, or to the target
+// topic when c is nil, listing them on out.
+func replay(ctx context.Context, bus broker.Broker, c *consumer.Consumer, opts options, out io.Writer) error {
+	// Replaying a topic into itself stops at the messages published before
+	// the replay started, rather than reading its own.
+	if opts.targetTopic == opts.topic && !opts.dryRun && opts.toTime.IsZero() {
+		opts.toTime = time.Now()
+	}
+
+	sub, err := bus.Subscribe(opts.topic, broker.SubscribeOptions{
+		StartOffset: opts.fromOffset,
+		StartTime:   opts.fromTime,
+	})
+	if err != nil {
+		return fmt.Errorf("error subscribing to %s: %w", opts.topic, err)
+	}
+	defer sub.Close()
+
+	enc := json.NewEncoder(out)
+	scanned, matched, failed := 0, 0, 0
+
+	for opts.limit == 0 || matched < opts.limit {
+		fetchCtx, cancel := context.WithTimeout(ctx, opts.idle)
+		msg, err := sub.Fetch(fetchCtx)
+		cancel()
+
+		if ctx.Err() != nil {
+			break
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("error reading from %s: %w", opts.topic, err)
+		}
+
+		if !opts.toTime.IsZero() && msg.Time.After(opts.toTime) {
+			break
+		}
+
+		scanned++
+
+		correlationID, executionID := messageIDs(msg)
+
+		if opts.executionID != "" && executionID != opts.executionID {
+			continue
+		}
+
+		if opts.correlationID != "" && correlationID != opts.correlationID {
+			continue
+		}
+
+		matched++
+
+		result := replayed{
+			Topic:         msg.Topic,
+			Offset:        msg.Offset,
+			Time:          msg.Time,
+			Key:           string(msg.Key),
+			CorrelationID: correlationID,
+			ExecutionID:   executionID,
+			Status:        "dry-run",
+		}
+
+		if !opts.dryRun {
+			if err := deliver(ctx, bus, c, opts, msg); err != nil {
+				result.Status, result.Error = "failed", err.Error()
+				failed++
+			} else {
+				result.Status = "replayed"
+			}
+		}
+
+		enc.Encode(result)
+	}
+
+	log.Printf("scanned %d, matched %d, failed %d", scanned, matched, failed)
+
+	return nil
+}
+
+// messageIDs returns the correlation and execution IDs of a message, from
+// its headers or, for messages without them, from its JSON payload.
+func messageIDs(msg broker.Message) (string, string) {
+	headers := shared.ParseHeaders(msg.Headers)
+
+	if headers.CorrelationID != "" && headers.ExecutionID != "" {
+		return headers.CorrelationID, headers.ExecutionID
+	}
+
+	var ids struct {
+		CorrelationID string `json:"correlation_id"`
+		ExecutionID   string `json:"execution_id"`
+	}
+	json.Unmarshal(msg.Value, &ids)
+
+	if headers.CorrelationID != "" {
+		ids.CorrelationID = headers.CorrelationID
+	}
+
+	if headers.ExecutionID != "" {
+		ids.ExecutionID = headers.ExecutionID
+	}
+
+	return ids.CorrelationID, ids.ExecutionID
+}
+
+// deliver marks msg as replayed, so the consumer runs its handlers again
+// even if its sink holds the event as processed, and delivers it.
+func deliver(ctx context.Context, bus broker.Broker, c *consumer.Consumer, opts options, msg broker.Message) error {
+	headers := append([]broker.Header{}, msg.Headers...)
+	headers = append(headers, broker.Header{Key: shared.HeaderReplayedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))})
+
+	replayed := broker.Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	}
+
+	if c != nil {
+		replayed.Topic, replayed.Partition, replayed.Offset, replayed.Time = msg.Topic, msg.Partition, msg.Offset, msg.Time
+		return c.Process(ctx, replayed).Err()
+	}
+
+	return bus.Publish(ctx, opts.targetTopic, replayed)
+}
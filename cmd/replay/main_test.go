@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/consumer"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newBus returns a memory broker holding five events on e_topic, one a
+// minute from start. The last one only carries its IDs in its payload.
+func newBus(t *testing.T) broker.Broker {
+	t.Helper()
+
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	for i := 0; i < 5; i++ {
+		correlationID, executionID := fmt.Sprintf("corr-%d", i), fmt.Sprintf("exec-%d", i)
+		data, _ := json.Marshal(shared.Event{CorrelationID: correlationID, ExecutionID: executionID, ServiceName: "payments", Type: shared.EventTypeCommitted})
+
+		msg := broker.Message{Key: []byte(correlationID), Value: data, Time: start.Add(time.Duration(i) * time.Minute)}
+		if i < 4 {
+			msg.Headers = shared.NewHeaders("monitor", shared.ContentTypeEvent, correlationID, executionID).Encode()
+		}
+
+		if err := bus.Publish(context.Background(), "e_topic", msg); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	return bus
+}
+
+// runReplay replays with opts, returning the listed messages.
+func runReplay(t *testing.T, bus broker.Broker, c *consumer.Consumer, opts options) []replayed {
+	t.Helper()
+
+	opts.topic = "e_topic"
+	opts.idle = 100 * time.Millisecond
+
+	var out bytes.Buffer
+	if err := replay(context.Background(), bus, c, opts, &out); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+
+	var listed []replayed
+	for dec := json.NewDecoder(&out); dec.More(); {
+		var r replayed
+		if err := dec.Decode(&r); err != nil {
+			t.Fatalf("decoding listing: %v", err)
+		}
+		listed = append(listed, r)
+	}
+
+	return listed
+}
+
+// readTopic returns the messages published to topic.
+func readTopic(t *testing.T, bus broker.Broker, topic string) []broker.Message {
+	t.Helper()
+
+	sub, err := bus.Subscribe(topic, broker.SubscribeOptions{StartOffset: broker.FirstOffset})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	var msgs []broker.Message
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		msg, err := sub.Fetch(ctx)
+		cancel()
+
+		if err != nil {
+			return msgs
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+func offsets(listed []replayed) string {
+	var offsets []int64
+	for _, r := range listed {
+		offsets = append(offsets, r.Offset)
+	}
+	return fmt.Sprint(offsets)
+}
+
+func TestReplayFilters(t *testing.T) {
+	tests := []struct {
+		name string
+		opts options
+		want string
+	}{
+		{"all", options{fromOffset: broker.FirstOffset}, "[0 1 2 3 4]"},
+		{"from offset", options{fromOffset: 2}, "[2 3 4]"},
+		{"from time", options{fromOffset: broker.FirstOffset, fromTime: start.Add(3 * time.Minute)}, "[3 4]"},
+		{"to time", options{fromOffset: broker.FirstOffset, toTime: start.Add(time.Minute)}, "[0 1]"},
+		{"execution", options{fromOffset: broker.FirstOffset, executionID: "exec-2"}, "[2]"},
+		{"correlation from payload", options{fromOffset: broker.FirstOffset, correlationID: "corr-4"}, "[4]"},
+		{"limit", options{fromOffset: 1, limit: 2}, "[1 2]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bus := newBus(t)
+
+			tt.opts.targetTopic = "e_topic.replay"
+			listed := runReplay(t, bus, nil, tt.opts)
+
+			if got := offsets(listed); got != tt.want {
+				t.Fatalf("replayed offsets %s, want %s", got, tt.want)
+			}
+
+			for _, r := range listed {
+				if r.Status != "replayed" {
+					t.Errorf("offset %d status %q, want replayed", r.Offset, r.Status)
+				}
+			}
+
+			published := readTopic(t, bus, "e_topic.replay")
+			if len(published) != len(listed) {
+				t.Fatalf("published %d messages, want %d", len(published), len(listed))
+			}
+
+			for _, msg := range published {
+				if shared.ParseHeaders(msg.Headers).ReplayedAt.IsZero() {
+					t.Errorf("message %s published without %s", msg.Key, shared.HeaderReplayedAt)
+				}
+			}
+		})
+	}
+}
+
+func TestReplayDryRun(t *testing.T) {
+	bus := newBus(t)
+
+	listed := runReplay(t, bus, nil, options{fromOffset: broker.FirstOffset, targetTopic: "e_topic.replay", dryRun: true})
+
+	if got := offsets(listed); got != "[0 1 2 3 4]" {
+		t.Fatalf("listed offsets %s, want [0 1 2 3 4]", got)
+	}
+
+	for _, r := range listed {
+		if r.Status != "dry-run" {
+			t.Errorf("offset %d status %q, want dry-run", r.Offset, r.Status)
+		}
+	}
+
+	if published := readTopic(t, bus, "e_topic.replay"); len(published) != 0 {
+		t.Fatalf("dry run published %d messages", len(published))
+	}
+}
+
+func TestReplayIntoSameTopic(t *testing.T) {
+	bus := newBus(t)
+
+	listed := runReplay(t, bus, nil, options{fromOffset: broker.FirstOffset, targetTopic: "e_topic"})
+
+	if got := offsets(listed); got != "[0 1 2 3 4]" {
+		t.Fatalf("replayed offsets %s, want [0 1 2 3 4], without its own messages", got)
+	}
+
+	if published := readTopic(t, bus, "e_topic"); len(published) != 10 {
+		t.Fatalf("e_topic holds %d messages, want 10", len(published))
+	}
+}
+
+func TestReplayRunsHandlersOfProcessedEvents(t *testing.T) {
+	bus := newBus(t)
+
+	var handled atomic.Int32
+	handlers := consumer.NewRegistry()
+	handlers.Register("count", consumer.Any, consumer.Any, func(ctx context.Context, evt shared.Event) error {
+		handled.Add(1)
+		return nil
+	})
+
+	customLogger := logger.New("replay")
+	customLogger.SetOutput(io.Discard)
+
+	sink := consumer.NewMemorySink()
+	c := consumer.New(consumer.Config{EventTopic: "e_topic", Handlers: handlers, Sink: sink}, bus, customLogger)
+
+	// The first replay records the events as processed in the sink, the
+	// second runs their handlers again rather than skipping duplicates.
+	for run := 1; run <= 2; run++ {
+		listed := runReplay(t, bus, c, options{fromOffset: broker.FirstOffset, executionID: "exec-1", handlers: true})
+
+		if len(listed) != 1 || listed[0].Status != "replayed" {
+			t.Fatalf("run %d listed %+v, want exec-1 replayed", run, listed)
+		}
+
+		if got := handled.Load(); got != int32(run) {
+			t.Fatalf("run %d: handlers ran %d times, want %d", run, got, run)
+		}
+	}
+
+	rec, ok, err := sink.Get(context.Background(), "corr-1")
+	if err != nil || !ok || rec.Status != consumer.StatusProcessed {
+		t.Fatalf("sink holds %+v, %t, %v, want corr-1 processed", rec, ok, err)
+	}
+}
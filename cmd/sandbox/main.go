@@ -0,0 +1,194 @@
+// Command sandbox runs the whole pipeline in a single process: producer,
+// forwarder, monitor, consumer, the dashboard backend and the WebSocket hub,
+// connected through an in-memory broker, or an embedded NATS JetStream server
+// with BROKER_KIND=nats, with logs kept in memory.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/blob"
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/consumer"
+	"github.com/assimoes/rtd-sandbox/forwarder"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/monitor"
+	"github.com/assimoes/rtd-sandbox/producer"
+	"github.com/assimoes/rtd-sandbox/shared"
+	"github.com/assimoes/rtd-sandbox/web/backend"
+	"github.com/assimoes/rtd-sandbox/web/ws"
+)
+
+var (
+	forwarderAddress = shared.GetEnv("FORWARDER_ADDRESS", "localhost:3000")
+	producerAddress  = shared.GetEnv("PRODUCER_ADDRESS", "localhost:8888")
+	backendAddress   = shared.GetEnv("BACKEND_ADDRESS", "localhost:8080")
+	wsAddress        = shared.GetEnv("WS_ADDRESS", "localhost:8899")
+	consumerAPI      = shared.GetEnv("CONSUMER_API_ADDRESS", "localhost:8090")
+	monitorAPI       = shared.GetEnv("MONITOR_API_ADDRESS", "localhost:8091")
+	producerInterval = shared.GetEnv("PRODUCER_INTERVAL", "5s")
+	scenarioFile     = shared.GetEnv("SCENARIO_FILE", "")
+	manualApproval   = shared.GetEnv("MANUAL_APPROVAL", "false")
+	eventRoutes      = shared.GetEnv("EVENT_ROUTES", "")
+	staticDir        = shared.GetEnv("STATIC_DIR", "web/frontend/public")
+	brokerKind       = shared.GetEnv("BROKER_KIND", broker.KindMemory)
+	maxMessages      = shared.GetEnv("BROKER_MAX_MESSAGES", "100000")
+	natsListen       = shared.GetEnv("NATS_LISTEN", "")
+	natsStoreDir     = shared.GetEnv("NATS_STORE_DIR", "")
+	offloadBytes     = shared.GetEnv("OFFLOAD_BYTES", "65536")
+	blobTTL          = shared.GetEnv("BLOB_RETENTION", "1h")
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	interval, err := time.ParseDuration(producerInterval)
+	if err != nil {
+		log.Fatalf("invalid PRODUCER_INTERVAL: %v", err)
+	}
+
+	var scenario *producer.Scenario
+	if scenarioFile != "" {
+		scenario, err = producer.LoadScenario(scenarioFile)
+		if err != nil {
+			log.Fatalf("invalid SCENARIO_FILE: %v", err)
+		}
+	}
+
+	offloadLimit, err := strconv.Atoi(offloadBytes)
+	if err != nil {
+		log.Fatalf("invalid OFFLOAD_BYTES: %v", err)
+	}
+
+	blobRetention, err := time.ParseDuration(blobTTL)
+	if err != nil {
+		log.Fatalf("invalid BLOB_RETENTION: %v", err)
+	}
+
+	messageLimit, err := strconv.Atoi(maxMessages)
+	if err != nil {
+		log.Fatalf("invalid BROKER_MAX_MESSAGES: %v", err)
+	}
+
+	bus, err := broker.New(broker.Config{
+		Kind:   brokerKind,
+		Memory: broker.MemoryConfig{MaxMessages: messageLimit},
+		NATS:   broker.NATSConfig{Embedded: true, Listen: natsListen, StoreDir: natsStoreDir},
+	})
+	if err != nil {
+		log.Fatalf("error creating broker: %v", err)
+	}
+	defer bus.Close()
+
+	store := backend.NewMemoryStore()
+
+	newLogger := func(friendlyName string) *logger.CustomLogger {
+		customLogger := logger.New(friendlyName)
+		customLogger.SetOutput(io.MultiWriter(os.Stdout, store))
+		return customLogger
+	}
+
+	blobs := blob.NewMemory()
+
+	f := forwarder.New(forwarder.Config{
+		FriendlyName:  "forwarder",
+		Group:         "forwarder",
+		Blobs:         blobs,
+		OffloadBytes:  offloadLimit,
+		BlobRetention: blobRetention,
+	}, bus, newLogger("forwarder"))
+
+	m, err := monitor.New(monitor.Config{
+		FriendlyName: "monitor",
+		Group:        "monitor",
+		EventRoutes:  eventRoutes,
+	}, bus, newLogger("monitor"))
+	if err != nil {
+		log.Fatalf("error creating monitor: %v", err)
+	}
+
+	consumerLogger := newLogger("consumer_a")
+	sink := consumer.NewMemorySink()
+
+	c := consumer.New(consumer.Config{
+		FriendlyName: "consumer_a",
+		Group:        "consumer_a",
+		Handlers:     consumer.DefaultRegistry(consumerLogger),
+		AckTopic:     "acks",
+		Sink:         sink,
+		Blobs:        blobs,
+	}, bus, consumerLogger)
+
+	p := producer.New(producer.Config{
+		ForwarderURL:   "http://" + forwarderAddress,
+		ServiceName:    "producer_a",
+		CallbackURL:    fmt.Sprintf("http://%s/callback", producerAddress),
+		Interval:       interval,
+		Scenario:       scenario,
+		ManualApproval: manualApproval == "true",
+	}, newLogger("producer_a"))
+
+	app := backend.New(store, staticDir)
+
+	var wg sync.WaitGroup
+
+	run := func(name string, fn func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			err := fn()
+			if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("%s stopped: %v", name, err)
+				stop()
+			}
+		}()
+	}
+
+	run("forwarder", serve(ctx, forwarderAddress, f.Handler()))
+	run("producer", serve(ctx, producerAddress, p.Handler()))
+	run("ws", serve(ctx, wsAddress, ws.NewHub().Handler()))
+	run("consumer api", serve(ctx, consumerAPI, c.Handler()))
+	run("monitor api", serve(ctx, monitorAPI, m.Handler()))
+	run("forwarder acks", func() error { return f.Run(ctx) })
+	run("monitor", func() error { return m.Run(ctx) })
+	run("consumer", func() error { return c.Run(ctx) })
+	run("producer ticker", func() error { p.Run(ctx); return nil })
+	run("backend", func() error {
+		go func() {
+			<-ctx.Done()
+			app.Shutdown()
+		}()
+		return app.Listen(backendAddress)
+	})
+
+	log.Printf("sandbox running: forwarder %s, producer %s, dashboard %s, websocket %s, consumer api %s, monitor api %s", forwarderAddress, producerAddress, backendAddress, wsAddress, consumerAPI, monitorAPI)
+
+	wg.Wait()
+}
+
+// serve runs an HTTP server until ctx is done.
+func serve(ctx context.Context, address string, handler http.Handler) func() error {
+	return func() error {
+		srv := &http.Server{Addr: address, Handler: handler}
+
+		go func() {
+			<-ctx.Done()
+			srv.Shutdown(context.Background())
+		}()
+
+		return srv.ListenAndServe()
+	}
+}
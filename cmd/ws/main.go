@@ -0,0 +1,16 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/assimoes/rtd-sandbox/web/ws"
+)
+
+func main() {
+	log.Println("WebSocket server started on :8899")
+	err := http.ListenAndServe(":8899", ws.NewHub().Handler())
+	if err != nil {
+		panic("Error starting server: " + err.Error())
+	}
+}
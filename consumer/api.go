@@ -0,0 +1,183 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Page sizes of the list endpoints.
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// NewAPI returns the read API over the materialized events in sink:
+//
+//	GET /correlations/{correlation_id}  state of one correlation
+//	GET /services                       record counts per service and status
+//	GET /services/{service_name}        records of one service
+//
+// The list endpoints return up to limit entries, 100 by default and 1000 at
+// most, after skipping the first offset ones.
+func NewAPI(sink Sink) http.Handler {
+	a := &api{sink: sink}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/correlations/", a.getCorrelation)
+	mux.HandleFunc("/services", a.getServices)
+	mux.HandleFunc("/services/", a.getService)
+
+	return mux
+}
+
+// Handler returns the consumer's HTTP routes: the read API over its sink,
+// when set, and the log level at /loglevel.
+func (c *Consumer) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	if c.cfg.Sink != nil {
+		mux.Handle("/", NewAPI(c.cfg.Sink))
+	}
+
+	mux.Handle("/loglevel", c.customLogger.LevelHandler())
+
+	return mux
+}
+
+type api struct {
+	sink Sink
+}
+
+func (a *api) getCorrelation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	correlationID := strings.TrimPrefix(r.URL.Path, "/correlations/")
+	if correlationID == "" {
+		http.Error(w, "invalid correlation id", http.StatusBadRequest)
+		return
+	}
+
+	rec, ok, err := a.sink.Get(r.Context(), correlationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		http.Error(w, "correlation not found", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, rec)
+}
+
+func (a *api) getServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, offset, err := page(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	services, err := a.sink.Services(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	total := len(services)
+	services = services[min(offset, total):]
+	services = services[:min(limit, len(services))]
+
+	writeJSON(w, map[string]interface{}{
+		"total":  total,
+		"limit":  limit,
+		"offset": offset,
+		"data":   services,
+	})
+}
+
+func (a *api) getService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	serviceName := strings.TrimPrefix(r.URL.Path, "/services/")
+	if serviceName == "" {
+		http.Error(w, "invalid service name", http.StatusBadRequest)
+		return
+	}
+
+	limit, offset, err := page(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	services, err := a.sink.Services(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	state := ServiceState{ServiceName: serviceName, Statuses: map[string]int{}}
+	for _, s := range services {
+		if s.ServiceName == serviceName {
+			state = s
+		}
+	}
+
+	records, err := a.sink.ByService(r.Context(), serviceName, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{
+		"service_name": serviceName,
+		"total":        state.Total,
+		"statuses":     state.Statuses,
+		"limit":        limit,
+		"offset":       offset,
+		"data":         records,
+	})
+}
+
+// page returns the limit and offset query parameters of a list request.
+func page(r *http.Request) (int, int, error) {
+	limit, offset := defaultPageSize, 0
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 || n > maxPageSize {
+			return 0, 0, fmt.Errorf("invalid limit %q, expected 1 to %d", value, maxPageSize)
+		}
+		limit = n
+	}
+
+	if value := r.URL.Query().Get("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid offset %q", value)
+		}
+		offset = n
+	}
+
+	return limit, offset, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,102 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIPaginatesServiceRecords(t *testing.T) {
+	sink := NewMemorySink()
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		sink.Apply(context.Background(), Record{
+			CorrelationID: fmt.Sprintf("corr-%d", i),
+			ServiceName:   "payments",
+			Status:        StatusProcessed,
+			UpdatedAt:     now.Add(time.Duration(i) * time.Second),
+		})
+	}
+
+	api := NewAPI(sink)
+
+	tests := []struct {
+		query  string
+		status int
+		want   []string
+	}{
+		{"", http.StatusOK, []string{"corr-4", "corr-3", "corr-2", "corr-1", "corr-0"}},
+		{"?limit=2", http.StatusOK, []string{"corr-4", "corr-3"}},
+		{"?limit=2&offset=3", http.StatusOK, []string{"corr-1", "corr-0"}},
+		{"?offset=10", http.StatusOK, nil},
+		{"?limit=0", http.StatusBadRequest, nil},
+		{"?limit=5000", http.StatusBadRequest, nil},
+		{"?offset=-1", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/services/payments"+tt.query, nil))
+
+		if rec.Code != tt.status {
+			t.Errorf("%q: status %d, want %d", tt.query, rec.Code, tt.status)
+			continue
+		}
+		if rec.Code != http.StatusOK {
+			continue
+		}
+
+		var body struct {
+			Total int      `json:"total"`
+			Data  []Record `json:"data"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+			t.Fatalf("%q: decoding: %v", tt.query, err)
+		}
+
+		var got []string
+		for _, r := range body.Data {
+			got = append(got, r.CorrelationID)
+		}
+
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%q: records %v, want %v", tt.query, got, tt.want)
+		}
+		if body.Total != 5 {
+			t.Errorf("%q: total %d, want 5", tt.query, body.Total)
+		}
+	}
+}
+
+func TestConsumerHandler(t *testing.T) {
+	sink := NewMemorySink()
+	sink.Apply(context.Background(), Record{CorrelationID: "corr-1", ServiceName: "payments", Status: StatusProcessed})
+
+	tests := []struct {
+		name string
+		cfg  Config
+		path string
+		want int
+	}{
+		{"read api", Config{Sink: sink}, "/correlations/corr-1", http.StatusOK},
+		{"log level", Config{Sink: sink}, "/loglevel", http.StatusOK},
+		{"no sink", Config{}, "/correlations/corr-1", http.StatusNotFound},
+		{"log level without sink", Config{}, "/loglevel", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		handler := New(tt.cfg, nil, discardLogger()).Handler()
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if rec.Code != tt.want {
+			t.Errorf("%s: GET %s = %d, want %d", tt.name, tt.path, rec.Code, tt.want)
+		}
+	}
+}
@@ -1,84 +1,552 @@
-package main
+package consumer
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
+	"github.com/assimoes/rtd-sandbox/blob"
+	"github.com/assimoes/rtd-sandbox/broker"
 	"github.com/assimoes/rtd-sandbox/logger"
 	"github.com/assimoes/rtd-sandbox/shared"
-	"github.com/segmentio/kafka-go"
 )
 
-var (
-	broker       = shared.GetEnv("KAFKA_BROKER", "localhost:9099")
-	friendlyName = shared.GetEnv("FRIENDLY_NAME", "consumer_a")
+// Outcome statuses. They double as the status of the published acks.
+const (
+	// StatusProcessed means every matching handler succeeded.
+	StatusProcessed = shared.AckProcessed
+	// StatusUnhandled means no handler matched the event.
+	StatusUnhandled = shared.AckUnhandled
+	// StatusFailed means a handler still failed after the last attempt, or
+	// the event could not be decoded.
+	StatusFailed = shared.AckFailed
+	// StatusDuplicate means the sink shows the event was already processed,
+	// so its handlers were skipped.
+	StatusDuplicate = "duplicate"
 )
 
-func main() {
+// Config configures a Consumer.
+type Config struct {
+	FriendlyName string
+	// Group is the consumer group used for the event topic.
+	Group      string
+	EventTopic string
+	// Handlers run for every event. Events without a matching handler are
+	// acknowledged and reported as unhandled.
+	Handlers *Registry
+	// Concurrency is the number of events processed in parallel. Defaults to 1.
+	Concurrency int
+	// MaxAttempts is how many times failing handlers are run per event.
+	// Defaults to 3.
+	MaxAttempts int
+	// RetryBackoff is the delay before the first retry, doubled on every
+	// further attempt. Defaults to 500ms.
+	RetryBackoff time.Duration
+	// DeadLetterTopic receives events whose handlers still fail after the
+	// last attempt. Without one, failed events are not acknowledged, and
+	// hold back the commits of the events read after them, so they are all
+	// redelivered once the group restarts.
+	DeadLetterTopic string
+	// MaxPending bounds the events read but not committed yet. Once reached,
+	// such as behind a failed event without a DeadLetterTopic, reading
+	// pauses until they are committed. Defaults to 10000.
+	MaxPending int
+	// AckTopic receives a shared.Ack for every processed or failed event.
+	// Acks are not published when empty.
+	AckTopic string
+	// Sink materializes every event by correlation ID. When set, events it
+	// already holds as processed are acknowledged without running the
+	// handlers again, unless they were replayed.
+	Sink Sink
+	// Blobs resolves the payloads the forwarder offloaded, so handlers see
+	// them in Event.Payload. It must be the forwarder's store.
+	Blobs blob.Store
+	// ReadBackoff is the wait after a failed read, doubled on every
+	// consecutive failure up to MaxReadBackoff. Defaults to 100ms and 10s.
+	ReadBackoff    time.Duration
+	MaxReadBackoff time.Duration
+}
 
-	eventCh, eventErrCh := readTopic("e_topic")
+// readErrorLimit bounds the read errors logged, such as while the broker is
+// unreachable.
+var readErrorLimit = logger.Limit{Burst: 3, Interval: 30 * time.Second}
 
-	customLogger := logger.New(friendlyName)
+// HandlerResult is the result of one handler for an event.
+type HandlerResult struct {
+	Handler  string
+	Attempts int
+	Err      error
+}
 
-	go errorLogger("e_topic", eventErrCh, customLogger)
+// Outcome reports how an event was processed.
+type Outcome struct {
+	Event    shared.Event
+	Headers  shared.Headers
+	Status   string
+	Results  []HandlerResult
+	Acked    bool
+	Duration time.Duration
+}
 
-	go processDataRequests(eventCh, customLogger)
+// Err joins the errors of the failed handlers, if any.
+func (o Outcome) Err() error {
+	var errs []error
+	for _, result := range o.Results {
+		if result.Err != nil {
+			if result.Handler != "" {
+				errs = append(errs, fmt.Errorf("%s: %w", result.Handler, result.Err))
+			} else {
+				errs = append(errs, result.Err)
+			}
+		}
+	}
 
-	select {}
+	return errors.Join(errs...)
 }
 
-func readTopic(topic string) (chan kafka.Message, chan error) {
-	msgCh, errCh := make(chan kafka.Message, 1000), make(chan error, 1000)
+// Consumer runs the registered handlers for the events published by the monitor.
+type Consumer struct {
+	cfg          Config
+	bus          broker.Broker
+	customLogger *logger.CustomLogger
+	keys         *keyLocks
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:   []string{broker},
-		Topic:     topic,
-		Partition: 0,
-		Dialer:    kafka.DefaultDialer,
-	})
+	mu         sync.Mutex
+	stats      map[string]int
+	onOutcomes []func(Outcome)
+}
+
+func New(cfg Config, bus broker.Broker, customLogger *logger.CustomLogger) *Consumer {
+	if cfg.EventTopic == "" {
+		cfg.EventTopic = "e_topic"
+	}
+	if cfg.Handlers == nil {
+		cfg.Handlers = NewRegistry()
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxPending <= 0 {
+		cfg.MaxPending = 10000
+	}
+	if cfg.ReadBackoff <= 0 {
+		cfg.ReadBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxReadBackoff <= 0 {
+		cfg.MaxReadBackoff = 10 * time.Second
+	}
+
+	return &Consumer{
+		cfg:          cfg,
+		bus:          bus,
+		customLogger: customLogger,
+		keys:         newKeyLocks(),
+		stats:        make(map[string]int),
+	}
+}
+
+// OnOutcome registers fn to be called with the outcome of every event.
+func (c *Consumer) OnOutcome(fn func(Outcome)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.onOutcomes = append(c.onOutcomes, fn)
+}
+
+// Stats returns the number of events per outcome status.
+func (c *Consumer) Stats() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[string]int, len(c.stats))
+	for status, n := range c.stats {
+		stats[status] = n
+	}
+
+	return stats
+}
+
+// Run consumes the event topic until ctx is done.
+func (c *Consumer) Run(ctx context.Context) error {
+	commits := broker.NewCommits()
+
+	eventSub, eventCh, eventErrCh, err := c.readTopic(ctx, c.cfg.EventTopic, commits)
+	if err != nil {
+		return err
+	}
+	defer eventSub.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(1 + c.cfg.Concurrency)
+
+	go func() { defer wg.Done(); c.errorLogger(ctx, c.cfg.EventTopic, eventErrCh) }()
+
+	for i := 0; i < c.cfg.Concurrency; i++ {
+		go func() { defer wg.Done(); c.processEvents(ctx, eventSub, eventCh, commits) }()
+	}
+
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// readTopic reads topic, tracking every message read with commits.
+func (c *Consumer) readTopic(ctx context.Context, topic string, commits *broker.Commits) (broker.Subscription, chan broker.Message, chan error, error) {
+	sub, err := c.bus.Subscribe(topic, broker.SubscribeOptions{Group: c.cfg.Group})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	msgCh, errCh := make(chan broker.Message, 1000), make(chan error, 1000)
 
 	go func() {
+		defer close(msgCh)
+		defer close(errCh)
+
+		var backoff time.Duration
+		pausedLogger := c.customLogger.Limited("pending "+topic, readErrorLimit)
+
 		for {
-			msg, err := reader.ReadMessage(context.Background())
+			// Events held back by an uncommitted one pile up in commits, so
+			// reading pauses rather than holding them without bound.
+			if pending := commits.Pending(); pending >= c.cfg.MaxPending {
+				pausedLogger.LogContext(ctx, "error", fmt.Sprintf("%d events read from %s topic are not committed yet, pausing reads", pending, topic), nil)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(c.cfg.ReadBackoff):
+				}
+
+				continue
+			}
+
+			msg, err := sub.Fetch(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
 			if err != nil {
 				errCh <- err
+
+				// Back off on consecutive errors, such as while the broker
+				// is unreachable, instead of failing in a tight loop.
+				backoff = min(max(2*backoff, c.cfg.ReadBackoff), c.cfg.MaxReadBackoff)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+
 				continue
 			}
 
+			backoff = 0
+			commits.Track(msg)
 			msgCh <- msg
 		}
 	}()
 
-	return msgCh, errCh
+	return sub, msgCh, errCh, nil
 }
 
-func errorLogger(topic string, errCh chan error, customLogger *logger.CustomLogger) {
+func (c *Consumer) errorLogger(ctx context.Context, topic string, errCh chan error) {
+	customLogger := c.customLogger.Limited("read "+topic, readErrorLimit)
+
 	for err := range errCh {
-		customLogger.Log("error", fmt.Sprintf("error reading from %s topic: %v", topic, err), err, "", "")
+		customLogger.LogContext(ctx, "error", fmt.Sprintf("error reading from %s topic: %v", topic, err), err)
+	}
+}
+
+func (c *Consumer) processEvents(ctx context.Context, sub broker.Subscription, eventCh chan broker.Message, commits *broker.Commits) {
+	for msg := range eventCh {
+		msgCtx := logger.WithHeaders(ctx, shared.ParseHeaders(msg.Headers))
+		outcome := c.process(msgCtx, msg)
+		msgCtx = outcomeContext(msgCtx, outcome)
+
+		// Events are only acknowledged once handled, and only committed
+		// once every event read before them is, as committing an offset
+		// commits every earlier one too.
+		if outcome.Status == StatusFailed && c.cfg.DeadLetterTopic != "" {
+			if err := c.bus.Publish(ctx, c.cfg.DeadLetterTopic, broker.Message{Key: msg.Key, Value: msg.Value, Headers: msg.Headers}); err != nil {
+				c.customLogger.LogContext(msgCtx, "error", fmt.Sprintf("error publishing event to dead letter topic %s: %v", c.cfg.DeadLetterTopic, err), err)
+			} else {
+				outcome.Acked = true
+			}
+		}
+
+		if ready := commits.Complete(msg, outcome.Acked); len(ready) > 0 {
+			if err := sub.Commit(ctx, ready...); err != nil {
+				c.customLogger.LogContext(msgCtx, "error", fmt.Sprintf("error acknowledging %d event(s): %v", len(ready), err), err)
+				outcome.Acked = false
+			}
+		}
+
+		c.report(msgCtx, outcome)
+
+		if c.cfg.AckTopic != "" && outcome.Event.CorrelationID != "" && outcome.Status != StatusDuplicate {
+			c.publishAck(msgCtx, outcome)
+		}
+	}
+}
+
+// publishAck tells upstream services that the event's execution is done.
+func (c *Consumer) publishAck(ctx context.Context, outcome Outcome) {
+	evt := outcome.Event
+	completedAt := time.Now().UTC()
+
+	ack := shared.Ack{
+		CorrelationID: evt.CorrelationID,
+		ExecutionID:   evt.ExecutionID,
+		ServiceName:   evt.ServiceName,
+		Consumer:      c.cfg.FriendlyName,
+		Status:        outcome.Status,
+		RequestedAt:   evt.RequestedAt,
+		CompletedAt:   completedAt,
+		ProcessingMs:  float64(outcome.Duration) / float64(time.Millisecond),
+	}
+
+	if !evt.RequestedAt.IsZero() {
+		ack.EndToEndMs = float64(completedAt.Sub(evt.RequestedAt)) / float64(time.Millisecond)
+	}
+
+	if err := outcome.Err(); err != nil {
+		ack.Error = err.Error()
+	}
+
+	ackData, _ := json.Marshal(ack)
+
+	err := c.bus.Publish(ctx, c.cfg.AckTopic, broker.Message{
+		Key:     []byte(evt.CorrelationID),
+		Value:   ackData,
+		Headers: outcome.Headers.Forward(c.cfg.FriendlyName, shared.ContentTypeAck).Encode(),
+	})
+	if err != nil {
+		c.customLogger.LogContext(ctx, "error", fmt.Sprintf("error publishing ack to %s topic: %v", c.cfg.AckTopic, err), err)
 	}
 }
 
-func processDataRequests(controlCh chan kafka.Message, customLogger *logger.CustomLogger) {
-	for ctrl := range controlCh {
+// Process runs the handlers for a single event message and reports the
+// outcome, without acknowledging it. It is used to replay past events.
+func (c *Consumer) Process(ctx context.Context, msg broker.Message) Outcome {
+	ctx = logger.WithHeaders(ctx, shared.ParseHeaders(msg.Headers))
+	outcome := c.process(ctx, msg)
+	c.report(outcomeContext(ctx, outcome), outcome)
+	return outcome
+}
+
+// process decodes an event and runs its handlers, retrying the failing ones.
+func (c *Consumer) process(ctx context.Context, msg broker.Message) Outcome {
+	start := time.Now()
+
+	headers := shared.ParseHeaders(msg.Headers)
 
-		var executionID string
+	var evt shared.Event
+	if err := json.Unmarshal(msg.Value, &evt); err != nil {
+		return Outcome{
+			Headers:  headers,
+			Status:   StatusFailed,
+			Results:  []HandlerResult{{Err: fmt.Errorf("decoding event: %w", err)}},
+			Duration: time.Since(start),
+		}
+	}
 
-		for _, header := range ctrl.Headers {
-			if header.Key == "execution_id" {
-				executionID = string(header.Value)
-				break
+	if headers.ExecutionID != "" {
+		evt.ExecutionID = headers.ExecutionID
+	}
+
+	if headers.CorrelationID != "" {
+		evt.CorrelationID = headers.CorrelationID
+	}
+
+	// Handlers log with the IDs of the event.
+	ctx = logger.WithIDs(logger.WithHeaders(ctx, headers), logger.IDs{CorrelationID: evt.CorrelationID, ExecutionID: evt.ExecutionID})
+
+	// Events of a correlation are processed one at a time, from reading the
+	// sink to writing it.
+	unlock := c.keys.lock(evt.CorrelationID)
+	defer unlock()
+
+	if evt.PayloadRef != "" && c.cfg.Blobs != nil {
+		payload, err := c.cfg.Blobs.Get(ctx, evt.PayloadRef)
+		if err != nil {
+			return Outcome{
+				Event:    evt,
+				Headers:  headers,
+				Status:   StatusFailed,
+				Results:  []HandlerResult{{Err: fmt.Errorf("resolving payload %s: %w", evt.PayloadRef, err)}},
+				Duration: time.Since(start),
 			}
 		}
 
-		var data shared.EventRequest
-		json.Unmarshal(ctrl.Value, &data)
+		evt.Payload = payload
+	}
 
-		if executionID != "" {
-			data.ExecutionID = executionID
+	if c.cfg.Sink != nil {
+		rec, ok, err := c.cfg.Sink.Get(ctx, evt.CorrelationID)
+		if err != nil {
+			return Outcome{
+				Event:    evt,
+				Headers:  headers,
+				Status:   StatusFailed,
+				Results:  []HandlerResult{{Err: fmt.Errorf("reading sink: %w", err)}},
+				Duration: time.Since(start),
+			}
 		}
 
-		customLogger.Log("kafka", fmt.Sprintf("consumed event request %s", data.ExecutionID), nil, data.CorrelationID, data.ExecutionID)
+		// Replayed events run the handlers again, as that is what they
+		// were replayed for.
+		if ok && rec.Status == StatusProcessed && headers.ReplayedAt.IsZero() {
+			outcome := Outcome{Event: evt, Headers: headers, Status: StatusDuplicate, Acked: true}
+			c.materialize(ctx, &outcome, msg.Offset)
+			outcome.Duration = time.Since(start)
+			return outcome
+		}
+	}
+
+	outcome := c.handle(ctx, evt)
+	outcome.Headers = headers
+
+	if c.cfg.Sink != nil {
+		c.materialize(ctx, &outcome, msg.Offset)
+	}
+
+	outcome.Duration = time.Since(start)
+
+	return outcome
+}
+
+// handle runs the handlers matching evt, retrying the failing ones.
+func (c *Consumer) handle(ctx context.Context, evt shared.Event) Outcome {
+	registrations := c.cfg.Handlers.match(evt)
+	if len(registrations) == 0 {
+		return Outcome{Event: evt, Status: StatusUnhandled, Acked: true}
+	}
+
+	results := make([]HandlerResult, len(registrations))
+	for i, reg := range registrations {
+		results[i].Handler = reg.name
+	}
+
+	backoff := c.cfg.RetryBackoff
+
+	for attempt := 1; attempt <= c.cfg.MaxAttempts; attempt++ {
+		failed := false
+
+		for i, reg := range registrations {
+			if results[i].Attempts > 0 && results[i].Err == nil {
+				continue
+			}
+
+			results[i].Attempts++
+			results[i].Err = runHandler(ctx, reg.handler, evt)
+
+			if results[i].Err != nil {
+				failed = true
+			}
+		}
+
+		if !failed {
+			return Outcome{Event: evt, Status: StatusProcessed, Results: results, Acked: true}
+		}
+
+		if attempt == c.cfg.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return Outcome{Event: evt, Status: StatusFailed, Results: results}
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+
+	return Outcome{Event: evt, Status: StatusFailed, Results: results}
+}
+
+// materialize upserts the record of the event read at offset in the sink.
+// If that fails the event is not acknowledged, so it is redelivered rather
+// than lost from the read model.
+func (c *Consumer) materialize(ctx context.Context, outcome *Outcome, offset int64) {
+	evt := outcome.Event
+	now := time.Now().UTC()
+
+	rec := Record{
+		CorrelationID: evt.CorrelationID,
+		ExecutionID:   evt.ExecutionID,
+		ServiceName:   evt.ServiceName,
+		EventType:     evt.Type,
+		Status:        outcome.Status,
+		Offset:        offset,
+		RequestedAt:   evt.RequestedAt,
+		UpdatedAt:     now,
+	}
+
+	if outcome.Status == StatusDuplicate {
+		rec.Status = StatusProcessed
+	}
+
+	if outcome.Status == StatusProcessed {
+		rec.ProcessedAt = now
+	}
+
+	if err := outcome.Err(); err != nil {
+		rec.Error = err.Error()
+	}
+
+	if err := c.cfg.Sink.Apply(ctx, rec); err != nil {
+		outcome.Status = StatusFailed
+		outcome.Acked = false
+		outcome.Results = append(outcome.Results, HandlerResult{Err: fmt.Errorf("writing sink: %w", err)})
+	}
+}
+
+// runHandler runs h, turning a panic into an error.
+func runHandler(ctx context.Context, h Handler, evt shared.Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+
+	return h(ctx, evt)
+}
+
+// outcomeContext returns ctx carrying the IDs of the outcome's event.
+func outcomeContext(ctx context.Context, outcome Outcome) context.Context {
+	return logger.WithIDs(logger.WithHeaders(ctx, outcome.Headers), logger.IDs{
+		CorrelationID: outcome.Event.CorrelationID,
+		ExecutionID:   outcome.Event.ExecutionID,
+	})
+}
+
+func (c *Consumer) report(ctx context.Context, outcome Outcome) {
+	c.mu.Lock()
+	c.stats[outcome.Status]++
+	onOutcomes := c.onOutcomes
+	c.mu.Unlock()
+
+	evt := outcome.Event
+
+	if err := outcome.Err(); err != nil {
+		c.customLogger.LogContext(ctx, "error", fmt.Sprintf("event %s %s in %s (acked: %t): %v", evt.ExecutionID, outcome.Status, outcome.Duration, outcome.Acked, err), err)
+	} else {
+		c.customLogger.LogContext(ctx, "kafka", fmt.Sprintf("event %s %s in %s by %d handler(s)", evt.ExecutionID, outcome.Status, outcome.Duration, len(outcome.Results)), nil)
+	}
 
+	for _, fn := range onOutcomes {
+		fn(outcome)
 	}
 }
@@ -0,0 +1,167 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+func discardLogger() *logger.CustomLogger {
+	customLogger := logger.New("consumer")
+	customLogger.SetOutput(io.Discard)
+	return customLogger
+}
+
+func publishEvents(t *testing.T, bus broker.Broker, correlationIDs ...string) {
+	t.Helper()
+
+	for _, correlationID := range correlationIDs {
+		evt := shared.Event{CorrelationID: correlationID, ExecutionID: "exec-" + correlationID, ServiceName: "payments", Type: shared.EventTypeCommitted}
+		data, _ := json.Marshal(evt)
+
+		if err := bus.Publish(context.Background(), "e_topic", broker.Message{Key: []byte(correlationID), Value: data}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+}
+
+// runConsumer runs c until it reported n outcomes, returning them.
+func runConsumer(t *testing.T, c *Consumer, n int) []Outcome {
+	t.Helper()
+
+	outcomes := make(chan Outcome, 100)
+	c.OnOutcome(func(outcome Outcome) { outcomes <- outcome })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); c.Run(ctx) }()
+
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	var got []Outcome
+	timeout := time.After(10 * time.Second)
+
+	for len(got) < n {
+		select {
+		case outcome := <-outcomes:
+			got = append(got, outcome)
+		case <-timeout:
+			t.Fatalf("got %d outcomes, want %d", len(got), n)
+		}
+	}
+
+	return got
+}
+
+func TestConsumerHoldsCommitsBehindFailedEvent(t *testing.T) {
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	publishEvents(t, bus, "a", "b")
+
+	failing := NewRegistry()
+	failing.Register("fail-a", Any, Any, func(ctx context.Context, evt shared.Event) error {
+		if evt.CorrelationID == "a" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	cfg := Config{Group: "billing", MaxAttempts: 1, Handlers: failing}
+
+	first := runConsumer(t, New(cfg, bus, discardLogger()), 2)
+	if first[0].Status != StatusFailed || first[1].Status != StatusProcessed {
+		t.Fatalf("first run statuses %s and %s, want failed and processed", first[0].Status, first[1].Status)
+	}
+
+	// b succeeded after a failed, but committing it would commit a too.
+	cfg.Handlers = NewRegistry()
+	second := runConsumer(t, New(cfg, bus, discardLogger()), 2)
+
+	if second[0].Event.CorrelationID != "a" || second[0].Status != StatusUnhandled {
+		t.Fatalf("second run got %s %s first, want a redelivered", second[0].Event.CorrelationID, second[0].Status)
+	}
+}
+
+func TestConsumerPausesBehindFailedEvent(t *testing.T) {
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	publishEvents(t, bus, "a", "b", "c", "d")
+
+	failing := NewRegistry()
+	failing.Register("fail-a", Any, Any, func(ctx context.Context, evt shared.Event) error {
+		if evt.CorrelationID == "a" {
+			return errors.New("boom")
+		}
+		return nil
+	})
+
+	c := New(Config{Group: "billing", MaxAttempts: 1, MaxPending: 2, ReadBackoff: 10 * time.Millisecond, Handlers: failing}, bus, discardLogger())
+
+	outcomes := make(chan Outcome, 100)
+	c.OnOutcome(func(outcome Outcome) { outcomes <- outcome })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); c.Run(ctx) }()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	for _, want := range []string{"a", "b"} {
+		select {
+		case outcome := <-outcomes:
+			if outcome.Event.CorrelationID != want {
+				t.Fatalf("got %s, want %s", outcome.Event.CorrelationID, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("no outcome for %s", want)
+		}
+	}
+
+	// a holds back the commit of b, so no more events are read.
+	select {
+	case outcome := <-outcomes:
+		t.Fatalf("read %s past the pending limit", outcome.Event.CorrelationID)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestConsumerSerializesCorrelation(t *testing.T) {
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	publishEvents(t, bus, "a", "a", "a", "a")
+
+	var running, overlaps atomic.Int32
+
+	handlers := NewRegistry()
+	handlers.Register("slow", Any, Any, func(ctx context.Context, evt shared.Event) error {
+		if running.Add(1) > 1 {
+			overlaps.Add(1)
+		}
+		time.Sleep(10 * time.Millisecond)
+		running.Add(-1)
+		return nil
+	})
+
+	runConsumer(t, New(Config{Group: "billing", Concurrency: 4, Handlers: handlers}, bus, discardLogger()), 4)
+
+	if n := overlaps.Load(); n > 0 {
+		t.Errorf("%d events of the same correlation handled concurrently", n)
+	}
+}
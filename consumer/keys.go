@@ -0,0 +1,49 @@
+package consumer
+
+import (
+	"sync"
+)
+
+// keyLocks serializes the processing of events sharing a key, so reading
+// and updating the sink for a correlation isn't interleaved. The monitor
+// keys events by correlation ID, so they share a partition, and with it a
+// consumer.
+type keyLocks struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	mu sync.Mutex
+	// refs counts the holders and waiters, the lock is dropped at zero.
+	refs int
+}
+
+func newKeyLocks() *keyLocks {
+	return &keyLocks{locks: make(map[string]*keyLock)}
+}
+
+// lock locks key and returns its unlock function.
+func (l *keyLocks) lock(key string) func() {
+	l.mu.Lock()
+	kl, ok := l.locks[key]
+	if !ok {
+		kl = &keyLock{}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+
+	kl.mu.Lock()
+
+	return func() {
+		kl.mu.Unlock()
+
+		l.mu.Lock()
+		kl.refs--
+		if kl.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
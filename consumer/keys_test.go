@@ -0,0 +1,41 @@
+package consumer
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeyLocksSerialize(t *testing.T) {
+	keys := newKeyLocks()
+
+	var running, overlaps atomic.Int32
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			unlock := keys.lock("corr-1")
+			defer unlock()
+
+			if running.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(time.Millisecond)
+			running.Add(-1)
+		}()
+	}
+
+	wg.Wait()
+
+	if n := overlaps.Load(); n > 0 {
+		t.Errorf("%d overlapping holders of the same key", n)
+	}
+
+	if n := len(keys.locks); n != 0 {
+		t.Errorf("%d locks left after every holder unlocked", n)
+	}
+}
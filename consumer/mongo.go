@@ -0,0 +1,177 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoSink is a Sink storing one document per correlation in Mongo.
+type MongoSink struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func NewMongoSink(ctx context.Context, uri, dbName, collectionName string) (*MongoSink, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	collection := client.Database(dbName).Collection(collectionName)
+
+	_, err = collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "service_name", Value: 1}, {Key: "updated_at", Value: -1}},
+	})
+	if err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	return &MongoSink{client: client, collection: collection}, nil
+}
+
+func (s *MongoSink) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+func (s *MongoSink) Get(ctx context.Context, correlationID string) (Record, bool, error) {
+	var rec Record
+
+	err := s.collection.FindOne(ctx, bson.M{"_id": correlationID}).Decode(&rec)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return Record{}, false, nil
+	}
+	if err != nil {
+		return Record{}, false, err
+	}
+
+	return rec, true, nil
+}
+
+func (s *MongoSink) Apply(ctx context.Context, rec Record) error {
+	set := bson.D{
+		{Key: "offset", Value: rec.Offset},
+		{Key: "execution_id", Value: rec.ExecutionID},
+		{Key: "service_name", Value: rec.ServiceName},
+		{Key: "event_type", Value: rec.EventType},
+		{Key: "status", Value: rec.Status},
+		{Key: "error", Value: rec.Error},
+		{Key: "requested_at", Value: rec.RequestedAt},
+		{Key: "updated_at", Value: rec.UpdatedAt},
+	}
+
+	if !rec.ProcessedAt.IsZero() {
+		set = append(set, bson.E{Key: "processed_at", Value: rec.ProcessedAt})
+	}
+
+	update := bson.D{
+		{Key: "$set", Value: set},
+		{Key: "$setOnInsert", Value: bson.D{{Key: "first_seen_at", Value: rec.UpdatedAt}}},
+		{Key: "$inc", Value: bson.D{{Key: "deliveries", Value: 1}}},
+	}
+
+	// Only a record at least as new as the stored one is applied. When the
+	// stored one is newer the filter doesn't match, and the upsert fails
+	// inserting a second document with the same ID.
+	filter := bson.M{
+		"_id": rec.CorrelationID,
+		"$or": bson.A{
+			bson.M{"offset": bson.M{"$lte": rec.Offset}},
+			bson.M{"offset": bson.M{"$exists": false}},
+		},
+	}
+
+	_, err := s.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+
+	_, err = s.collection.UpdateOne(ctx, bson.M{"_id": rec.CorrelationID}, bson.D{
+		{Key: "$inc", Value: bson.D{{Key: "deliveries", Value: 1}}},
+	})
+
+	return err
+}
+
+func (s *MongoSink) ByService(ctx context.Context, serviceName string, limit, offset int) ([]Record, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "updated_at", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := s.collection.Find(ctx, bson.M{"service_name": serviceName}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []Record
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (s *MongoSink) Services(ctx context.Context) ([]ServiceState, error) {
+	groupStage := bson.D{
+		{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.D{
+				{Key: "service_name", Value: "$service_name"},
+				{Key: "status", Value: "$status"},
+			}},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}},
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{groupStage})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	states := make(map[string]*ServiceState)
+
+	for cursor.Next(ctx) {
+		var result struct {
+			ID struct {
+				ServiceName string `bson:"service_name"`
+				Status      string `bson:"status"`
+			} `bson:"_id"`
+			Count int `bson:"count"`
+		}
+
+		if err := cursor.Decode(&result); err != nil {
+			return nil, err
+		}
+
+		state, ok := states[result.ID.ServiceName]
+		if !ok {
+			state = &ServiceState{ServiceName: result.ID.ServiceName, Statuses: make(map[string]int)}
+			states[result.ID.ServiceName] = state
+		}
+
+		state.Total += result.Count
+		state.Statuses[result.ID.Status] += result.Count
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	services := make([]ServiceState, 0, len(states))
+	for _, state := range states {
+		services = append(services, *state)
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ServiceName < services[j].ServiceName
+	})
+
+	return services, nil
+}
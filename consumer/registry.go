@@ -0,0 +1,89 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// Any matches every service name or event type when registering a handler.
+const Any = "*"
+
+// Handler processes one event. Handlers may run more than once for the same
+// event when they fail or the event is redelivered, so they must be idempotent.
+type Handler func(ctx context.Context, evt shared.Event) error
+
+type registration struct {
+	name        string
+	serviceName string
+	eventType   string
+	handler     Handler
+}
+
+// Registry holds the handlers run for each event, selected by the event's
+// service name and type.
+type Registry struct {
+	mu            sync.RWMutex
+	registrations []registration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a handler for events of eventType originating from
+// serviceName. Either may be Any. name identifies the handler in outcomes
+// and must be unique.
+func (r *Registry) Register(name, serviceName, eventType string, h Handler) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, reg := range r.registrations {
+		if reg.name == name {
+			return fmt.Errorf("handler %q already registered", name)
+		}
+	}
+
+	r.registrations = append(r.registrations, registration{
+		name:        name,
+		serviceName: serviceName,
+		eventType:   eventType,
+		handler:     h,
+	})
+
+	return nil
+}
+
+// match returns the handlers registered for evt, in registration order.
+func (r *Registry) match(evt shared.Event) []registration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var matched []registration
+	for _, reg := range r.registrations {
+		if (reg.serviceName == Any || reg.serviceName == evt.ServiceName) &&
+			(reg.eventType == Any || reg.eventType == evt.Type) {
+			matched = append(matched, reg)
+		}
+	}
+
+	return matched
+}
+
+// DefaultRegistry returns the handlers the consumer runs out of the box.
+func DefaultRegistry(customLogger *logger.CustomLogger) *Registry {
+	r := NewRegistry()
+	r.Register("log", Any, Any, LogHandler(customLogger))
+	return r
+}
+
+// LogHandler returns a handler that only logs the events it receives.
+func LogHandler(customLogger *logger.CustomLogger) Handler {
+	return func(ctx context.Context, evt shared.Event) error {
+		customLogger.LogContext(ctx, "kafka", fmt.Sprintf("consumed %s event %s from %s", evt.Type, evt.ExecutionID, evt.ServiceName), nil)
+		return nil
+	}
+}
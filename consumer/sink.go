@@ -0,0 +1,146 @@
+package consumer
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Record is the materialized state of one correlation.
+type Record struct {
+	CorrelationID string `json:"correlation_id" bson:"_id"`
+	ExecutionID   string `json:"execution_id" bson:"execution_id"`
+	ServiceName   string `json:"service_name" bson:"service_name"`
+	EventType     string `json:"event_type" bson:"event_type"`
+	Status        string `json:"status" bson:"status"`
+	Error         string `json:"error,omitempty" bson:"error,omitempty"`
+	// Offset is the offset of the event the record was last updated from.
+	// The monitor keys events by correlation ID, so the events of a
+	// correlation share a partition and their offsets order them.
+	Offset int64 `json:"offset" bson:"offset"`
+	// Deliveries counts every time the event was received, redeliveries included.
+	Deliveries  int       `json:"deliveries" bson:"deliveries"`
+	RequestedAt time.Time `json:"requested_at" bson:"requested_at"`
+	FirstSeenAt time.Time `json:"first_seen_at" bson:"first_seen_at"`
+	UpdatedAt   time.Time `json:"updated_at" bson:"updated_at"`
+	// ProcessedAt is when the handlers first succeeded.
+	ProcessedAt time.Time `json:"processed_at,omitempty" bson:"processed_at,omitempty"`
+}
+
+// ServiceState summarizes the records of one service.
+type ServiceState struct {
+	ServiceName string         `json:"service_name" bson:"_id"`
+	Total       int            `json:"total" bson:"total"`
+	Statuses    map[string]int `json:"statuses" bson:"-"`
+}
+
+// Sink stores the materialized state of the consumed events.
+type Sink interface {
+	// Get returns the record of correlationID and whether it exists.
+	Get(ctx context.Context, correlationID string) (Record, bool, error)
+	// Apply upserts rec by correlation ID, counting one more delivery.
+	// FirstSeenAt is kept from the first delivery and ProcessedAt is only
+	// changed when set on rec. A rec from an event older than the stored
+	// one, by Offset, only counts the delivery, so an event finishing late
+	// doesn't overwrite a newer state.
+	Apply(ctx context.Context, rec Record) error
+	// ByService returns up to limit records of serviceName, most recent
+	// first, skipping the first offset ones.
+	ByService(ctx context.Context, serviceName string, limit, offset int) ([]Record, error)
+	// Services returns a summary of every service with records.
+	Services(ctx context.Context) ([]ServiceState, error)
+}
+
+// MemorySink is a Sink kept in memory.
+type MemorySink struct {
+	mu      sync.RWMutex
+	records map[string]Record
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{records: make(map[string]Record)}
+}
+
+func (s *MemorySink) Get(ctx context.Context, correlationID string) (Record, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.records[correlationID]
+	return rec, ok, nil
+}
+
+func (s *MemorySink) Apply(ctx context.Context, rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[rec.CorrelationID]; ok {
+		if rec.Offset < existing.Offset {
+			existing.Deliveries++
+			s.records[rec.CorrelationID] = existing
+			return nil
+		}
+
+		rec.Deliveries = existing.Deliveries
+		rec.FirstSeenAt = existing.FirstSeenAt
+		if rec.ProcessedAt.IsZero() {
+			rec.ProcessedAt = existing.ProcessedAt
+		}
+	} else {
+		rec.Deliveries = 0
+		rec.FirstSeenAt = rec.UpdatedAt
+	}
+
+	rec.Deliveries++
+	s.records[rec.CorrelationID] = rec
+
+	return nil
+}
+
+func (s *MemorySink) ByService(ctx context.Context, serviceName string, limit, offset int) ([]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var records []Record
+	for _, rec := range s.records {
+		if rec.ServiceName == serviceName {
+			records = append(records, rec)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].UpdatedAt.After(records[j].UpdatedAt)
+	})
+
+	records = records[min(offset, len(records)):]
+
+	return records[:min(limit, len(records))], nil
+}
+
+func (s *MemorySink) Services(ctx context.Context) ([]ServiceState, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make(map[string]*ServiceState)
+	for _, rec := range s.records {
+		state, ok := states[rec.ServiceName]
+		if !ok {
+			state = &ServiceState{ServiceName: rec.ServiceName, Statuses: make(map[string]int)}
+			states[rec.ServiceName] = state
+		}
+
+		state.Total++
+		state.Statuses[rec.Status]++
+	}
+
+	services := make([]ServiceState, 0, len(states))
+	for _, state := range states {
+		services = append(services, *state)
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		return services[i].ServiceName < services[j].ServiceName
+	})
+
+	return services, nil
+}
@@ -0,0 +1,32 @@
+package consumer
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemorySinkKeepsNewerRecord(t *testing.T) {
+	ctx := context.Background()
+	sink := NewMemorySink()
+	now := time.Now()
+
+	sink.Apply(ctx, Record{CorrelationID: "a", Status: StatusProcessed, Offset: 5, UpdatedAt: now})
+	// An older event finishing late.
+	sink.Apply(ctx, Record{CorrelationID: "a", Status: StatusFailed, Offset: 3, UpdatedAt: now.Add(time.Second)})
+
+	rec, _, _ := sink.Get(ctx, "a")
+	if rec.Status != StatusProcessed || rec.Offset != 5 {
+		t.Errorf("record %s at offset %d, want processed at 5", rec.Status, rec.Offset)
+	}
+	if rec.Deliveries != 2 {
+		t.Errorf("deliveries = %d, want 2", rec.Deliveries)
+	}
+
+	// A redelivery of the same event is applied.
+	sink.Apply(ctx, Record{CorrelationID: "a", Status: StatusFailed, Offset: 5, UpdatedAt: now.Add(2 * time.Second)})
+
+	if rec, _, _ = sink.Get(ctx, "a"); rec.Status != StatusFailed {
+		t.Errorf("status = %s after a redelivery, want failed", rec.Status)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"log"
 
+	"github.com/assimoes/rtd-sandbox/redact"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
 	"go.mongodb.org/mongo-driver/bson"
@@ -19,17 +20,25 @@ import (
 type LogEntry struct {
 	ExecutionID   string `json:"execution_id"`
 	CorrelationID string `json:"correlation_id"`
+	TraceID       string `json:"trace_id,omitempty"`
 	Message       string `json:"message"`
 }
 
 // LogData contains the log data to be stored.
 type LogData struct {
-	Container    string      `json:"container"`
-	FriendlyName string      `json:"friendly_name"`
-	Timestamp    string      `json:"timestamp"`
-	Target       string      `json:"target"`
-	Log          LogEntry    `json:"log"`
-	Error        interface{} `json:"error,omitempty"`
+	Container    string                 `json:"container"`
+	FriendlyName string                 `json:"friendly_name"`
+	Timestamp    string                 `json:"timestamp"`
+	Target       string                 `json:"target"`
+	Level        string                 `json:"level,omitempty"`
+	Log          LogEntry               `json:"log"`
+	Fields       map[string]interface{} `json:"fields,omitempty"`
+	Error        interface{}            `json:"error,omitempty"`
+}
+
+// logCollection is where log lines are stored, a Mongo collection.
+type logCollection interface {
+	UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
 }
 
 // getLogHash computes a hash based on the container ID and log line.
@@ -40,7 +49,7 @@ func getLogHash(containerID, logLine string) string {
 }
 
 // processLogs fetches and processes logs for the specified container.
-func processLogs(ctx context.Context, cli *client.Client, collection *mongo.Collection, container types.Container) {
+func processLogs(ctx context.Context, cli *client.Client, collection logCollection, redactor *redact.Redactor, container types.Container) {
 	containerName := getContainerName(container)
 
 	reader, err := cli.ContainerLogs(ctx, container.ID, types.ContainerLogsOptions{ShowStdout: true, Follow: true})
@@ -56,7 +65,7 @@ func processLogs(ctx context.Context, cli *client.Client, collection *mongo.Coll
 	scanner.Buffer(buf, 10*1024*1024)
 
 	for scanner.Scan() {
-		handleLogEntry(ctx, collection, scanner.Text(), container.ID, containerName)
+		handleLogEntry(ctx, collection, redactor, scanner.Text(), container.ID, containerName)
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -65,8 +74,9 @@ func processLogs(ctx context.Context, cli *client.Client, collection *mongo.Coll
 
 }
 
-// handleLogEntry processes and stores a log line.
-func handleLogEntry(ctx context.Context, collection *mongo.Collection, logLineStr, containerID, containerName string) {
+// handleLogEntry processes and stores a log line, redacted again in case it
+// was written without redaction.
+func handleLogEntry(ctx context.Context, collection logCollection, redactor *redact.Redactor, logLineStr, containerID, containerName string) {
 	var parsedLog map[string]interface{}
 
 	if len(logLineStr) < 10 {
@@ -80,20 +90,26 @@ func handleLogEntry(ctx context.Context, collection *mongo.Collection, logLineSt
 		return
 	}
 
+	redactor.Value(parsedLog)
+
 	timestamp, _ := parsedLog["timestamp"].(string)
 	target, _ := parsedLog["target"].(string)
-	logError := parsedLog["error"]
+	level, _ := parsedLog["level"].(string)
+	traceID, _ := parsedLog["trace_id"].(string)
+	fields, _ := parsedLog["fields"].(map[string]interface{})
+	logError := errorDocument(parsedLog["error"])
 
 	msg, err := toJSONString(parsedLog["log"])
 
 	if err != nil {
-		log.Println("failed to decode log entry: %v", err)
+		log.Printf("failed to decode log entry: %v", err)
 		return
 	}
 
 	logEntry := LogEntry{
 		ExecutionID:   parsedLog["execution_id"].(string),
 		CorrelationID: parsedLog["correlation_id"].(string),
+		TraceID:       traceID,
 		Message:       msg,
 	}
 
@@ -102,13 +118,15 @@ func handleLogEntry(ctx context.Context, collection *mongo.Collection, logLineSt
 		FriendlyName: containerName,
 		Timestamp:    timestamp,
 		Target:       target,
+		Level:        level,
 		Log:          logEntry,
+		Fields:       fields,
 		Error:        logError,
 	}
 
 	hash := getLogHash(containerID, logLineStr)
-	filter := bson.D{{"_id", hash}}
-	update := bson.D{{"$setOnInsert", logData}}
+	filter := bson.D{{Key: "_id", Value: hash}}
+	update := bson.D{{Key: "$setOnInsert", Value: logData}}
 
 	_, err = collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
 	if err != nil {
@@ -116,6 +134,21 @@ func handleLogEntry(ctx context.Context, collection *mongo.Collection, logLineSt
 	}
 }
 
+// errorDocument returns the error of a log line as stored: the structured
+// error written by the logger as is, and older plain errors as its message.
+func errorDocument(v interface{}) interface{} {
+	switch e := v.(type) {
+	case nil:
+		return nil
+	case map[string]interface{}:
+		return e
+	case string:
+		return map[string]interface{}{"message": e}
+	default:
+		return map[string]interface{}{"message": fmt.Sprint(e)}
+	}
+}
+
 func toJSONString(v interface{}) (string, error) {
 	bytes, err := json.Marshal(v)
 	if err != nil {
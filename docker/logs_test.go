@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/assimoes/rtd-sandbox/redact"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// recordingCollection keeps the updates it is given.
+type recordingCollection struct {
+	updates []interface{}
+}
+
+func (c *recordingCollection) UpdateOne(ctx context.Context, filter interface{}, update interface{}, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	c.updates = append(c.updates, update)
+	return &mongo.UpdateResult{UpsertedCount: 1}, nil
+}
+
+func TestHandleLogEntryRedacts(t *testing.T) {
+	redactor, err := redact.New(redact.DefaultRules, "test-key")
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// Docker prefixes every line with an 8 byte stream header.
+	line := "\x01\x00\x00\x00\x00\x00\x00\x00" + `{"execution_id":"exec-1","correlation_id":"corr-1","timestamp":"2024-01-01T00:00:00Z","target":"consumer",` +
+		`"log":{"execution_id":"exec-1","message":"mailing ann@example.com"},` +
+		`"fields":{"user_id":"user-1234","payload":{"card":"4111111111111111"}},` +
+		`"error":{"message":"no account for bob@example.com"}}`
+
+	collection := &recordingCollection{}
+	handleLogEntry(context.Background(), collection, redactor, line, "container-1", "consumer")
+
+	if len(collection.updates) != 1 {
+		t.Fatalf("%d updates, want 1", len(collection.updates))
+	}
+
+	stored, err := bson.MarshalExtJSON(collection.updates[0], false, false)
+	if err != nil {
+		t.Fatalf("MarshalExtJSON: %v", err)
+	}
+
+	for _, raw := range []string{"user-1234", "4111111111111111", "ann@example.com", "bob@example.com"} {
+		if strings.Contains(string(stored), raw) {
+			t.Errorf("stored %s, containing %q", stored, raw)
+		}
+	}
+
+	if !strings.Contains(string(stored), redactor.Hash("user-1234")) {
+		t.Errorf("stored %s, without the user_id hash", stored)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 
+	"github.com/assimoes/rtd-sandbox/redact"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/filters"
 )
@@ -27,6 +28,11 @@ func main() {
 		log.Fatalf("Failed to fetch container list: %v", err)
 	}
 
+	redactor, err := redact.FromEnv()
+	if err != nil {
+		log.Fatalf("Failed to load redaction rules: %v", err)
+	}
+
 	mongoClient, collection, err := connectToMongoDB(ctx)
 	if err != nil {
 		log.Fatalf("Failed to connect to mongodb: %v", err)
@@ -34,7 +40,7 @@ func main() {
 	defer mongoClient.Disconnect(ctx)
 
 	for _, container := range containers {
-		go processLogs(ctx, cli, collection, container)
+		go processLogs(ctx, cli, collection, redactor, container)
 	}
 
 	select {}
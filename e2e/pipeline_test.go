@@ -0,0 +1,143 @@
+// Package e2e runs the whole pipeline in process, over the in-memory broker.
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/consumer"
+	"github.com/assimoes/rtd-sandbox/forwarder"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/monitor"
+	"github.com/assimoes/rtd-sandbox/producer"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// pipeline is a producer, forwarder, monitor and consumer sharing a memory
+// broker.
+type pipeline struct {
+	producer *producer.Producer
+	outcomes chan consumer.Outcome
+}
+
+func startPipeline(t *testing.T) *pipeline {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	newLogger := func(name string) *logger.CustomLogger {
+		customLogger := logger.New(name)
+		customLogger.SetOutput(io.Discard)
+		return customLogger
+	}
+
+	f := forwarder.New(forwarder.Config{FriendlyName: "forwarder", Group: "forwarder"}, bus, newLogger("forwarder"))
+	forwarderServer := httptest.NewServer(f.Handler())
+	t.Cleanup(forwarderServer.Close)
+
+	m, err := monitor.New(monitor.Config{FriendlyName: "monitor", Group: "monitor"}, bus, newLogger("monitor"))
+	if err != nil {
+		t.Fatalf("monitor.New: %v", err)
+	}
+
+	c := consumer.New(consumer.Config{FriendlyName: "consumer", Group: "consumer", AckTopic: "acks"}, bus, newLogger("consumer"))
+
+	outcomes := make(chan consumer.Outcome, 16)
+	c.OnOutcome(func(outcome consumer.Outcome) { outcomes <- outcome })
+
+	commitAll := 1.0
+	mux := http.NewServeMux()
+	producerServer := httptest.NewServer(mux)
+	t.Cleanup(producerServer.Close)
+
+	p := producer.New(producer.Config{
+		ForwarderURL: forwarderServer.URL,
+		ServiceName:  "payments",
+		CallbackURL:  producerServer.URL + "/callback",
+		Scenario: &producer.Scenario{
+			Name:   "commit-all",
+			Phases: []producer.Phase{{CommitRatio: &commitAll}},
+		},
+	}, newLogger("payments"))
+	mux.Handle("/", p.Handler())
+
+	go f.Run(ctx)
+	go m.Run(ctx)
+	go c.Run(ctx)
+
+	return &pipeline{producer: p, outcomes: outcomes}
+}
+
+// outcome waits for the consumer's outcome of correlationID.
+func (p *pipeline) outcome(t *testing.T, correlationID string) consumer.Outcome {
+	t.Helper()
+
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case outcome := <-p.outcomes:
+			if outcome.Event.CorrelationID == correlationID {
+				return outcome
+			}
+		case <-timeout:
+			t.Fatalf("no event for %s reached the consumer", correlationID)
+		}
+	}
+}
+
+func TestPipelinePropagatesHeaders(t *testing.T) {
+	p := startPipeline(t)
+
+	ctx := logger.WithIDs(context.Background(), logger.IDs{TraceID: "trace-e2e"})
+
+	executionID, correlationID, err := p.producer.Fire(ctx, producer.FireRequest{
+		Payload:    json.RawMessage(`{"amount":42}`),
+		Attributes: map[string]string{"region": "eu"},
+	})
+	if err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+
+	outcome := p.outcome(t, correlationID)
+	evt, headers := outcome.Event, outcome.Headers
+
+	if evt.ExecutionID != executionID || headers.ExecutionID != executionID {
+		t.Errorf("execution ID = %q (headers %q), want %q", evt.ExecutionID, headers.ExecutionID, executionID)
+	}
+
+	if headers.CorrelationID != correlationID {
+		t.Errorf("headers correlation ID = %q, want %q", headers.CorrelationID, correlationID)
+	}
+
+	if headers.TraceID != "trace-e2e" {
+		t.Errorf("trace ID = %q, want trace-e2e", headers.TraceID)
+	}
+
+	// The forwarder publishes the commit request, the monitor forwards it
+	// as an event.
+	if headers.OriginService != "monitor" || headers.Hops != 2 {
+		t.Errorf("origin %q after %d hops, want monitor after 2", headers.OriginService, headers.Hops)
+	}
+
+	if headers.ContentType != shared.ContentTypeEvent {
+		t.Errorf("content type = %q, want %q", headers.ContentType, shared.ContentTypeEvent)
+	}
+
+	if evt.ServiceName != "payments" || evt.Type != shared.EventTypeCommitted {
+		t.Errorf("event from %q of type %q, want a committed event from payments", evt.ServiceName, evt.Type)
+	}
+
+	if string(evt.Payload) != `{"amount":42}` || evt.Attributes["region"] != "eu" {
+		t.Errorf("event payload %s, attributes %v, want the request's", evt.Payload, evt.Attributes)
+	}
+}
@@ -1,57 +1,215 @@
-package main
+package forwarder
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/assimoes/rtd-sandbox/blob"
+	"github.com/assimoes/rtd-sandbox/broker"
 	"github.com/assimoes/rtd-sandbox/logger"
 	"github.com/assimoes/rtd-sandbox/shared"
 	"github.com/google/uuid"
-	"github.com/segmentio/kafka-go"
 )
 
-var (
-	broker       = shared.GetEnv("KAFKA_BROKER", "localhost:9092")
-	friendlyName = shared.GetEnv("FRIENDLY_NAME", "forwarder")
+// Config configures a Forwarder.
+type Config struct {
+	FriendlyName string
+	// Group is the consumer group used for the ack topic.
+	Group string
+	// AckTopic carries the consumer acknowledgements that close each
+	// execution. Defaults to "acks".
+	AckTopic string
+	// MaxPayloadBytes bounds the payload of a data request, 256KiB by
+	// default.
+	MaxPayloadBytes int
+	// MaxAttributes bounds the attributes of a data request, 32 by default.
+	MaxAttributes int
+	// Blobs, when set, holds the payloads larger than OffloadBytes, which are
+	// then published as a shared.DataRequest.PayloadRef, and the data
+	// requests until they are decided. Payloads are deleted once their
+	// execution is cancelled, and otherwise after BlobRetention, so every
+	// consumer group and replay of the event can still read them.
+	Blobs blob.Store
+	// BlobRetention is how long the blobs are kept, 7 days by default. It
+	// should be at least the retention of the event topics.
+	BlobRetention time.Duration
+	// OffloadBytes is the payload size above which payloads go to Blobs,
+	// 64KiB by default.
+	OffloadBytes int
+	// IdempotencyTTL is how long an accepted data request is remembered by
+	// its Idempotency-Key header, answering its retries with the same
+	// correlation ID. Defaults to 10m.
+	IdempotencyTTL time.Duration
+	// ReadBackoff is the wait after a failed read of the ack topic, doubled
+	// on every consecutive failure up to MaxReadBackoff. Defaults to 100ms
+	// and 10s.
+	ReadBackoff    time.Duration
+	MaxReadBackoff time.Duration
+}
+
+// readErrorLimit bounds the read errors logged, such as while the broker is
+// unreachable.
+var readErrorLimit = logger.Limit{Burst: 3, Interval: 30 * time.Second}
+
+// Forwarder accepts data and commit requests over HTTP and publishes them to
+// the control, commit and cancel topics.
+type Forwarder struct {
+	cfg          Config
+	bus          broker.Broker
 	customLogger *logger.CustomLogger
-)
+	accepted     *acceptedRequests
+}
+
+func New(cfg Config, bus broker.Broker, customLogger *logger.CustomLogger) *Forwarder {
+	if cfg.AckTopic == "" {
+		cfg.AckTopic = "acks"
+	}
 
-func main() {
+	if cfg.MaxPayloadBytes <= 0 {
+		cfg.MaxPayloadBytes = 256 << 10
+	}
 
-	customLogger = logger.New(friendlyName)
+	if cfg.MaxAttributes <= 0 {
+		cfg.MaxAttributes = 32
+	}
 
-	http.HandleFunc("/request", request)
+	if cfg.OffloadBytes <= 0 {
+		cfg.OffloadBytes = 64 << 10
+	}
 
-	http.HandleFunc("/commit", commit)
+	if cfg.IdempotencyTTL <= 0 {
+		cfg.IdempotencyTTL = 10 * time.Minute
+	}
 
-	serverAddress := ":3000"
+	if cfg.BlobRetention <= 0 {
+		cfg.BlobRetention = 7 * 24 * time.Hour
+	}
+
+	if cfg.ReadBackoff <= 0 {
+		cfg.ReadBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxReadBackoff <= 0 {
+		cfg.MaxReadBackoff = 10 * time.Second
+	}
 
-	if err := http.ListenAndServe(serverAddress, nil); err != nil {
-		customLogger.Log("error", fmt.Sprintf("error starting forwarder: %v", err), err, "", "")
+	return &Forwarder{
+		cfg:          cfg,
+		bus:          bus,
+		customLogger: customLogger,
+		accepted:     newAcceptedRequests(cfg.IdempotencyTTL),
 	}
 }
 
-func request(w http.ResponseWriter, r *http.Request) {
+// Handler returns the forwarder's HTTP routes. Requests carry their trace
+// ID, taken from the X-Trace-Id header or new, into the published messages.
+func (f *Forwarder) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/request", f.request)
+
+	mux.HandleFunc("/commit", f.commit)
+
+	mux.Handle("/loglevel", f.customLogger.LevelHandler())
+
+	return logger.Middleware(mux)
+}
+
+func (f *Forwarder) request(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
+	key := r.Header.Get(shared.HTTPHeaderIdempotencyKey)
+	if key == "" {
+		f.accept(w, r)
+		return
+	}
+
+	for {
+		req, first := f.accepted.begin(key)
+		if first {
+			correlationID, accepted := f.accept(w, r)
+			f.accepted.finish(key, req, correlationID, accepted)
+			return
+		}
+
+		select {
+		case <-req.done:
+		case <-r.Context().Done():
+			return
+		}
+
+		// The first request failed, this one is handled instead.
+		if !req.accepted {
+			continue
+		}
+
+		ctx := logger.WithIDs(r.Context(), logger.IDs{CorrelationID: req.correlationID})
+		f.customLogger.LogContext(ctx, "kafka", fmt.Sprintf("Repeated request %s already accepted with correlation ID: %s", key, req.correlationID), nil)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(shared.DataResponse{Status: "OK", CorrelationID: req.correlationID})
+		return
+	}
+}
+
+// accept publishes a data request, returning its correlation ID and whether
+// it was accepted.
+func (f *Forwarder) accept(w http.ResponseWriter, r *http.Request) (string, bool) {
 	correlationID := uuid.New().String()
+	ctx := logger.WithIDs(r.Context(), logger.IDs{CorrelationID: correlationID})
 
 	var dataReq shared.DataRequest
 
-	if err := json.NewDecoder(r.Body).Decode(&dataReq); err != nil {
-		customLogger.Log("error", fmt.Sprintf("error decoding data request: %v", err), err, correlationID, dataReq.ExecutionID)
+	// The body is bounded with room for the other fields and attributes
+	// around the payload.
+	body := http.MaxBytesReader(w, r.Body, int64(f.cfg.MaxPayloadBytes)+64<<10)
+
+	if err := json.NewDecoder(body).Decode(&dataReq); err != nil {
+		f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error decoding data request: %v", err), err)
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return correlationID, false
+		}
+
 		w.WriteHeader(http.StatusBadRequest)
-		return
+		return correlationID, false
+	}
+
+	ctx = logger.WithIDs(ctx, logger.IDs{ExecutionID: dataReq.ExecutionID})
+
+	if err := validatePayload(f.cfg, &dataReq); err != nil {
+		f.customLogger.LogContext(ctx, "error", fmt.Sprintf("invalid data request: %v", err), err)
+
+		if errors.Is(err, errPayloadTooLarge) {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return correlationID, false
+		}
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return correlationID, false
 	}
 
 	dataReq.CorrelationID = correlationID
 
-	w.WriteHeader(http.StatusOK)
+	if f.cfg.Blobs != nil && len(dataReq.Payload) > f.cfg.OffloadBytes {
+		if err := f.cfg.Blobs.Put(ctx, correlationID, dataReq.Payload); err != nil {
+			f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error offloading payload: %v", err), err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return correlationID, false
+		}
+
+		f.customLogger.LogContext(ctx, "blob", fmt.Sprintf("Offloaded %d byte payload", len(dataReq.Payload)), nil)
+
+		dataReq.PayloadRef, dataReq.Payload = correlationID, nil
+	}
 
 	dataRes := shared.DataResponse{
 		Status:        "OK",
@@ -60,74 +218,253 @@ func request(w http.ResponseWriter, r *http.Request) {
 
 	topicData, _ := json.Marshal(dataReq)
 
-	if err := publish("control", []kafka.Message{
-		{
-			Partition: 0,
-			Key:       []byte(correlationID),
-			Value:     topicData,
-			Headers: []kafka.Header{
-				{Key: "execution_id", Value: []byte(dataReq.ExecutionID)},
-			},
-		},
-	}, customLogger); err != nil {
-		customLogger.Log("error", fmt.Sprintf("error when publishing to control topic: %v", err), err, correlationID, dataReq.ExecutionID)
-		w.WriteHeader(http.StatusBadRequest)
+	// The request is kept with the payloads, so its commit carries it to
+	// whichever monitor reads it, even one that never saw the control
+	// message.
+	if f.cfg.Blobs != nil {
+		if err := f.cfg.Blobs.Put(ctx, originKey(correlationID), topicData); err != nil {
+			f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error storing data request: %v", err), err)
+			if dataReq.PayloadRef != "" {
+				f.releasePayload(ctx, correlationID)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return correlationID, false
+		}
 	}
 
-	customLogger.Log("kafka", fmt.Sprintf("Published request with correlation ID: %s", correlationID), nil, correlationID, dataReq.ExecutionID)
+	if err := f.publish(ctx, "control", broker.Message{
+		Key:     []byte(correlationID),
+		Value:   topicData,
+		Headers: f.headers(ctx, shared.ContentTypeDataRequest).Encode(),
+	}); err != nil {
+		f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error when publishing to control topic: %v", err), err)
+		if dataReq.PayloadRef != "" {
+			f.releasePayload(ctx, correlationID)
+		}
+		f.releaseOrigin(ctx, correlationID)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return correlationID, false
+	}
+
+	f.customLogger.LogContext(ctx, "kafka", fmt.Sprintf("Published request with correlation ID: %s", correlationID), nil)
 
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(dataRes)
+
+	return correlationID, true
 }
 
-func commit(w http.ResponseWriter, r *http.Request) {
+func (f *Forwarder) commit(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	correlationID := uuid.New().String()
+	ctx := r.Context()
 
 	var commitReq shared.CommitRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&commitReq); err != nil {
-		customLogger.Log("error", fmt.Sprintf("error decoding commit request: %v", err), err, correlationID, commitReq.ExecutionID)
+		f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error decoding commit request: %v", err), err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
+	correlationID := commitReq.CorrelationID
+	ctx = logger.WithIDs(ctx, logger.IDs{CorrelationID: correlationID, ExecutionID: commitReq.ExecutionID})
+
 	var topic string
 	if commitReq.Commit {
 		topic = "commit"
-		customLogger.Log("kafka", fmt.Sprintf("publishing commit with correlation ID: %s", correlationID), nil, correlationID, commitReq.ExecutionID)
+		f.customLogger.LogContext(ctx, "kafka", fmt.Sprintf("publishing commit with correlation ID: %s", correlationID), nil)
 	} else {
 		topic = "cancel"
-		customLogger.Log("kafka", fmt.Sprintf("publishing cancel with correlation ID: %s", correlationID), nil, correlationID, commitReq.ExecutionID)
+		f.customLogger.LogContext(ctx, "kafka", fmt.Sprintf("publishing cancel with correlation ID: %s", correlationID), nil)
 	}
 
-	w.WriteHeader(http.StatusOK)
+	if commitReq.Commit {
+		commitReq.Request = f.origin(ctx, correlationID)
+	}
 
 	topicData, _ := json.Marshal(commitReq)
 
-	publish(topic, []kafka.Message{
-		{
-			Partition: 0,
-			Key:       []byte(correlationID),
-			Value:     topicData,
-		},
-	}, customLogger)
+	// The decision is only acknowledged once published, so the producer
+	// retries it otherwise.
+	if err := f.publish(ctx, topic, broker.Message{
+		Key:     []byte(correlationID),
+		Value:   topicData,
+		Headers: f.headers(ctx, shared.ContentTypeCommitRequest).Encode(),
+	}); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	// The decision carries the request from now on.
+	f.releaseOrigin(ctx, correlationID)
+
+	// A cancelled execution is over: nothing will read its payload.
+	if !commitReq.Commit {
+		f.releasePayload(ctx, correlationID)
+	}
+}
+
+// headers returns the headers of a message entering the pipeline for the
+// execution and trace carried by ctx.
+func (f *Forwarder) headers(ctx context.Context, contentType string) shared.Headers {
+	ids := logger.IDsFrom(ctx)
+
+	headers := shared.NewHeaders(f.cfg.FriendlyName, contentType, ids.CorrelationID, ids.ExecutionID)
+	headers.TraceID = ids.TraceID
+
+	return headers
+}
+
+// releasePayload deletes the offloaded payload of an execution, if any.
+func (f *Forwarder) releasePayload(ctx context.Context, correlationID string) {
+	if f.cfg.Blobs == nil || correlationID == "" {
+		return
+	}
+
+	if err := f.cfg.Blobs.Delete(ctx, correlationID); err != nil {
+		f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error deleting offloaded payload: %v", err), err)
+	}
+}
+
+// originKey is the blob key of the data request of an execution.
+func originKey(correlationID string) string {
+	return correlationID + ".origin"
+}
+
+// origin returns the stored data request of an execution, or nil when it
+// isn't stored.
+func (f *Forwarder) origin(ctx context.Context, correlationID string) *shared.DataRequest {
+	if f.cfg.Blobs == nil || correlationID == "" {
+		return nil
+	}
+
+	data, err := f.cfg.Blobs.Get(ctx, originKey(correlationID))
+	if errors.Is(err, blob.ErrNotFound) {
+		f.customLogger.LogContext(ctx, "blob", fmt.Sprintf("no data request stored for %s", correlationID), nil)
+		return nil
+	}
+	if err != nil {
+		f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error reading data request: %v", err), err)
+		return nil
+	}
+
+	var dataReq shared.DataRequest
+	if err := json.Unmarshal(data, &dataReq); err != nil {
+		f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error decoding stored data request: %v", err), err)
+		return nil
+	}
+
+	return &dataReq
 }
 
-func publish(topic string, messages []kafka.Message, customLogger *logger.CustomLogger) error {
-	k := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{broker},
-		Dialer:  kafka.DefaultDialer,
-		Topic:   topic,
-	})
+// releaseOrigin deletes the stored data request of an execution, if any.
+func (f *Forwarder) releaseOrigin(ctx context.Context, correlationID string) {
+	if f.cfg.Blobs == nil || correlationID == "" {
+		return
+	}
+
+	if err := f.cfg.Blobs.Delete(ctx, originKey(correlationID)); err != nil {
+		f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error deleting data request: %v", err), err)
+	}
+}
 
-	if err := k.WriteMessages(context.Background(), messages...); err != nil {
-		customLogger.Log("error", fmt.Sprintf("error when publishing to %s topic: %v", topic, err), err, "", "")
+func (f *Forwarder) publish(ctx context.Context, topic string, messages ...broker.Message) error {
+	if err := f.bus.Publish(ctx, topic, messages...); err != nil {
+		f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error when publishing to %s topic: %v", topic, err), err)
 		return err
 	}
 
 	return nil
 }
+
+// Run consumes consumer acknowledgements until ctx is done, logging how long
+// each execution took from the producer's request to its completion. It
+// also deletes the blobs older than the retention.
+func (f *Forwarder) Run(ctx context.Context) error {
+	sub, err := f.bus.Subscribe(f.cfg.AckTopic, broker.SubscribeOptions{Group: f.cfg.Group})
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	if f.cfg.Blobs != nil {
+		go f.sweepBlobs(ctx)
+	}
+
+	readLogger := f.customLogger.Limited("read "+f.cfg.AckTopic, readErrorLimit)
+
+	var backoff time.Duration
+
+	for {
+		msg, err := sub.Fetch(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			readLogger.LogContext(ctx, "error", fmt.Sprintf("error reading from %s topic: %v", f.cfg.AckTopic, err), err)
+
+			// Back off on consecutive errors, such as while the broker is
+			// unreachable, instead of failing in a tight loop.
+			backoff = min(max(2*backoff, f.cfg.ReadBackoff), f.cfg.MaxReadBackoff)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+
+			continue
+		}
+		backoff = 0
+
+		msgCtx := logger.WithHeaders(ctx, shared.ParseHeaders(msg.Headers))
+
+		var ack shared.Ack
+		if err := json.Unmarshal(msg.Value, &ack); err != nil {
+			f.customLogger.LogContext(msgCtx, "error", fmt.Sprintf("error decoding ack: %v", err), err)
+			sub.Commit(ctx, msg)
+			continue
+		}
+
+		msgCtx = logger.WithIDs(msgCtx, logger.IDs{CorrelationID: ack.CorrelationID, ExecutionID: ack.ExecutionID})
+
+		if ack.Status == shared.AckFailed {
+			f.customLogger.LogContext(msgCtx, "error", fmt.Sprintf("execution %s failed in %s after %.1fms (end to end %.1fms): %s", ack.ExecutionID, ack.Consumer, ack.ProcessingMs, ack.EndToEndMs, ack.Error), nil)
+		} else {
+			f.customLogger.LogContext(msgCtx, "kafka", fmt.Sprintf("execution %s %s by %s in %.1fms (end to end %.1fms)", ack.ExecutionID, ack.Status, ack.Consumer, ack.ProcessingMs, ack.EndToEndMs), nil)
+		}
+
+		sub.Commit(ctx, msg)
+	}
+}
+
+// sweepBlobs deletes the blobs older than the retention until ctx is done,
+// every tenth of the retention, but at most every second and at least
+// hourly.
+func (f *Forwarder) sweepBlobs(ctx context.Context) {
+	ticker := time.NewTicker(min(max(f.cfg.BlobRetention/10, time.Second), time.Hour))
+	defer ticker.Stop()
+
+	for {
+		deleted, err := f.cfg.Blobs.DeleteOlder(ctx, time.Now().Add(-f.cfg.BlobRetention))
+		if err != nil && ctx.Err() == nil {
+			f.customLogger.LogContext(ctx, "error", fmt.Sprintf("error deleting expired blobs: %v", err), err)
+		}
+		if deleted > 0 {
+			f.customLogger.LogContext(ctx, "blob", fmt.Sprintf("Deleted %d expired blobs", deleted), nil)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
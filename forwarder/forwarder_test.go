@@ -0,0 +1,318 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/blob"
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+func newForwarder(t *testing.T, cfg Config) (*Forwarder, *broker.Memory) {
+	t.Helper()
+
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	customLogger := logger.New("forwarder")
+	customLogger.SetOutput(io.Discard)
+
+	cfg.FriendlyName, cfg.Group = "forwarder", "forwarder"
+
+	return New(cfg, bus, customLogger), bus
+}
+
+func post(handler http.Handler, path, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	return rec
+}
+
+// postRequest posts a data request for payments, returning its correlation
+// ID.
+func postRequest(t *testing.T, handler http.Handler) string {
+	t.Helper()
+
+	rec := post(handler, "/request", `{"execution_id":"exec-1","service_name":"payments","payload":{"amount":42}}`, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("request status %d", rec.Code)
+	}
+
+	var res shared.DataResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+
+	return res.CorrelationID
+}
+
+func TestRequestDeduplicatesRetries(t *testing.T) {
+	f, bus := newForwarder(t, Config{})
+	handler := f.Handler()
+
+	sub, err := bus.Subscribe("control", broker.SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	header := http.Header{shared.HTTPHeaderIdempotencyKey: {"exec-1"}}
+	body := `{"execution_id":"exec-1","service_name":"payments"}`
+
+	var correlationIDs []string
+	for i := 0; i < 2; i++ {
+		rec := post(handler, "/request", body, header)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("attempt %d: status %d", i+1, rec.Code)
+		}
+
+		var res shared.DataResponse
+		json.NewDecoder(rec.Body).Decode(&res)
+		correlationIDs = append(correlationIDs, res.CorrelationID)
+	}
+
+	if correlationIDs[0] == "" || correlationIDs[0] != correlationIDs[1] {
+		t.Errorf("correlation IDs %v, want the same one twice", correlationIDs)
+	}
+
+	// Only the first attempt is published.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	if _, err := sub.Fetch(ctx); err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if msg, err := sub.Fetch(ctx); err == nil {
+		t.Errorf("retry published a second data request: %s", msg.Value)
+	}
+
+	// Another key is another request.
+	rec := post(handler, "/request", `{"execution_id":"exec-2"}`, http.Header{shared.HTTPHeaderIdempotencyKey: {"exec-2"}})
+
+	var res shared.DataResponse
+	json.NewDecoder(rec.Body).Decode(&res)
+	if res.CorrelationID == correlationIDs[0] {
+		t.Errorf("another key got the same correlation ID")
+	}
+}
+
+func TestCommitFailsWhenNotPublished(t *testing.T) {
+	f, bus := newForwarder(t, Config{})
+	handler := f.Handler()
+
+	body := `{"correlation_id":"corr-1","execution_id":"exec-1","commit":true}`
+
+	if rec := post(handler, "/commit", body, nil); rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	bus.Close()
+
+	if rec := post(handler, "/commit", body, nil); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status %d with the broker closed, want 503", rec.Code)
+	}
+}
+
+func TestCommitCarriesRequest(t *testing.T) {
+	blobs := blob.NewMemory()
+	f, bus := newForwarder(t, Config{Blobs: blobs})
+	handler := f.Handler()
+
+	sub, err := bus.Subscribe("commit", broker.SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	correlationID := postRequest(t, handler)
+
+	body := `{"correlation_id":"` + correlationID + `","execution_id":"exec-1","commit":true}`
+	if rec := post(handler, "/commit", body, nil); rec.Code != http.StatusOK {
+		t.Fatalf("commit status %d", rec.Code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg, err := sub.Fetch(ctx)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	var commitReq shared.CommitRequest
+	json.Unmarshal(msg.Value, &commitReq)
+
+	if commitReq.Request == nil || commitReq.Request.ServiceName != "payments" || string(commitReq.Request.Payload) != `{"amount":42}` {
+		t.Fatalf("commit carries %+v, want the data request", commitReq.Request)
+	}
+
+	// Once decided, the request travels with the decision.
+	if _, err := blobs.Get(ctx, originKey(correlationID)); !errors.Is(err, blob.ErrNotFound) {
+		t.Errorf("stored request after the decision: %v, want ErrNotFound", err)
+	}
+}
+
+func TestPayloadOutlivesAcks(t *testing.T) {
+	blobs := blob.NewMemory()
+	f, bus := newForwarder(t, Config{Blobs: blobs, OffloadBytes: 1})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	correlationID := postRequest(t, f.Handler())
+
+	// Another consumer group, or a replay, may still read the payload.
+	ack, _ := json.Marshal(shared.Ack{CorrelationID: correlationID, ExecutionID: "exec-1", Status: shared.AckProcessed})
+	if err := bus.Publish(ctx, "acks", broker.Message{Value: ack}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	if _, err := blobs.Get(ctx, correlationID); err != nil {
+		t.Fatalf("payload after a processed ack: %v", err)
+	}
+}
+
+func TestExpiredBlobsAreDeleted(t *testing.T) {
+	blobs := blob.NewMemory()
+	// Retentions under ten ticks of the clock are swept every second.
+	f, _ := newForwarder(t, Config{Blobs: blobs, OffloadBytes: 1, BlobRetention: time.Nanosecond})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	// Nothing decides the execution, as when it fails.
+	postRequest(t, f.Handler())
+
+	deadline := time.Now().Add(5 * time.Second)
+	for blobs.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d blobs left after the retention", blobs.Len())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// scriptedBroker answers the fetches of its subscriptions with its script, a
+// read error or a message for each fetch, recording when they happen. Once
+// the script is over fetches block until ctx is done.
+type scriptedBroker struct {
+	broker.Broker
+
+	mu      sync.Mutex
+	script  []error
+	fetches []time.Time
+	// over is closed by the first fetch past the script.
+	over  chan struct{}
+	ended bool
+}
+
+func newScriptedBroker(script ...error) *scriptedBroker {
+	return &scriptedBroker{script: script, over: make(chan struct{})}
+}
+
+func (b *scriptedBroker) Subscribe(topic string, opts broker.SubscribeOptions) (broker.Subscription, error) {
+	return b, nil
+}
+
+func (b *scriptedBroker) Fetch(ctx context.Context) (broker.Message, error) {
+	b.mu.Lock()
+	b.fetches = append(b.fetches, time.Now())
+
+	if len(b.script) == 0 {
+		if !b.ended {
+			b.ended = true
+			close(b.over)
+		}
+		b.mu.Unlock()
+
+		<-ctx.Done()
+		return broker.Message{}, ctx.Err()
+	}
+
+	err := b.script[0]
+	b.script = b.script[1:]
+	b.mu.Unlock()
+
+	if err != nil {
+		return broker.Message{}, err
+	}
+
+	return broker.Message{Value: []byte("not an ack")}, nil
+}
+
+func (b *scriptedBroker) Commit(ctx context.Context, msgs ...broker.Message) error {
+	return nil
+}
+
+func (b *scriptedBroker) Close() error {
+	return nil
+}
+
+// waits returns the time between consecutive fetches.
+func (b *scriptedBroker) waits() []time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	waits := make([]time.Duration, len(b.fetches)-1)
+	for i := range waits {
+		waits[i] = b.fetches[i+1].Sub(b.fetches[i])
+	}
+
+	return waits
+}
+
+func TestRunBacksOffReadErrors(t *testing.T) {
+	down := errors.New("broker unreachable")
+	bus := newScriptedBroker(down, down, down, down, nil, down, down)
+
+	customLogger := logger.New("forwarder")
+	customLogger.SetOutput(io.Discard)
+
+	f := New(Config{Group: "forwarder", ReadBackoff: 30 * time.Millisecond, MaxReadBackoff: 120 * time.Millisecond}, bus, customLogger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() { defer close(done); f.Run(ctx) }()
+
+	select {
+	case <-bus.over:
+	case <-time.After(5 * time.Second):
+		t.Fatal("script not read")
+	}
+	cancel()
+	<-done
+
+	// Doubled up to MaxReadBackoff, and from ReadBackoff again after a
+	// message.
+	want := []time.Duration{30, 60, 120, 120, 0, 30, 60}
+	waits := bus.waits()
+	if len(waits) != len(want) {
+		t.Fatalf("waited %v, want %v ms", waits, want)
+	}
+
+	for i, wait := range waits {
+		want := want[i] * time.Millisecond
+		if wait < want || wait >= want+25*time.Millisecond {
+			t.Errorf("waited %s after fetch %d, want %s", wait, i, want)
+		}
+	}
+}
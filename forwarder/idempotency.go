@@ -0,0 +1,83 @@
+package forwarder
+
+import (
+	"sync"
+	"time"
+)
+
+// acceptedRequests remembers the data requests accepted by idempotency key,
+// so a retried request gets the correlation ID of the first one instead of
+// starting a second execution.
+type acceptedRequests struct {
+	ttl         time.Duration
+	mu          sync.Mutex
+	requests    map[string]*acceptedRequest
+	lastSweepAt time.Time
+}
+
+type acceptedRequest struct {
+	// done is closed once the first request is answered.
+	done          chan struct{}
+	correlationID string
+	accepted      bool
+	at            time.Time
+}
+
+func newAcceptedRequests(ttl time.Duration) *acceptedRequests {
+	return &acceptedRequests{ttl: ttl, requests: make(map[string]*acceptedRequest)}
+}
+
+// begin returns the request with key and whether it is the first one, which
+// must then be finished.
+func (a *acceptedRequests) begin(key string) (*acceptedRequest, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	now := time.Now()
+	a.sweep(now)
+
+	if req, ok := a.requests[key]; ok {
+		return req, false
+	}
+
+	req := &acceptedRequest{done: make(chan struct{}), at: now}
+	a.requests[key] = req
+
+	return req, true
+}
+
+// finish records the outcome of the first request with key. A request that
+// wasn't accepted is forgotten, so a retry starts over.
+func (a *acceptedRequests) finish(key string, req *acceptedRequest, correlationID string, accepted bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	req.correlationID, req.accepted = correlationID, accepted
+	if !accepted {
+		delete(a.requests, key)
+	}
+
+	close(req.done)
+}
+
+// sweep forgets the requests older than the TTL, at most every tenth of the
+// TTL. a.mu must be held.
+func (a *acceptedRequests) sweep(now time.Time) {
+	if now.Sub(a.lastSweepAt) < a.ttl/10 {
+		return
+	}
+	a.lastSweepAt = now
+
+	for key, req := range a.requests {
+		select {
+		case <-req.done:
+		default:
+			// Still being answered.
+			continue
+		}
+
+		if now.Sub(req.at) > a.ttl {
+			delete(a.requests, key)
+		}
+	}
+}
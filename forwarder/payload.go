@@ -0,0 +1,60 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// defaultContentType is assumed for payloads sent without a content type.
+const defaultContentType = "application/json"
+
+// errPayloadTooLarge is returned for a payload above Config.MaxPayloadBytes.
+var errPayloadTooLarge = errors.New("payload too large")
+
+// validatePayload checks the payload and attributes of a data request
+// against the limits in cfg, defaulting its content type.
+func validatePayload(cfg Config, data *shared.DataRequest) error {
+	if len(data.Payload) > cfg.MaxPayloadBytes {
+		return fmt.Errorf("%w: %d bytes, at most %d allowed", errPayloadTooLarge, len(data.Payload), cfg.MaxPayloadBytes)
+	}
+
+	if len(data.Payload) > 0 {
+		if data.ContentType == "" {
+			data.ContentType = defaultContentType
+		}
+
+		mediaType, _, err := mime.ParseMediaType(data.ContentType)
+		if err != nil {
+			return fmt.Errorf("invalid content type %q: %v", data.ContentType, err)
+		}
+
+		if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			return fmt.Errorf("content type %q is not JSON", data.ContentType)
+		}
+	} else if data.ContentType != "" {
+		return fmt.Errorf("content type %q without a payload", data.ContentType)
+	}
+
+	if len(data.Attributes) > cfg.MaxAttributes {
+		return fmt.Errorf("%d attributes, at most %d allowed", len(data.Attributes), cfg.MaxAttributes)
+	}
+
+	for key, value := range data.Attributes {
+		if key == "" {
+			return errors.New("empty attribute name")
+		}
+
+		if len(key) > maxAttributeLength || len(value) > maxAttributeLength {
+			return fmt.Errorf("attribute %.32q longer than %d bytes", key, maxAttributeLength)
+		}
+	}
+
+	return nil
+}
+
+// maxAttributeLength bounds attribute names and values.
+const maxAttributeLength = 256
@@ -0,0 +1,112 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+	"github.com/google/uuid"
+)
+
+// IDs identify the execution a log line belongs to.
+type IDs struct {
+	CorrelationID string
+	ExecutionID   string
+	// TraceID follows one request across services and retries.
+	TraceID string
+}
+
+type idsKey struct{}
+
+// WithIDs returns ctx carrying ids. Empty IDs keep the values ctx already
+// carries.
+func WithIDs(ctx context.Context, ids IDs) context.Context {
+	current := IDsFrom(ctx)
+
+	if ids.CorrelationID == "" {
+		ids.CorrelationID = current.CorrelationID
+	}
+	if ids.ExecutionID == "" {
+		ids.ExecutionID = current.ExecutionID
+	}
+	if ids.TraceID == "" {
+		ids.TraceID = current.TraceID
+	}
+
+	return context.WithValue(ctx, idsKey{}, ids)
+}
+
+// IDsFrom returns the IDs carried by ctx.
+func IDsFrom(ctx context.Context) IDs {
+	ids, _ := ctx.Value(idsKey{}).(IDs)
+	return ids
+}
+
+// NewTraceID returns a new random trace ID.
+func NewTraceID() string {
+	return uuid.NewString()
+}
+
+// WithHeaders returns ctx carrying the IDs of a broker message, with a new
+// trace ID when neither the message nor ctx has one.
+func WithHeaders(ctx context.Context, headers shared.Headers) context.Context {
+	ids := IDs{
+		CorrelationID: headers.CorrelationID,
+		ExecutionID:   headers.ExecutionID,
+		TraceID:       headers.TraceID,
+	}
+
+	if ids.TraceID == "" && IDsFrom(ctx).TraceID == "" {
+		ids.TraceID = NewTraceID()
+	}
+
+	return WithIDs(ctx, ids)
+}
+
+// Middleware puts the IDs of each request in its context: the trace ID from
+// the X-Trace-Id header, or a new one, and the correlation and execution IDs
+// from the X-Correlation-Id and X-Execution-Id headers or the correlation_id
+// and execution_id query parameters. The trace ID is echoed in the response.
+// A request already carrying a trace ID, from an outer Middleware, keeps it.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids := IDs{
+			CorrelationID: r.Header.Get(shared.HTTPHeaderCorrelationID),
+			ExecutionID:   r.Header.Get(shared.HTTPHeaderExecutionID),
+			TraceID:       r.Header.Get(shared.HTTPHeaderTraceID),
+		}
+
+		if ids.CorrelationID == "" {
+			ids.CorrelationID = r.URL.Query().Get("correlation_id")
+		}
+		if ids.ExecutionID == "" {
+			ids.ExecutionID = r.URL.Query().Get("execution_id")
+		}
+		if ids.TraceID == "" {
+			ids.TraceID = IDsFrom(r.Context()).TraceID
+		}
+		if ids.TraceID == "" {
+			ids.TraceID = NewTraceID()
+		}
+
+		w.Header().Set(shared.HTTPHeaderTraceID, ids.TraceID)
+
+		next.ServeHTTP(w, r.WithContext(WithIDs(r.Context(), ids)))
+	})
+}
+
+// SetHTTPHeaders copies the IDs carried by ctx to the headers of an outgoing
+// request.
+func SetHTTPHeaders(ctx context.Context, header http.Header) {
+	ids := IDsFrom(ctx)
+
+	if ids.CorrelationID != "" {
+		header.Set(shared.HTTPHeaderCorrelationID, ids.CorrelationID)
+	}
+	if ids.ExecutionID != "" {
+		header.Set(shared.HTTPHeaderExecutionID, ids.ExecutionID)
+	}
+	if ids.TraceID != "" {
+		header.Set(shared.HTTPHeaderTraceID, ids.TraceID)
+	}
+}
@@ -0,0 +1,159 @@
+package logger
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+func TestMiddlewarePropagatesIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		header   http.Header
+		outer    string
+		want     IDs
+		newTrace bool
+	}{
+		{
+			name:   "headers",
+			target: "/callback",
+			header: http.Header{shared.HTTPHeaderCorrelationID: {"corr-1"}, shared.HTTPHeaderExecutionID: {"exec-1"}, shared.HTTPHeaderTraceID: {"trace-1"}},
+			want:   IDs{CorrelationID: "corr-1", ExecutionID: "exec-1", TraceID: "trace-1"},
+		},
+		{
+			name:     "query",
+			target:   "/callback?correlation_id=corr-2&execution_id=exec-2",
+			want:     IDs{CorrelationID: "corr-2", ExecutionID: "exec-2"},
+			newTrace: true,
+		},
+		{
+			name:     "headers before query",
+			target:   "/callback?correlation_id=corr-2&execution_id=exec-2",
+			header:   http.Header{shared.HTTPHeaderCorrelationID: {"corr-1"}},
+			want:     IDs{CorrelationID: "corr-1", ExecutionID: "exec-2"},
+			newTrace: true,
+		},
+		{
+			name:   "outer trace",
+			target: "/request",
+			outer:  "trace-outer",
+			want:   IDs{TraceID: "trace-outer"},
+		},
+		{
+			name:   "header trace before outer",
+			target: "/request",
+			header: http.Header{shared.HTTPHeaderTraceID: {"trace-1"}},
+			outer:  "trace-outer",
+			want:   IDs{TraceID: "trace-1"},
+		},
+	}
+
+	for _, tt := range tests {
+		var got IDs
+		handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = IDsFrom(r.Context())
+		}))
+
+		req := httptest.NewRequest(http.MethodPost, tt.target, nil)
+		for key, values := range tt.header {
+			req.Header[key] = values
+		}
+		if tt.outer != "" {
+			req = req.WithContext(WithIDs(req.Context(), IDs{TraceID: tt.outer}))
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if tt.newTrace {
+			if got.TraceID == "" {
+				t.Errorf("%s: no trace ID", tt.name)
+			}
+			tt.want.TraceID = got.TraceID
+		}
+
+		if got != tt.want {
+			t.Errorf("%s: IDs %+v, want %+v", tt.name, got, tt.want)
+		}
+
+		if echoed := rec.Header().Get(shared.HTTPHeaderTraceID); echoed != got.TraceID {
+			t.Errorf("%s: echoed trace ID %q, want %q", tt.name, echoed, got.TraceID)
+		}
+	}
+}
+
+func TestMiddlewareNewTracePerRequest(t *testing.T) {
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	traces := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		traces[rec.Header().Get(shared.HTTPHeaderTraceID)] = true
+	}
+
+	if len(traces) != 3 {
+		t.Fatalf("trace IDs %v, want one per request", traces)
+	}
+}
+
+func TestIDsRoundTripThroughHTTP(t *testing.T) {
+	ids := IDs{CorrelationID: "corr-1", ExecutionID: "exec-1", TraceID: "trace-1"}
+
+	var got IDs
+	server := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = IDsFrom(r.Context())
+	})))
+	t.Cleanup(server.Close)
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	SetHTTPHeaders(WithIDs(context.Background(), ids), req.Header)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if got != ids {
+		t.Fatalf("server got IDs %+v, want %+v", got, ids)
+	}
+}
+
+func TestWithIDsKeepsCarriedIDs(t *testing.T) {
+	ctx := WithIDs(context.Background(), IDs{CorrelationID: "corr-1", ExecutionID: "exec-1", TraceID: "trace-1"})
+	ctx = WithIDs(ctx, IDs{ExecutionID: "exec-2"})
+
+	if got, want := IDsFrom(ctx), (IDs{CorrelationID: "corr-1", ExecutionID: "exec-2", TraceID: "trace-1"}); got != want {
+		t.Errorf("IDs %+v, want %+v", got, want)
+	}
+
+	// Messages without a trace ID keep the one of ctx.
+	if got := IDsFrom(WithHeaders(ctx, shared.Headers{CorrelationID: "corr-3"})); got.TraceID != "trace-1" || got.CorrelationID != "corr-3" {
+		t.Errorf("IDs %+v, want corr-3 in trace-1", got)
+	}
+
+	if got := IDsFrom(WithHeaders(context.Background(), shared.Headers{})); got.TraceID == "" {
+		t.Error("no trace ID for a message without one")
+	}
+}
+
+func TestLogContextWritesIDs(t *testing.T) {
+	customLogger, buf := newTestLogger(t)
+
+	var ctx context.Context
+	Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/callback?correlation_id=corr-1&execution_id=exec-1", nil))
+
+	customLogger.LogContext(ctx, "forwarder", "called back", nil)
+
+	data := logLines(t, buf)[0]
+	if data.CorrelationID != "corr-1" || data.ExecutionID != "exec-1" || data.TraceID != IDsFrom(ctx).TraceID {
+		t.Fatalf("log data IDs %q %q %q, want those of the request", data.CorrelationID, data.ExecutionID, data.TraceID)
+	}
+}
@@ -0,0 +1,95 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+	"github.com/segmentio/kafka-go"
+)
+
+// maxErrorChain bounds the wrapped errors logged with an error.
+const maxErrorChain = 16
+
+// thisPackage is the import path of the logger, whose frames are left out
+// of stacks.
+const thisPackage = "github.com/assimoes/rtd-sandbox/logger"
+
+// httpStatusError is implemented by errors that carry the status code of a
+// failed HTTP call.
+type httpStatusError interface {
+	HTTPStatus() int
+}
+
+// errorData converts v, the error passed to a logger, to its logged form.
+// Values that aren't errors are logged with their fmt representation.
+func errorData(v interface{}) *shared.ErrorData {
+	err, ok := v.(error)
+	if !ok {
+		return &shared.ErrorData{Message: fmt.Sprint(v), Type: fmt.Sprintf("%T", v)}
+	}
+
+	data := describeError(err)
+
+	var statusErr httpStatusError
+	if errors.As(err, &statusErr) {
+		data.HTTPStatus = statusErr.HTTPStatus()
+	}
+
+	var kafkaErr kafka.Error
+	if errors.As(err, &kafkaErr) {
+		data.KafkaCode = int(kafkaErr)
+	}
+
+	// Walk the wrapped errors, depth first, including those of errors.Join.
+	queue := unwrap(err)
+	for len(queue) > 0 && len(data.Chain) < maxErrorChain {
+		next := queue[0]
+		queue = append(unwrap(next), queue[1:]...)
+		data.Chain = append(data.Chain, describeError(next))
+	}
+
+	return &data
+}
+
+func describeError(err error) shared.ErrorData {
+	return shared.ErrorData{Message: err.Error(), Type: fmt.Sprintf("%T", err)}
+}
+
+func unwrap(err error) []error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		if next := e.Unwrap(); next != nil {
+			return []error{next}
+		}
+	case interface{ Unwrap() []error }:
+		return e.Unwrap()
+	}
+
+	return nil
+}
+
+// callerStack returns the stack of the goroutine logging, leaving out the
+// frames of the logger and log/slog.
+func callerStack() string {
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(2, pcs)]
+
+	var b strings.Builder
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+
+		if !strings.HasPrefix(frame.Function, "log/slog.") && !strings.HasPrefix(frame.Function, thisPackage+".") {
+			fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	return b.String()
+}
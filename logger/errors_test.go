@@ -0,0 +1,130 @@
+package logger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+	"github.com/segmentio/kafka-go"
+)
+
+// statusError is an error of a failed HTTP call.
+type statusError struct{ code int }
+
+func (e *statusError) Error() string   { return fmt.Sprintf("status %d", e.code) }
+func (e *statusError) HTTPStatus() int { return e.code }
+
+func TestErrorData(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+		want *shared.ErrorData
+	}{
+		{
+			name: "not an error",
+			v:    "timed out",
+			want: &shared.ErrorData{Message: "timed out", Type: "string"},
+		},
+		{
+			name: "wrapped",
+			v:    fmt.Errorf("submitting: %w", fmt.Errorf("reading: %w", io.EOF)),
+			want: &shared.ErrorData{
+				Message: "submitting: reading: EOF",
+				Type:    "*fmt.wrapError",
+				Chain: []shared.ErrorData{
+					{Message: "reading: EOF", Type: "*fmt.wrapError"},
+					{Message: "EOF", Type: "*errors.errorString"},
+				},
+			},
+		},
+		{
+			name: "joined",
+			v:    errors.Join(fmt.Errorf("a: %w", io.EOF), io.ErrUnexpectedEOF),
+			want: &shared.ErrorData{
+				Message: "a: EOF\nunexpected EOF",
+				Type:    "*errors.joinError",
+				Chain: []shared.ErrorData{
+					{Message: "a: EOF", Type: "*fmt.wrapError"},
+					{Message: "EOF", Type: "*errors.errorString"},
+					{Message: "unexpected EOF", Type: "*errors.errorString"},
+				},
+			},
+		},
+		{
+			name: "kafka",
+			v:    fmt.Errorf("publishing: %w", kafka.LeaderNotAvailable),
+			want: &shared.ErrorData{
+				Message:   "publishing: " + kafka.LeaderNotAvailable.Error(),
+				Type:      "*fmt.wrapError",
+				KafkaCode: int(kafka.LeaderNotAvailable),
+				Chain:     []shared.ErrorData{{Message: kafka.LeaderNotAvailable.Error(), Type: "kafka.Error"}},
+			},
+		},
+		{
+			name: "http status",
+			v:    fmt.Errorf("posting: %w", &statusError{code: 503}),
+			want: &shared.ErrorData{
+				Message:    "posting: status 503",
+				Type:       "*fmt.wrapError",
+				HTTPStatus: 503,
+				Chain:      []shared.ErrorData{{Message: "status 503", Type: "*logger.statusError"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if got := errorData(tt.v); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: errorData() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestErrorDataBoundsChain(t *testing.T) {
+	err := io.EOF
+	for i := 0; i < 2*maxErrorChain; i++ {
+		err = fmt.Errorf("layer %d: %w", i, err)
+	}
+
+	if got := errorData(err); len(got.Chain) != maxErrorChain {
+		t.Fatalf("chain of %d errors, want %d", len(got.Chain), maxErrorChain)
+	}
+}
+
+func TestErrorSerialization(t *testing.T) {
+	customLogger, buf := newTestLogger(t)
+	customLogger.core.errorStacks = true
+
+	customLogger.Log("kafka", "publish failed", fmt.Errorf("publishing: %w", kafka.LeaderNotAvailable), "", "")
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("decoding log line: %v", err)
+	}
+
+	logged, ok := line["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("error %v, want an object", line["error"])
+	}
+
+	if logged["kafka_code"] != float64(kafka.LeaderNotAvailable) || logged["type"] != "*fmt.wrapError" {
+		t.Errorf("error %v, want the kafka code and type", logged)
+	}
+
+	if _, ok := logged["http_status"]; ok {
+		t.Errorf("error %v has an http_status without a status code", logged)
+	}
+
+	if chain, ok := logged["chain"].([]interface{}); !ok || len(chain) != 1 {
+		t.Errorf("chain %v, want the kafka error", logged["chain"])
+	}
+
+	stack, _ := logged["stack"].(string)
+	if !strings.Contains(stack, "testing.tRunner") || strings.Contains(stack, "log/slog.") {
+		t.Errorf("stack %q, want the caller's frames without the logger's", stack)
+	}
+}
@@ -0,0 +1,136 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FileSink appends lines to a file, rotating it to path.1, path.2 and so on
+// once it reaches a size.
+type FileSink struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFileSink opens path for appending. The file is rotated before it grows
+// past maxBytes, keeping maxFiles rotated files. A maxBytes of zero never
+// rotates.
+func NewFileSink(path string, maxBytes int64, maxFiles int) (*FileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	s := &FileSink{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	s.file, s.size = file, info.Size()
+
+	return nil
+}
+
+func (s *FileSink) Write(line []byte) error {
+	return s.WriteBatch([][]byte{line})
+}
+
+func (s *FileSink) WriteBatch(lines [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+
+	for _, line := range lines {
+		n := int64(len(line)) + 1
+
+		if s.maxBytes > 0 && s.size > 0 && s.size+n > s.maxBytes {
+			if err := s.rotate(); err != nil {
+				return err
+			}
+		}
+
+		buf := make([]byte, n)
+		copy(buf, line)
+		buf[n-1] = '\n'
+
+		written, err := s.file.Write(buf)
+		s.size += int64(written)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, and path to path.1.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	if s.maxFiles <= 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return s.open()
+	}
+
+	os.Remove(s.rotated(s.maxFiles))
+
+	for i := s.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(s.rotated(i), s.rotated(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(s.path, s.rotated(1)); err != nil {
+		return err
+	}
+
+	return s.open()
+}
+
+func (s *FileSink) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", s.path, i)
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+
+	err := s.file.Close()
+	s.file = nil
+
+	return err
+}
@@ -0,0 +1,232 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// handler is the slog.Handler behind a CustomLogger.
+type handler struct {
+	friendlyName string
+	core         *core
+	attrs        []groupedAttr
+	groups       []string
+}
+
+// groupedAttr is an attribute added with WithAttrs under the groups open
+// at the time.
+type groupedAttr struct {
+	groups []string
+	attr   slog.Attr
+}
+
+func (h *handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.core.level.Level()
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.withAttrs(attrs)
+}
+
+func (h *handler) withAttrs(attrs []slog.Attr) *handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	h2 := *h
+	h2.attrs = make([]groupedAttr, len(h.attrs), len(h.attrs)+len(attrs))
+	copy(h2.attrs, h.attrs)
+
+	for _, attr := range attrs {
+		h2.attrs = append(h2.attrs, groupedAttr{groups: h.groups, attr: attr})
+	}
+
+	return &h2
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+
+	h2 := *h
+	h2.groups = append(h.groups[:len(h.groups):len(h.groups)], name)
+
+	return &h2
+}
+
+func (h *handler) Handle(ctx context.Context, record slog.Record) error {
+	timestamp := record.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+
+	ids := IDsFrom(ctx)
+
+	data := shared.LogData{
+		CorrelationID: ids.CorrelationID,
+		ExecutionID:   ids.ExecutionID,
+		TraceID:       ids.TraceID,
+		FriendlyName:  h.friendlyName,
+		Timestamp:     timestamp.Format(time.RFC3339Nano),
+		Level:         strings.ToLower(record.Level.String()),
+		Log:           shared.LogEntry{Message: record.Message},
+	}
+
+	fields := make(map[string]interface{})
+
+	for _, a := range h.attrs {
+		h.addAttr(&data, fields, a.groups, a.attr)
+	}
+
+	record.Attrs(func(attr slog.Attr) bool {
+		h.addAttr(&data, fields, h.groups, attr)
+		return true
+	})
+
+	data.Log.ExecutionID = data.ExecutionID
+
+	if len(fields) > 0 {
+		data.Fields = fields
+	}
+
+	str, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if redactor := h.core.getRedactor(); redactor != nil {
+		if str, err = redactor.JSON(str); err != nil {
+			return err
+		}
+	}
+
+	var errs []error
+	for _, sink := range h.core.getSinks() {
+		errs = append(errs, sink.Write(str))
+	}
+
+	return errors.Join(errs...)
+}
+
+// addAttr sets the log data field named by a top-level attribute, or adds
+// the attribute to fields under its groups.
+func (h *handler) addAttr(data *shared.LogData, fields map[string]interface{}, groups []string, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if len(groups) == 0 {
+		switch attr.Key {
+		case TargetKey:
+			data.Target = attr.Value.String()
+			return
+		case CorrelationIDKey:
+			if id := attr.Value.String(); id != "" {
+				data.CorrelationID = id
+			}
+			return
+		case ExecutionIDKey:
+			if id := attr.Value.String(); id != "" {
+				data.ExecutionID = id
+			}
+			return
+		case TraceIDKey:
+			if id := attr.Value.String(); id != "" {
+				data.TraceID = id
+			}
+			return
+		case ErrorKey:
+			if v := attr.Value.Any(); v != nil {
+				data.Error = errorData(v)
+				if h.core.errorStacks {
+					data.Error.Stack = callerStack()
+				}
+			}
+			return
+		}
+	}
+
+	for _, group := range groups {
+		next, ok := fields[group].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			fields[group] = next
+		}
+		fields = next
+	}
+
+	addField(fields, attr)
+}
+
+// addField adds attr to fields, nesting groups.
+func addField(fields map[string]interface{}, attr slog.Attr) {
+	attr.Value = attr.Value.Resolve()
+
+	if attr.Equal(slog.Attr{}) {
+		return
+	}
+
+	if attr.Value.Kind() != slog.KindGroup {
+		fields[attr.Key] = fieldValue(attr.Value)
+		return
+	}
+
+	group := attr.Value.Group()
+	if len(group) == 0 {
+		return
+	}
+
+	// Attributes of a group without a key are inlined.
+	if attr.Key != "" {
+		nested := make(map[string]interface{})
+		fields[attr.Key] = nested
+		fields = nested
+	}
+
+	for _, a := range group {
+		addField(fields, a)
+	}
+}
+
+// fieldValue converts v to a value that marshals to meaningful JSON.
+func fieldValue(v slog.Value) interface{} {
+	switch v.Kind() {
+	case slog.KindTime:
+		return v.Time().Format(time.RFC3339Nano)
+	case slog.KindDuration:
+		return v.Duration().String()
+	case slog.KindAny:
+		switch value := v.Any().(type) {
+		case error:
+			return errorData(value)
+		case fmt.Stringer:
+			return value.String()
+		}
+	}
+
+	return v.Any()
+}
+
+// argsToAttrs converts slog style arguments to attributes.
+func argsToAttrs(args []any) []slog.Attr {
+	var record slog.Record
+	record.Add(args...)
+
+	attrs := make([]slog.Attr, 0, record.NumAttrs())
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs = append(attrs, attr)
+		return true
+	})
+
+	return attrs
+}
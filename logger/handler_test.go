@@ -0,0 +1,168 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// newTestLogger returns a logger writing unredacted lines to the returned
+// buffer.
+func newTestLogger(t *testing.T) (*CustomLogger, *bytes.Buffer) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	customLogger := New("test")
+	customLogger.SetOutput(&buf)
+	customLogger.SetRedactor(nil)
+
+	return customLogger, &buf
+}
+
+// logLines decodes the lines written to buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []shared.LogData {
+	t.Helper()
+
+	var lines []shared.LogData
+	for dec := json.NewDecoder(buf); dec.More(); {
+		var data shared.LogData
+		if err := dec.Decode(&data); err != nil {
+			t.Fatalf("decoding log line: %v", err)
+		}
+		lines = append(lines, data)
+	}
+
+	return lines
+}
+
+func TestHandlerWritesLogData(t *testing.T) {
+	customLogger, buf := newTestLogger(t)
+
+	ctx := WithIDs(context.Background(), IDs{CorrelationID: "corr-1", ExecutionID: "exec-1", TraceID: "trace-1"})
+	customLogger.Slog().InfoContext(ctx, "request forwarded",
+		TargetKey, "kafka",
+		ErrorKey, errors.New("boom"),
+		"attempts", 3,
+		"took", 1500*time.Millisecond,
+	)
+
+	lines := logLines(t, buf)
+	if len(lines) != 1 {
+		t.Fatalf("wrote %d lines, want 1", len(lines))
+	}
+	data := lines[0]
+
+	if data.FriendlyName != "test" || data.Level != "info" || data.Target != "kafka" || data.Log.Message != "request forwarded" {
+		t.Errorf("log data %+v", data)
+	}
+
+	if data.CorrelationID != "corr-1" || data.ExecutionID != "exec-1" || data.TraceID != "trace-1" || data.Log.ExecutionID != "exec-1" {
+		t.Errorf("IDs %q %q %q %q, want those of the context", data.CorrelationID, data.ExecutionID, data.TraceID, data.Log.ExecutionID)
+	}
+
+	if _, err := time.Parse(time.RFC3339Nano, data.Timestamp); err != nil {
+		t.Errorf("timestamp %q: %v", data.Timestamp, err)
+	}
+
+	if data.Error == nil || data.Error.Message != "boom" {
+		t.Errorf("error %+v, want boom", data.Error)
+	}
+
+	want := map[string]interface{}{"attempts": float64(3), "took": "1.5s"}
+	if !reflect.DeepEqual(data.Fields, want) {
+		t.Errorf("fields %v, want %v", data.Fields, want)
+	}
+}
+
+func TestHandlerIDAttrsOverrideContext(t *testing.T) {
+	customLogger, buf := newTestLogger(t)
+
+	ctx := WithIDs(context.Background(), IDs{CorrelationID: "corr-1", ExecutionID: "exec-1"})
+	customLogger.InfoContext(ctx, "decided", CorrelationIDKey, "corr-2", ExecutionIDKey, "")
+
+	data := logLines(t, buf)[0]
+	if data.CorrelationID != "corr-2" || data.ExecutionID != "exec-1" {
+		t.Errorf("IDs %q %q, want corr-2 and the context's exec-1", data.CorrelationID, data.ExecutionID)
+	}
+	if data.Fields != nil {
+		t.Errorf("fields %v, want the IDs only at the top level", data.Fields)
+	}
+}
+
+func TestHandlerGroupsAndAttrs(t *testing.T) {
+	customLogger, buf := newTestLogger(t)
+
+	base := customLogger.Slog().With("service", "payments")
+	grouped := base.WithGroup("request").With("id", 7).WithGroup("http")
+
+	grouped.Info("served",
+		"status", 200,
+		// Reserved keys are fields inside a group.
+		TargetKey, "forwarder",
+		slog.Group("peer", "addr", "10.0.0.1"),
+		slog.Group("", "inlined", true),
+		slog.Group("empty"),
+	)
+	base.Info("unchanged")
+	customLogger.Slog().WithGroup("").Info("no group", "n", 1)
+
+	lines := logLines(t, buf)
+	if len(lines) != 3 {
+		t.Fatalf("wrote %d lines, want 3", len(lines))
+	}
+
+	want := map[string]interface{}{
+		"service": "payments",
+		"request": map[string]interface{}{
+			"id": float64(7),
+			"http": map[string]interface{}{
+				"status":  float64(200),
+				"target":  "forwarder",
+				"peer":    map[string]interface{}{"addr": "10.0.0.1"},
+				"inlined": true,
+			},
+		},
+	}
+	if !reflect.DeepEqual(lines[0].Fields, want) || lines[0].Target != "" {
+		t.Errorf("grouped fields %v and target %q, want %v", lines[0].Fields, lines[0].Target, want)
+	}
+
+	// Deriving handlers doesn't change the ones derived from.
+	if want := map[string]interface{}{"service": "payments"}; !reflect.DeepEqual(lines[1].Fields, want) {
+		t.Errorf("base fields %v, want %v", lines[1].Fields, want)
+	}
+
+	if want := map[string]interface{}{"n": float64(1)}; !reflect.DeepEqual(lines[2].Fields, want) {
+		t.Errorf("fields with an empty group %v, want %v", lines[2].Fields, want)
+	}
+}
+
+func TestHandlerLevel(t *testing.T) {
+	customLogger, buf := newTestLogger(t)
+	customLogger.SetLevel(LevelWarn)
+
+	handler := customLogger.Handler()
+	if handler.Enabled(context.Background(), LevelInfo) || !handler.Enabled(context.Background(), LevelError) {
+		t.Error("Enabled doesn't follow the warn level")
+	}
+
+	customLogger.Slog().Info("dropped")
+	customLogger.With("component", "sink").Warn("kept")
+	customLogger.Log("kafka", "failed", errors.New("boom"), "", "")
+
+	lines := logLines(t, buf)
+	if len(lines) != 2 || lines[0].Level != "warn" || lines[1].Level != "error" {
+		t.Fatalf("wrote %+v, want the warn and error lines", lines)
+	}
+
+	if lines[0].Fields["component"] != "sink" {
+		t.Errorf("fields %v, want component sink", lines[0].Fields)
+	}
+}
@@ -0,0 +1,48 @@
+package logger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// LevelHandler serves the logger's level: GET returns {"level": "info"}, with
+// the totals of its asynchronous sinks under "sinks", and PUT or POST
+// {"level": "debug"} changes it.
+func (c *CustomLogger) LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var req struct {
+				Level string `json:"level"`
+			}
+
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+				return
+			}
+
+			level, err := ParseLevel(req.Level)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid level %q", req.Level), http.StatusBadRequest)
+				return
+			}
+
+			c.SetLevel(level)
+			c.Info(fmt.Sprintf("Log level set to %s", strings.ToLower(level.String())), TargetKey, "logger")
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		res := map[string]interface{}{"level": strings.ToLower(c.Level().String())}
+		if stats := c.SinkStats(); len(stats) > 0 {
+			res["sinks"] = stats
+		}
+
+		json.NewEncoder(w).Encode(res)
+	})
+}
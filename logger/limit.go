@@ -0,0 +1,156 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RepeatedKey is the field holding how many messages a summary stands for.
+const RepeatedKey = "repeated"
+
+// Limit bounds how often messages sharing a key are logged. Messages over
+// the limit are counted, and summarized as "repeated N times" once the
+// interval is over.
+type Limit struct {
+	// Burst is how many messages are logged per Interval. Defaults to 1.
+	Burst int
+	// Interval defaults to 10s.
+	Interval time.Duration
+	// Sample logs one in Sample of the messages over Burst. Zero logs none
+	// of them.
+	Sample int
+}
+
+// limitState is the window of a key.
+type limitState struct {
+	limit Limit
+
+	mu         sync.Mutex
+	start      time.Time
+	seen       int
+	suppressed int
+	// last is the last suppressed message, logged with the summary.
+	last  deferredRecord
+	timer *time.Timer
+}
+
+type deferredRecord struct {
+	logger *CustomLogger
+	ctx    context.Context
+	record slog.Record
+}
+
+// Limited returns a logger applying limit to everything it logs, counting
+// against key. Loggers limited with the same key, by any logger sharing the
+// core, share their window, whose limit is the first one given.
+func (c *CustomLogger) Limited(key string, limit Limit) *CustomLogger {
+	if limit.Burst <= 0 {
+		limit.Burst = 1
+	}
+	if limit.Interval <= 0 {
+		limit.Interval = 10 * time.Second
+	}
+
+	c.core.mu.Lock()
+	state, ok := c.core.limits[key]
+	if !ok {
+		if c.core.limits == nil {
+			c.core.limits = make(map[string]*limitState)
+		}
+		state = &limitState{limit: limit}
+		c.core.limits[key] = state
+	}
+	c.core.mu.Unlock()
+
+	return &CustomLogger{
+		friendlyName: c.friendlyName,
+		core:         c.core,
+		handler:      c.handler,
+		limit:        state,
+	}
+}
+
+// admit reports whether record is logged, adding a sampled field to the
+// sampled ones. Suppressed records are kept to be summarized.
+func (s *limitState) admit(c *CustomLogger, ctx context.Context, record *slog.Record) bool {
+	now := record.Time
+
+	s.mu.Lock()
+
+	if s.start.IsZero() || now.Sub(s.start) >= s.limit.Interval {
+		summary, ok := s.summary(now)
+		s.start, s.seen = now, 0
+
+		if ok {
+			s.mu.Unlock()
+			summary.logger.handler.Handle(summary.ctx, summary.record)
+			s.mu.Lock()
+		}
+	}
+
+	s.seen++
+	over := s.seen - s.limit.Burst
+
+	switch {
+	case over <= 0:
+		s.mu.Unlock()
+		return true
+	case s.limit.Sample > 0 && over%s.limit.Sample == 0:
+		s.mu.Unlock()
+		record.AddAttrs(slog.Int("sampled", s.limit.Sample))
+		return true
+	}
+
+	s.suppressed++
+	s.last = deferredRecord{logger: c, ctx: ctx, record: record.Clone()}
+
+	if s.timer == nil {
+		s.timer = time.AfterFunc(s.start.Add(s.limit.Interval).Sub(now), s.flush)
+	}
+
+	s.mu.Unlock()
+
+	return false
+}
+
+// flush logs the summary of the window once it is over, so it isn't held
+// back until the next message.
+func (s *limitState) flush() {
+	s.mu.Lock()
+	summary, ok := s.summary(time.Now())
+	s.mu.Unlock()
+
+	if ok {
+		summary.logger.handler.Handle(summary.ctx, summary.record)
+	}
+}
+
+// summary returns the record summarizing the suppressed messages, if any,
+// and resets their count. It must be called with s.mu held.
+func (s *limitState) summary(now time.Time) (deferredRecord, bool) {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+
+	if s.suppressed == 0 {
+		return deferredRecord{}, false
+	}
+
+	last := s.last
+	message := fmt.Sprintf("%s (repeated %d times in %s)", last.record.Message, s.suppressed, now.Sub(s.start).Round(time.Millisecond))
+
+	record := slog.NewRecord(now, last.record.Level, message, last.record.PC)
+	last.record.Attrs(func(attr slog.Attr) bool {
+		record.AddAttrs(attr)
+		return true
+	})
+	record.AddAttrs(slog.Int(RepeatedKey, s.suppressed))
+
+	s.suppressed, s.last = 0, deferredRecord{}
+
+	return deferredRecord{logger: last.logger, ctx: last.ctx, record: record}, true
+}
@@ -0,0 +1,198 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+func TestLimitAdmit(t *testing.T) {
+	type message struct {
+		at      time.Duration
+		admit   bool
+		sampled bool
+	}
+
+	tests := []struct {
+		name      string
+		limit     Limit
+		messages  []message
+		summaries []string
+		repeated  []float64
+	}{
+		{
+			name:  "under burst",
+			limit: Limit{Burst: 3, Interval: 10 * time.Second},
+			messages: []message{
+				{at: 0, admit: true},
+				{at: time.Second, admit: true},
+				{at: 2 * time.Second, admit: true},
+				{at: 10 * time.Second, admit: true},
+			},
+		},
+		{
+			name:  "suppress over burst",
+			limit: Limit{Burst: 2, Interval: 10 * time.Second},
+			messages: []message{
+				{at: 0, admit: true},
+				{at: time.Second, admit: true},
+				{at: 2 * time.Second},
+				{at: 3 * time.Second},
+				// A new window, with the summary of the last one.
+				{at: 10 * time.Second, admit: true},
+				{at: 11 * time.Second, admit: true},
+				{at: 12 * time.Second},
+				{at: 25 * time.Second, admit: true},
+			},
+			summaries: []string{"read failed (repeated 2 times in 10s)", "read failed (repeated 1 times in 15s)"},
+			repeated:  []float64{2, 1},
+		},
+		{
+			name:  "sample over burst",
+			limit: Limit{Burst: 1, Interval: 10 * time.Second, Sample: 2},
+			messages: []message{
+				{at: 0, admit: true},
+				{at: time.Second},
+				{at: 2 * time.Second, admit: true, sampled: true},
+				{at: 3 * time.Second},
+				{at: 4 * time.Second, admit: true, sampled: true},
+				{at: 10 * time.Second, admit: true},
+			},
+			summaries: []string{"read failed (repeated 2 times in 10s)"},
+			repeated:  []float64{2},
+		},
+		{
+			name:  "defaults",
+			limit: Limit{},
+			messages: []message{
+				{at: 0, admit: true},
+				{at: 9 * time.Second},
+				{at: 10 * time.Second, admit: true},
+			},
+			summaries: []string{"read failed (repeated 1 times in 10s)"},
+			repeated:  []float64{1},
+		},
+	}
+
+	start := time.Now()
+
+	for _, tt := range tests {
+		customLogger, buf := newTestLogger(t)
+		limited := customLogger.Limited("read", tt.limit)
+
+		for i, msg := range tt.messages {
+			record := slog.NewRecord(start.Add(msg.at), LevelWarn, "read failed", 0)
+			record.AddAttrs(slog.String("topic", "requests"))
+
+			if admit := limited.limit.admit(limited, context.Background(), &record); admit != msg.admit {
+				t.Errorf("%s: message %d at %s admitted %v, want %v", tt.name, i, msg.at, admit, msg.admit)
+			}
+
+			sampled := false
+			record.Attrs(func(attr slog.Attr) bool {
+				sampled = sampled || attr.Key == "sampled"
+				return true
+			})
+			if sampled != msg.sampled {
+				t.Errorf("%s: message %d at %s sampled %v, want %v", tt.name, i, msg.at, sampled, msg.sampled)
+			}
+		}
+
+		// Only the summaries are written by admit.
+		lines := logLines(t, buf)
+		if len(lines) != len(tt.summaries) {
+			t.Errorf("%s: wrote %d summaries, want %d", tt.name, len(lines), len(tt.summaries))
+			continue
+		}
+
+		for i, line := range lines {
+			if line.Log.Message != tt.summaries[i] || line.Level != "warn" {
+				t.Errorf("%s: summary %q at %s, want %q at warn", tt.name, line.Log.Message, line.Level, tt.summaries[i])
+			}
+
+			if line.Fields[RepeatedKey] != tt.repeated[i] || line.Fields["topic"] != "requests" {
+				t.Errorf("%s: summary fields %v, want repeated %v with the message's topic", tt.name, line.Fields, tt.repeated[i])
+			}
+		}
+	}
+}
+
+// chanSink sends the lines written to it, for lines written by other
+// goroutines.
+type chanSink chan []byte
+
+func (s chanSink) Write(line []byte) error {
+	s <- append([]byte(nil), line...)
+	return nil
+}
+
+func (s chanSink) Close() error {
+	return nil
+}
+
+func TestLimitFlushesSummary(t *testing.T) {
+	customLogger, _ := newTestLogger(t)
+	lines := make(chanSink, 8)
+	customLogger.SetSinks(lines)
+
+	next := func() shared.LogData {
+		t.Helper()
+
+		select {
+		case line := <-lines:
+			var data shared.LogData
+			if err := json.Unmarshal(line, &data); err != nil {
+				t.Fatalf("decoding %s: %v", line, err)
+			}
+			return data
+		case <-time.After(5 * time.Second):
+			t.Fatal("no line written")
+			return shared.LogData{}
+		}
+	}
+
+	limit := Limit{Burst: 1, Interval: 50 * time.Millisecond}
+
+	// Loggers limited with the same key share the window.
+	start := time.Now()
+	customLogger.Limited("read", limit).Warn("read failed", "topic", "requests")
+	customLogger.Limited("read", limit).Warn("read failed", "topic", "requests")
+	customLogger.Limited("read", limit).Warn("read failed", "topic", "responses")
+
+	if first := next(); first.Log.Message != "read failed" {
+		t.Fatalf("first line %q, want the message", first.Log.Message)
+	}
+
+	// The summary is flushed once the window is over, without waiting for
+	// the next message.
+	summary := next()
+	if elapsed := time.Since(start); elapsed < limit.Interval {
+		t.Errorf("summary flushed after %s, before the %s window was over", elapsed, limit.Interval)
+	}
+
+	if !strings.HasPrefix(summary.Log.Message, "read failed (repeated 2 times in ") || summary.Fields[RepeatedKey] != float64(2) {
+		t.Errorf("summary %q with fields %v, want 2 repeats", summary.Log.Message, summary.Fields)
+	}
+
+	// The summary carries the fields of the last suppressed message.
+	if summary.Fields["topic"] != "responses" {
+		t.Errorf("summary topic %v, want responses", summary.Fields["topic"])
+	}
+
+	// The flushed summary isn't written again by the next window.
+	customLogger.Limited("read", limit).Warn("read failed", "topic", "requests")
+	if line := next(); strings.Contains(line.Log.Message, "repeated") {
+		t.Errorf("wrote %q in the next window, want the message", line.Log.Message)
+	}
+
+	select {
+	case line := <-lines:
+		t.Errorf("wrote %s, want nothing more", line)
+	default:
+	}
+}
@@ -1,40 +1,265 @@
 package logger
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"io"
 	"log"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/assimoes/rtd-sandbox/redact"
 	"github.com/assimoes/rtd-sandbox/shared"
 )
 
+// Levels accepted by SetLevel, the log/slog ones.
+const (
+	LevelDebug = slog.LevelDebug
+	LevelInfo  = slog.LevelInfo
+	LevelWarn  = slog.LevelWarn
+	LevelError = slog.LevelError
+)
+
+// Keys of the attributes that fill the top-level shared.LogData fields
+// instead of its Fields. The IDs default to those carried by the context.
+const (
+	TargetKey        = "target"
+	CorrelationIDKey = "correlation_id"
+	ExecutionIDKey   = "execution_id"
+	TraceIDKey       = "trace_id"
+	ErrorKey         = "error"
+)
+
+var (
+	logLevel      = shared.GetEnv("LOG_LEVEL", "info")
+	logErrorStack = shared.GetEnv("LOG_ERROR_STACK", "false")
+)
+
+// core is shared by a logger and the loggers derived from it.
+type core struct {
+	mu    sync.Mutex
+	sinks []Sink
+	level slog.LevelVar
+	// errorStacks adds the stack of the caller to logged errors.
+	errorStacks bool
+	// limits are the windows of the keys of limited loggers.
+	limits map[string]*limitState
+	// redactor removes personal data from lines before they are written.
+	redactor *redact.Redactor
+}
+
 type CustomLogger struct {
 	friendlyName string
+	core         *core
+	handler      *handler
+	limit        *limitState
 }
 
+// New returns a logger writing shared.LogData JSON lines to stdout, at the
+// level named by LOG_LEVEL, info by default. Errors are logged with the
+// caller's stack when LOG_ERROR_STACK is true. Lines are redacted with the
+// rules of redact.FromEnv, and New exits when those can't be loaded rather
+// than write lines redacted by other rules.
 func New(friendlyName string) *CustomLogger {
+	c := &core{sinks: []Sink{NewWriterSink(os.Stdout)}}
+	c.errorStacks, _ = strconv.ParseBool(logErrorStack)
+
+	redactor, err := redact.FromEnv()
+	if err != nil {
+		log.Fatalf("error loading redaction rules: %v", err)
+	}
+	c.redactor = redactor
+
+	if level, err := ParseLevel(logLevel); err == nil {
+		c.level.Set(level)
+	}
+
 	return &CustomLogger{
 		friendlyName: friendlyName,
+		core:         c,
+		handler:      &handler{friendlyName: friendlyName, core: c},
+	}
+}
+
+// SetOutput sets where JSON log lines are written, synchronously. Defaults
+// to stdout, which is what the log collector reads.
+func (c *CustomLogger) SetOutput(w io.Writer) {
+	c.SetSinks(NewWriterSink(w))
+}
+
+// SetSinks replaces where JSON log lines are written, for the logger and
+// every logger derived from it. The sinks are not closed by the logger.
+func (c *CustomLogger) SetSinks(sinks ...Sink) {
+	c.core.mu.Lock()
+	defer c.core.mu.Unlock()
+
+	c.core.sinks = sinks
+}
+
+// SetRedactor replaces the redactor of the logger and every logger derived
+// from it. nil writes lines as they are.
+func (c *CustomLogger) SetRedactor(redactor *redact.Redactor) {
+	c.core.mu.Lock()
+	defer c.core.mu.Unlock()
+
+	c.core.redactor = redactor
+}
+
+// SinkStats returns the totals of the logger's asynchronous sinks.
+func (c *CustomLogger) SinkStats() []SinkStats {
+	var stats []SinkStats
+	for _, sink := range c.core.getSinks() {
+		if s, ok := sink.(interface{ Stats() SinkStats }); ok {
+			stats = append(stats, s.Stats())
+		}
+	}
+
+	return stats
+}
+
+func (c *core) getSinks() []Sink {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.sinks
+}
+
+func (c *core) getRedactor() *redact.Redactor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.redactor
+}
+
+// SetLevel changes the minimum level logged, at runtime, for the logger and
+// every logger derived from it.
+func (c *CustomLogger) SetLevel(level slog.Level) {
+	c.core.level.Set(level)
+}
+
+// Level returns the minimum level logged.
+func (c *CustomLogger) Level() slog.Level {
+	return c.core.level.Level()
+}
+
+// Enabled reports whether messages at level are logged.
+func (c *CustomLogger) Enabled(level slog.Level) bool {
+	return level >= c.core.level.Level()
+}
+
+// ParseLevel parses a level name such as "debug" or "WARN".
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+// With returns a logger adding args, slog style key/value pairs or
+// slog.Attr values, to everything it logs.
+func (c *CustomLogger) With(args ...any) *CustomLogger {
+	return &CustomLogger{
+		friendlyName: c.friendlyName,
+		core:         c.core,
+		handler:      c.handler.withAttrs(argsToAttrs(args)),
+		limit:        c.limit,
 	}
 }
 
+// Handler returns a slog.Handler writing the same shared.LogData lines as
+// the logger, so services can log through log/slog.
+func (c *CustomLogger) Handler() slog.Handler {
+	return c.handler
+}
+
+// Slog returns a slog.Logger backed by Handler.
+func (c *CustomLogger) Slog() *slog.Logger {
+	return slog.New(c.handler)
+}
+
+// Debug logs msg at debug level with args as fields, slog style. The
+// target, correlation_id, execution_id, trace_id and error keys fill the
+// matching log data fields.
+func (c *CustomLogger) Debug(msg string, args ...any) {
+	c.log(context.Background(), LevelDebug, msg, args...)
+}
+
+// Info logs msg at info level, see Debug.
+func (c *CustomLogger) Info(msg string, args ...any) {
+	c.log(context.Background(), LevelInfo, msg, args...)
+}
+
+// Warn logs msg at warn level, see Debug.
+func (c *CustomLogger) Warn(msg string, args ...any) {
+	c.log(context.Background(), LevelWarn, msg, args...)
+}
+
+// Error logs msg at error level, see Debug.
+func (c *CustomLogger) Error(msg string, args ...any) {
+	c.log(context.Background(), LevelError, msg, args...)
+}
+
+// DebugContext is Debug with the IDs carried by ctx.
+func (c *CustomLogger) DebugContext(ctx context.Context, msg string, args ...any) {
+	c.log(ctx, LevelDebug, msg, args...)
+}
+
+// InfoContext is Info with the IDs carried by ctx.
+func (c *CustomLogger) InfoContext(ctx context.Context, msg string, args ...any) {
+	c.log(ctx, LevelInfo, msg, args...)
+}
+
+// WarnContext is Warn with the IDs carried by ctx.
+func (c *CustomLogger) WarnContext(ctx context.Context, msg string, args ...any) {
+	c.log(ctx, LevelWarn, msg, args...)
+}
+
+// ErrorContext is Error with the IDs carried by ctx.
+func (c *CustomLogger) ErrorContext(ctx context.Context, msg string, args ...any) {
+	c.log(ctx, LevelError, msg, args...)
+}
+
+func (c *CustomLogger) log(ctx context.Context, level slog.Level, msg string, args ...any) {
+	if !c.Enabled(level) {
+		return
+	}
+
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+
+	record := slog.NewRecord(time.Now(), level, msg, pcs[0])
+	record.Add(args...)
+
+	if c.limit != nil && !c.limit.admit(c, ctx, &record) {
+		return
+	}
+
+	c.handler.Handle(ctx, record)
+}
+
+// Log logs message for target at error level when target is "error" or err
+// is set, and at info level otherwise.
 func (c *CustomLogger) Log(target string, message string, err interface{}, correlationID string, executionID string) {
+	c.logTarget(context.Background(), target, message, err, CorrelationIDKey, correlationID, ExecutionIDKey, executionID)
+}
+
+// LogContext is Log with the IDs carried by ctx.
+func (c *CustomLogger) LogContext(ctx context.Context, target string, message string, err interface{}) {
+	c.logTarget(ctx, target, message, err)
+}
+
+func (c *CustomLogger) logTarget(ctx context.Context, target string, message string, err interface{}, args ...any) {
+	level := LevelInfo
+	if target == "error" || err != nil {
+		level = LevelError
+	}
+
+	args = append(args, TargetKey, target)
+	if err != nil {
+		args = append(args, ErrorKey, err)
+	}
 
-	data := shared.LogData{
-		FriendlyName:  c.friendlyName,
-		Timestamp:     time.Now().Format(time.RFC3339Nano),
-		Target:        target,
-		CorrelationID: correlationID,
-		ExecutionID:   executionID,
-		Log: shared.LogEntry{
-			ExecutionID: executionID,
-			Message:     message,
-		},
-		Error: err,
-	}
-
-	str, _ := json.Marshal(data)
-	log.Println(string(str))
-	fmt.Println(string(str))
+	c.log(ctx, level, message, args...)
 }
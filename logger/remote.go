@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+)
+
+// remoteTimeout bounds the delivery of a batch by the broker and HTTP sinks.
+const remoteTimeout = 5 * time.Second
+
+// BrokerSink publishes lines to a topic, one message per line.
+type BrokerSink struct {
+	bus   broker.Broker
+	topic string
+}
+
+func NewBrokerSink(bus broker.Broker, topic string) *BrokerSink {
+	return &BrokerSink{bus: bus, topic: topic}
+}
+
+func (s *BrokerSink) Write(line []byte) error {
+	return s.WriteBatch([][]byte{line})
+}
+
+func (s *BrokerSink) WriteBatch(lines [][]byte) error {
+	msgs := make([]broker.Message, len(lines))
+	for i, line := range lines {
+		msgs[i] = broker.Message{Value: line}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	return s.bus.Publish(ctx, s.topic, msgs...)
+}
+
+// Close does nothing, the broker belongs to the caller.
+func (s *BrokerSink) Close() error {
+	return nil
+}
+
+// HTTPSink POSTs lines as newline delimited JSON, such as to the dashboard
+// backend's /api/logs.
+type HTTPSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSink(url string) *HTTPSink {
+	return &HTTPSink{url: url, client: &http.Client{Timeout: remoteTimeout}}
+}
+
+func (s *HTTPSink) Write(line []byte) error {
+	return s.WriteBatch([][]byte{line})
+}
+
+func (s *HTTPSink) WriteBatch(lines [][]byte) error {
+	var body bytes.Buffer
+	for _, line := range lines {
+		body.Write(line)
+		body.WriteByte('\n')
+	}
+
+	res, err := s.client.Post(s.url, "application/x-ndjson", &body)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s answered %s", s.url, res.Status)
+	}
+
+	return nil
+}
+
+func (s *HTTPSink) Close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,304 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// Sink kinds accepted in LOG_SINKS.
+const (
+	SinkStdout = "stdout"
+	SinkFile   = "file"
+	SinkBroker = "broker"
+	SinkHTTP   = "http"
+)
+
+var (
+	logSinks        = shared.GetEnv("LOG_SINKS", SinkStdout)
+	logBuffer       = shared.GetEnv("LOG_BUFFER", "4096")
+	logFile         = shared.GetEnv("LOG_FILE", "")
+	logFileMaxBytes = shared.GetEnv("LOG_FILE_MAX_BYTES", "10485760")
+	logFileMaxFiles = shared.GetEnv("LOG_FILE_MAX_FILES", "5")
+	logTopic        = shared.GetEnv("LOG_TOPIC", "logs")
+	logHTTPURL      = shared.GetEnv("LOG_HTTP_URL", "")
+)
+
+// Sink receives the JSON lines written by a logger.
+type Sink interface {
+	// Write delivers one line, without its trailing newline. It must not
+	// keep line after returning.
+	Write(line []byte) error
+	Close() error
+}
+
+// batchWriter is implemented by sinks that deliver many lines at once more
+// cheaply than one by one.
+type batchWriter interface {
+	WriteBatch(lines [][]byte) error
+}
+
+// SinkStats are the totals of an AsyncSink.
+type SinkStats struct {
+	Name string `json:"name"`
+	// Buffered is the number of lines waiting to be written.
+	Buffered  int    `json:"buffered"`
+	Written   int64  `json:"written"`
+	Dropped   int64  `json:"dropped"`
+	Failed    int64  `json:"failed"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// maxBatch bounds the lines an AsyncSink hands to a batch writer at once.
+const maxBatch = 256
+
+// AsyncSink writes lines to a sink from its own goroutine, so logging never
+// waits for it. Lines are buffered up to a bound and dropped, and counted,
+// once the sink falls that far behind.
+type AsyncSink struct {
+	name string
+	sink Sink
+
+	mu     sync.RWMutex
+	closed bool
+	lines  chan []byte
+	done   chan struct{}
+
+	written atomic.Int64
+	dropped atomic.Int64
+	failed  atomic.Int64
+	lastErr atomic.Value
+}
+
+// NewAsyncSink starts writing to sink through a buffer of up to buffer lines.
+func NewAsyncSink(name string, sink Sink, buffer int) *AsyncSink {
+	if buffer <= 0 {
+		buffer = 1
+	}
+
+	a := &AsyncSink{
+		name:  name,
+		sink:  sink,
+		lines: make(chan []byte, buffer),
+		done:  make(chan struct{}),
+	}
+
+	go a.run()
+
+	return a
+}
+
+// Write queues a copy of line, or drops it when the buffer is full or the
+// sink closed.
+func (a *AsyncSink) Write(line []byte) error {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.closed {
+		a.dropped.Add(1)
+		return nil
+	}
+
+	select {
+	case a.lines <- append([]byte(nil), line...):
+	default:
+		a.dropped.Add(1)
+	}
+
+	return nil
+}
+
+func (a *AsyncSink) run() {
+	defer close(a.done)
+
+	batcher, batches := a.sink.(batchWriter)
+
+	for line := range a.lines {
+		if !batches {
+			a.count(1, a.sink.Write(line))
+			continue
+		}
+
+		batch := [][]byte{line}
+	fill:
+		for len(batch) < maxBatch {
+			select {
+			case next, ok := <-a.lines:
+				if !ok {
+					break fill
+				}
+				batch = append(batch, next)
+			default:
+				break fill
+			}
+		}
+
+		a.count(len(batch), batcher.WriteBatch(batch))
+	}
+}
+
+func (a *AsyncSink) count(n int, err error) {
+	if err != nil {
+		a.failed.Add(int64(n))
+		a.lastErr.Store(err.Error())
+		return
+	}
+
+	a.written.Add(int64(n))
+}
+
+// Stats returns the sink's totals.
+func (a *AsyncSink) Stats() SinkStats {
+	lastErr, _ := a.lastErr.Load().(string)
+
+	return SinkStats{
+		Name:      a.name,
+		Buffered:  len(a.lines),
+		Written:   a.written.Load(),
+		Dropped:   a.dropped.Load(),
+		Failed:    a.failed.Load(),
+		LastError: lastErr,
+	}
+}
+
+// Close writes the buffered lines and closes the sink. Lines written after
+// Close are dropped.
+func (a *AsyncSink) Close() error {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return nil
+	}
+	a.closed = true
+	close(a.lines)
+	a.mu.Unlock()
+
+	<-a.done
+
+	return a.sink.Close()
+}
+
+// WriterSink writes lines to an io.Writer, such as stdout, which is what the
+// docker log collector reads.
+type WriterSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(line []byte) error {
+	buf := make([]byte, len(line)+1)
+	copy(buf, line)
+	buf[len(line)] = '\n'
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.w.Write(buf)
+	return err
+}
+
+// Close does nothing, the writer belongs to the caller.
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// Sinks are the sinks of a service, shared by its loggers.
+type Sinks []Sink
+
+// Close closes every sink, writing what they buffered.
+func (s Sinks) Close() error {
+	var errs []error
+	for _, sink := range s {
+		errs = append(errs, sink.Close())
+	}
+
+	return errors.Join(errs...)
+}
+
+// SinksFromEnv returns the sinks named by LOG_SINKS, a comma separated list
+// of stdout, file, broker and http, each asynchronous with a buffer of
+// LOG_BUFFER lines:
+//
+//	file    LOG_FILE, rotated at LOG_FILE_MAX_BYTES keeping LOG_FILE_MAX_FILES
+//	broker  the LOG_TOPIC topic of bus, stored by a backend given BROKER_KIND
+//	http    POSTed in batches to LOG_HTTP_URL
+//
+// bus may be nil for services without a broker.
+func SinksFromEnv(bus broker.Broker) (Sinks, error) {
+	buffer, err := strconv.Atoi(logBuffer)
+	if err != nil || buffer <= 0 {
+		return nil, fmt.Errorf("invalid LOG_BUFFER %q", logBuffer)
+	}
+
+	var sinks Sinks
+
+	for _, kind := range strings.Split(logSinks, ",") {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+
+		sink, err := newSink(kind, bus)
+		if err != nil {
+			sinks.Close()
+			return nil, fmt.Errorf("log sink %s: %w", kind, err)
+		}
+
+		sinks = append(sinks, NewAsyncSink(kind, sink, buffer))
+	}
+
+	if len(sinks) == 0 {
+		return nil, errors.New("no log sinks in LOG_SINKS")
+	}
+
+	return sinks, nil
+}
+
+func newSink(kind string, bus broker.Broker) (Sink, error) {
+	switch kind {
+	case SinkStdout:
+		return NewWriterSink(os.Stdout), nil
+	case SinkFile:
+		if logFile == "" {
+			return nil, errors.New("LOG_FILE is not set")
+		}
+
+		maxBytes, err := strconv.ParseInt(logFileMaxBytes, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_FILE_MAX_BYTES: %v", err)
+		}
+
+		maxFiles, err := strconv.Atoi(logFileMaxFiles)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_FILE_MAX_FILES: %v", err)
+		}
+
+		return NewFileSink(logFile, maxBytes, maxFiles)
+	case SinkBroker:
+		if bus == nil {
+			return nil, errors.New("no broker in this service")
+		}
+
+		return NewBrokerSink(bus, logTopic), nil
+	case SinkHTTP:
+		if logHTTPURL == "" {
+			return nil, errors.New("LOG_HTTP_URL is not set")
+		}
+
+		return NewHTTPSink(logHTTPURL), nil
+	default:
+		return nil, errors.New("unknown sink")
+	}
+}
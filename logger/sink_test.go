@@ -0,0 +1,365 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// blockingSink holds each Write until released, recording the lines.
+type blockingSink struct {
+	started chan struct{}
+	release chan struct{}
+
+	mu    sync.Mutex
+	lines []string
+}
+
+func newBlockingSink() *blockingSink {
+	return &blockingSink{started: make(chan struct{}, 1), release: make(chan struct{})}
+}
+
+func (s *blockingSink) Write(line []byte) error {
+	select {
+	case s.started <- struct{}{}:
+	default:
+	}
+	<-s.release
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lines = append(s.lines, string(line))
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	return nil
+}
+
+// failingSink fails every batch.
+type failingSink struct{}
+
+func (failingSink) Write(line []byte) error         { return errors.New("sink down") }
+func (failingSink) WriteBatch(lines [][]byte) error { return errors.New("sink down") }
+func (failingSink) Close() error                    { return nil }
+
+func TestAsyncSinkDropsWhenFull(t *testing.T) {
+	sink := newBlockingSink()
+	async := NewAsyncSink("slow", sink, 2)
+
+	async.Write([]byte("1"))
+	<-sink.started
+
+	// The sink holds line 1, the buffer takes 2 and 3.
+	for _, line := range []string{"2", "3", "4", "5"} {
+		async.Write([]byte(line))
+	}
+
+	if stats := async.Stats(); stats.Name != "slow" || stats.Buffered != 2 || stats.Dropped != 2 || stats.Written != 0 {
+		t.Fatalf("stats %+v, want 2 buffered and 2 dropped", stats)
+	}
+
+	close(sink.release)
+	if err := async.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	async.Write([]byte("6"))
+
+	if stats := async.Stats(); stats.Buffered != 0 || stats.Written != 3 || stats.Dropped != 3 {
+		t.Errorf("stats after Close %+v, want 3 written and 3 dropped", stats)
+	}
+
+	if got := strings.Join(sink.lines, ","); got != "1,2,3" {
+		t.Errorf("wrote %s, want 1,2,3", got)
+	}
+}
+
+func TestAsyncSinkCountsFailures(t *testing.T) {
+	async := NewAsyncSink("down", failingSink{}, 8)
+
+	for i := 0; i < 3; i++ {
+		async.Write([]byte("line"))
+	}
+	async.Close()
+
+	if stats := async.Stats(); stats.Failed != 3 || stats.Written != 0 || stats.LastError != "sink down" {
+		t.Errorf("stats %+v, want 3 failed with sink down", stats)
+	}
+}
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Fields(string(content))
+}
+
+func TestFileSinkRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "service.log")
+
+	// Lines of 5 bytes with their newline, so files hold 2 lines.
+	sink, err := NewFileSink(path, 10, 2)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+
+	sink.Write([]byte("0001"))
+	sink.Write([]byte("0002"))
+	if err := sink.WriteBatch([][]byte{[]byte("0003"), []byte("0004"), []byte("0005"), []byte("0006"), []byte("0007")}); err != nil {
+		t.Fatalf("WriteBatch: %v", err)
+	}
+	sink.Close()
+
+	if err := sink.Write([]byte("0008")); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Write after Close = %v, want %v", err, os.ErrClosed)
+	}
+
+	// Reopening appends to the current file, counting what it holds.
+	sink, err = NewFileSink(path, 10, 2)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	sink.Write([]byte("0008"))
+	sink.Close()
+
+	want := map[string]string{
+		path:        "0007 0008",
+		path + ".1": "0005 0006",
+		path + ".2": "0003 0004",
+	}
+	for file, lines := range want {
+		if got := strings.Join(readLines(t, file), " "); got != lines {
+			t.Errorf("%s holds %q, want %q", filepath.Base(file), got, lines)
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("kept %s.3, want only 2 rotated files", filepath.Base(path))
+	}
+}
+
+func TestFileSinkWithoutRotatedFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "service.log")
+
+	sink, err := NewFileSink(path, 10, 0)
+	if err != nil {
+		t.Fatalf("NewFileSink: %v", err)
+	}
+	for _, line := range []string{"0001", "0002", "0003"} {
+		sink.Write([]byte(line))
+	}
+	sink.Close()
+
+	if got := readLines(t, path); len(got) != 1 || got[0] != "0003" {
+		t.Errorf("file holds %v, want the lines since it was truncated", got)
+	}
+
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Error("rotated to service.log.1 with no rotated files kept")
+	}
+}
+
+// setSinkEnv sets the LOG_ variables read by SinksFromEnv for the test.
+func setSinkEnv(t *testing.T, sinks, buffer, file, httpURL string) {
+	t.Helper()
+
+	saved := []string{logSinks, logBuffer, logFile, logHTTPURL, logFileMaxBytes, logFileMaxFiles, logTopic}
+	t.Cleanup(func() {
+		logSinks, logBuffer, logFile, logHTTPURL, logFileMaxBytes, logFileMaxFiles, logTopic = saved[0], saved[1], saved[2], saved[3], saved[4], saved[5], saved[6]
+	})
+
+	logSinks, logBuffer, logFile, logHTTPURL = sinks, buffer, file, httpURL
+	logFileMaxBytes, logFileMaxFiles, logTopic = "10485760", "5", "logs"
+}
+
+func TestSinksFromEnv(t *testing.T) {
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	file := filepath.Join(t.TempDir(), "service.log")
+
+	tests := []struct {
+		name      string
+		sinks     string
+		buffer    string
+		file      string
+		httpURL   string
+		bus       broker.Broker
+		wantNames []string
+	}{
+		{name: "stdout", sinks: "stdout", buffer: "4096", wantNames: []string{"stdout"}},
+		{name: "all", sinks: " stdout, file,broker ,http,", buffer: "16", file: file, httpURL: "http://backend:3000/api/logs", bus: bus, wantNames: []string{"stdout", "file", "broker", "http"}},
+		{name: "invalid buffer", sinks: "stdout", buffer: "many"},
+		{name: "zero buffer", sinks: "stdout", buffer: "0"},
+		{name: "unknown", sinks: "stdout,syslog", buffer: "16"},
+		{name: "file without LOG_FILE", sinks: "file", buffer: "16"},
+		{name: "http without LOG_HTTP_URL", sinks: "http", buffer: "16"},
+		{name: "broker without a broker", sinks: "broker", buffer: "16"},
+		{name: "none", sinks: " , ", buffer: "16"},
+	}
+
+	for _, tt := range tests {
+		setSinkEnv(t, tt.sinks, tt.buffer, tt.file, tt.httpURL)
+
+		sinks, err := SinksFromEnv(tt.bus)
+		if tt.wantNames == nil {
+			if err == nil {
+				sinks.Close()
+				t.Errorf("%s: SinksFromEnv accepted LOG_SINKS %q and LOG_BUFFER %q", tt.name, tt.sinks, tt.buffer)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: SinksFromEnv: %v", tt.name, err)
+			continue
+		}
+
+		var names []string
+		for _, sink := range sinks {
+			async, ok := sink.(*AsyncSink)
+			if !ok {
+				t.Errorf("%s: sink %T, want an AsyncSink", tt.name, sink)
+				continue
+			}
+			names = append(names, async.Stats().Name)
+		}
+		sinks.Close()
+
+		if strings.Join(names, ",") != strings.Join(tt.wantNames, ",") {
+			t.Errorf("%s: sinks %v, want %v", tt.name, names, tt.wantNames)
+		}
+	}
+}
+
+func TestSinksFromEnvRejectsFileLimits(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "service.log")
+
+	for _, limits := range [][2]string{{"10MB", "5"}, {"1024", "all"}} {
+		setSinkEnv(t, "file", "16", file, "")
+		logFileMaxBytes, logFileMaxFiles = limits[0], limits[1]
+
+		if sinks, err := SinksFromEnv(nil); err == nil {
+			sinks.Close()
+			t.Errorf("SinksFromEnv accepted LOG_FILE_MAX_BYTES %q and LOG_FILE_MAX_FILES %q", limits[0], limits[1])
+		}
+	}
+}
+
+func TestHTTPSinkPostsBatches(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		bodies []string
+		status = http.StatusAccepted
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Errorf("%s with %s, want a POST of application/x-ndjson", r.Method, r.Header.Get("Content-Type"))
+		}
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	sink := NewHTTPSink(server.URL + "/api/logs")
+	t.Cleanup(func() { sink.Close() })
+
+	if err := sink.WriteBatch([][]byte{[]byte(`{"n":1}`), []byte(`{"n":2}`)}); err != nil {
+		t.Fatalf("WriteBatch: %v", err)
+	}
+	if err := sink.Write([]byte(`{"n":3}`)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	mu.Lock()
+	status = http.StatusServiceUnavailable
+	mu.Unlock()
+
+	if err := sink.Write([]byte(`{"n":4}`)); err == nil || !strings.Contains(err.Error(), "503") {
+		t.Errorf("Write to a failing backend = %v, want its status", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := []string{"{\"n\":1}\n{\"n\":2}\n", "{\"n\":3}\n", "{\"n\":4}\n"}
+	if strings.Join(bodies, "|") != strings.Join(want, "|") {
+		t.Errorf("posted %q, want %q", bodies, want)
+	}
+}
+
+func TestBrokerSinkPublishesLogTopic(t *testing.T) {
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	// The backend stores the lines published to the LOG_TOPIC it shares with
+	// the services.
+	setSinkEnv(t, "broker", "16", "", "")
+	sinks, err := SinksFromEnv(bus)
+	if err != nil {
+		t.Fatalf("SinksFromEnv: %v", err)
+	}
+
+	customLogger := New("test")
+	customLogger.SetSinks(sinks...)
+	customLogger.SetRedactor(nil)
+
+	customLogger.Log("kafka", "first", nil, "corr-1", "exec-1")
+	customLogger.Log("kafka", "second", nil, "corr-2", "exec-2")
+
+	// Close writes what the sink buffered.
+	if err := sinks.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	sub, err := bus.Subscribe("logs", broker.SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	t.Cleanup(func() { sub.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, want := range []string{"first", "second"} {
+		msg, err := sub.Fetch(ctx)
+		if err != nil {
+			t.Fatalf("Fetch: %v", err)
+		}
+
+		var data shared.LogData
+		if err := json.Unmarshal(msg.Value, &data); err != nil {
+			t.Fatalf("decoding %s: %v", msg.Value, err)
+		}
+
+		if data.Log.Message != want || data.Target != "kafka" || data.FriendlyName != "test" {
+			t.Errorf("published %+v, want %s", data, want)
+		}
+	}
+
+	if stats := customLogger.SinkStats(); len(stats) != 1 || stats[0].Written != 2 {
+		t.Errorf("sink stats %+v, want 2 lines written", stats)
+	}
+}
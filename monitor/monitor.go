@@ -1,156 +1,398 @@
-package main
+package monitor
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/assimoes/rtd-sandbox/broker"
 	"github.com/assimoes/rtd-sandbox/logger"
 	"github.com/assimoes/rtd-sandbox/shared"
-	"github.com/segmentio/kafka-go"
 )
 
-var (
-	broker       = shared.GetEnv("KAFKA_BROKER", "localhost:9092")
-	friendlyName = shared.GetEnv("FRIENDLY_NAME", "monitor")
-)
+// Config configures a Monitor.
+type Config struct {
+	FriendlyName string
+	// Group is the consumer group used for the control, commit and cancel topics.
+	Group string
+	// EventRoutes is a comma separated list of pattern=topic routing rules.
+	EventRoutes       string
+	DefaultEventTopic string
+	// ReadBackoff is the wait after a failed read, doubled on every
+	// consecutive failure up to MaxReadBackoff. Defaults to 100ms and 10s.
+	ReadBackoff    time.Duration
+	MaxReadBackoff time.Duration
+	// OriginTTL is how long the origin of a request is kept waiting for its
+	// commit or cancel. Defaults to 10m.
+	OriginTTL time.Duration
+	// MaxOrigins bounds the origins kept, dropping the oldest ones first.
+	// Defaults to 100000.
+	MaxOrigins int
+	// MaxOriginBytes bounds the bytes of the origins kept, which carry the
+	// request payloads, dropping the oldest ones first. Defaults to 64 MiB.
+	MaxOriginBytes int64
+	// CallbackTimeout bounds a callback to a producer. Defaults to 10s.
+	CallbackTimeout time.Duration
+	// CallbackConcurrency is how many producers are called back at once,
+	// so a slow producer doesn't hold back the others. Defaults to 16.
+	CallbackConcurrency int
+}
+
+// readErrorLimit bounds the read errors logged per topic, such as while
+// the broker is unreachable.
+var readErrorLimit = logger.Limit{Burst: 3, Interval: 30 * time.Second}
+
+// originDropLimit bounds the dropped origins logged, such as while a
+// producer never decides its requests.
+var originDropLimit = logger.Limit{Burst: 10, Interval: 30 * time.Second}
+
+// Monitor calls producers back for every data request and publishes an event
+// for every committed request.
+type Monitor struct {
+	cfg          Config
+	bus          broker.Broker
+	customLogger *logger.CustomLogger
+	eventRouter  *router
+	origins      *originCache
+	client       *http.Client
+}
+
+func New(cfg Config, bus broker.Broker, customLogger *logger.CustomLogger) (*Monitor, error) {
+	if cfg.DefaultEventTopic == "" {
+		cfg.DefaultEventTopic = "e_topic"
+	}
+	if cfg.ReadBackoff <= 0 {
+		cfg.ReadBackoff = 100 * time.Millisecond
+	}
+	if cfg.MaxReadBackoff <= 0 {
+		cfg.MaxReadBackoff = 10 * time.Second
+	}
+	if cfg.OriginTTL <= 0 {
+		cfg.OriginTTL = 10 * time.Minute
+	}
+	if cfg.MaxOrigins <= 0 {
+		cfg.MaxOrigins = 100000
+	}
+	if cfg.MaxOriginBytes <= 0 {
+		cfg.MaxOriginBytes = 64 << 20
+	}
+	if cfg.CallbackTimeout <= 0 {
+		cfg.CallbackTimeout = 10 * time.Second
+	}
+	if cfg.CallbackConcurrency <= 0 {
+		cfg.CallbackConcurrency = 16
+	}
+
+	eventRouter, err := parseRoutes(cfg.EventRoutes, cfg.DefaultEventTopic)
+	if err != nil {
+		return nil, err
+	}
+
+	dropLogger := customLogger.Limited("origin dropped", originDropLimit)
+
+	return &Monitor{
+		cfg:          cfg,
+		bus:          bus,
+		customLogger: customLogger,
+		eventRouter:  eventRouter,
+		origins: newOriginCache(cfg.OriginTTL, cfg.MaxOrigins, cfg.MaxOriginBytes, func(correlationID string, expired bool) {
+			reason := fmt.Sprintf("more than %d requests or %d bytes of origins in flight", cfg.MaxOrigins, cfg.MaxOriginBytes)
+			if expired {
+				reason = fmt.Sprintf("no commit or cancel within %s", cfg.OriginTTL)
+			}
+			dropLogger.Log("error", fmt.Sprintf("dropped the origin of request %s: %s", correlationID, reason), nil, correlationID, "")
+		}),
+		client: &http.Client{Timeout: cfg.CallbackTimeout},
+	}, nil
+}
+
+// Handler returns the monitor's HTTP routes, the log level at /loglevel.
+func (m *Monitor) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/loglevel", m.customLogger.LevelHandler())
+	return mux
+}
+
+// Run consumes the pipeline topics until ctx is done.
+func (m *Monitor) Run(ctx context.Context) error {
+	controlSub, controlCh, controlErrCh, err := m.readTopic(ctx, "control")
+	if err != nil {
+		return err
+	}
+	defer controlSub.Close()
 
-func main() {
+	commitSub, commitCh, commitErrCh, err := m.readTopic(ctx, "commit")
+	if err != nil {
+		return err
+	}
+	defer commitSub.Close()
+
+	cancelSub, cancelCh, cancelErrCh, err := m.readTopic(ctx, "cancel")
+	if err != nil {
+		return err
+	}
+	defer cancelSub.Close()
 
-	controlCh, controlErrCh := readTopic("control")
-	commitCh, commitErrCh := readTopic("commit")
+	var wg sync.WaitGroup
+	wg.Add(6)
 
-	customLogger := logger.New(friendlyName)
+	go func() { defer wg.Done(); m.errorLogger(ctx, "control", controlErrCh) }()
+	go func() { defer wg.Done(); m.errorLogger(ctx, "commit", commitErrCh) }()
+	go func() { defer wg.Done(); m.errorLogger(ctx, "cancel", cancelErrCh) }()
 
-	go errorLogger("control", controlErrCh, customLogger)
-	go errorLogger("commit", commitErrCh, customLogger)
+	go func() { defer wg.Done(); m.processDataRequests(ctx, controlSub, controlCh) }()
+	go func() { defer wg.Done(); m.processCommitRequests(ctx, commitSub, commitCh) }()
+	go func() { defer wg.Done(); m.processCancelRequests(ctx, cancelSub, cancelCh) }()
 
-	go processDataRequests(controlCh, customLogger)
-	go processCommitRequests(commitCh, customLogger)
+	wg.Wait()
 
-	select {}
+	return ctx.Err()
 }
 
-func readTopic(topic string) (chan kafka.Message, chan error) {
-	msgCh, errCh := make(chan kafka.Message, 1000), make(chan error, 1000)
+func (m *Monitor) readTopic(ctx context.Context, topic string) (broker.Subscription, chan broker.Message, chan error, error) {
+	sub, err := m.bus.Subscribe(topic, broker.SubscribeOptions{Group: m.cfg.Group})
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers:   []string{broker},
-		Topic:     topic,
-		Partition: 0,
-		Dialer:    kafka.DefaultDialer,
-	})
+	msgCh, errCh := make(chan broker.Message, 1000), make(chan error, 1000)
 
 	go func() {
+		defer close(msgCh)
+		defer close(errCh)
+
+		var backoff time.Duration
+
 		for {
-			msg, err := reader.ReadMessage(context.Background())
+			msg, err := sub.Fetch(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+
 			if err != nil {
 				errCh <- err
+
+				// Back off on consecutive errors, such as while the broker
+				// is unreachable, instead of failing in a tight loop.
+				backoff = min(max(2*backoff, m.cfg.ReadBackoff), m.cfg.MaxReadBackoff)
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(backoff):
+				}
+
 				continue
 			}
 
+			backoff = 0
 			msgCh <- msg
 		}
 	}()
 
-	return msgCh, errCh
+	return sub, msgCh, errCh, nil
 }
 
-func errorLogger(topic string, errCh chan error, customLogger *logger.CustomLogger) {
+func (m *Monitor) errorLogger(ctx context.Context, topic string, errCh chan error) {
+	customLogger := m.customLogger.Limited("read "+topic, readErrorLimit)
+
 	for err := range errCh {
-		customLogger.Log("error", fmt.Sprintf("error reading from %s topic: %v", topic, err), err, "", "")
+		customLogger.LogContext(ctx, "error", fmt.Sprintf("error reading from %s topic: %v", topic, err), err)
 	}
 }
 
-func processDataRequests(controlCh chan kafka.Message, customLogger *logger.CustomLogger) {
-	for ctrl := range controlCh {
+// processDataRequests calls the producers back concurrently. A control
+// message is committed once its callback and those of every earlier message
+// are done.
+func (m *Monitor) processDataRequests(ctx context.Context, sub broker.Subscription, controlCh chan broker.Message) {
+	commits := broker.NewCommits()
+	slots := make(chan struct{}, m.cfg.CallbackConcurrency)
 
-		var executionID string
+	var wg sync.WaitGroup
+	defer wg.Wait()
 
-		for _, header := range ctrl.Headers {
-			if header.Key == "execution_id" {
-				executionID = string(header.Value)
-				break
-			}
-		}
+	for ctrl := range controlCh {
+		commits.Track(ctrl)
+
+		headers := shared.ParseHeaders(ctrl.Headers)
 
 		var data shared.DataRequest
 		json.Unmarshal(ctrl.Value, &data)
 
-		if executionID != "" {
-			data.ExecutionID = executionID
+		if headers.ExecutionID != "" {
+			data.ExecutionID = headers.ExecutionID
 		}
 
-		customLogger.Log("kafka", fmt.Sprintf("received data request %s", data.ExecutionID), nil, data.CorrelationID, data.ExecutionID)
+		if headers.CorrelationID != "" {
+			data.CorrelationID = headers.CorrelationID
+		}
 
-		res, err := http.Get(data.Callback + "?correlation_id=" + data.CorrelationID + "&execution_id=" + data.ExecutionID)
+		msgCtx := logger.WithIDs(logger.WithHeaders(ctx, headers), logger.IDs{CorrelationID: data.CorrelationID, ExecutionID: data.ExecutionID})
 
-		if err != nil {
-			customLogger.Log("error", fmt.Sprintf("error calling back the source system: %v", err), err, data.CorrelationID, data.ExecutionID)
-			continue
-		}
+		m.customLogger.LogContext(msgCtx, "kafka", fmt.Sprintf("received data request %s from %s (hop %d)", data.ExecutionID, headers.OriginService, headers.Hops), nil)
+
+		m.origins.put(data.CorrelationID, originOf(data))
+
+		slots <- struct{}{}
+		wg.Add(1)
 
-		customLogger.Log(data.ServiceName, fmt.Sprintf("got http status code from source system: %s", res.Status), nil, data.CorrelationID, data.ExecutionID)
+		go func(ctrl broker.Message, data shared.DataRequest) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			m.callbackProducer(msgCtx, data)
+
+			if ready := commits.Complete(ctrl, true); len(ready) > 0 {
+				sub.Commit(ctx, ready...)
+			}
+		}(ctrl, data)
 	}
 }
 
-func processCommitRequests(commitCh chan kafka.Message, customLogger *logger.CustomLogger) {
+func (m *Monitor) callbackProducer(ctx context.Context, data shared.DataRequest) {
+	res, err := m.callback(ctx, data.Callback+"?correlation_id="+data.CorrelationID+"&execution_id="+data.ExecutionID)
+	if err != nil {
+		m.customLogger.LogContext(ctx, "error", fmt.Sprintf("error calling back the source system: %v", err), err)
+		return
+	}
+	res.Body.Close()
 
-	for cmt := range commitCh {
+	m.customLogger.LogContext(ctx, data.ServiceName, fmt.Sprintf("got http status code from source system: %s", res.Status), nil)
+}
 
-		var executionID string
+func (m *Monitor) processCommitRequests(ctx context.Context, sub broker.Subscription, commitCh chan broker.Message) {
 
-		for _, header := range cmt.Headers {
-			if header.Key == "execution_id" {
-				executionID = string(header.Value)
-				break
-			}
-		}
+	for cmt := range commitCh {
+
+		headers := shared.ParseHeaders(cmt.Headers)
 
 		var data shared.CommitRequest
 		json.Unmarshal(cmt.Value, &data)
 
-		if executionID != "" {
-			data.ExecutionID = executionID
+		if headers.ExecutionID != "" {
+			data.ExecutionID = headers.ExecutionID
+		}
+
+		if headers.CorrelationID != "" {
+			data.CorrelationID = headers.CorrelationID
+		}
+
+		msgCtx := logger.WithIDs(logger.WithHeaders(ctx, headers), logger.IDs{CorrelationID: data.CorrelationID, ExecutionID: data.ExecutionID})
+
+		// The origin carried by the commit is preferred, it doesn't depend
+		// on this instance having read the data request.
+		o, ok := m.origins.get(data.CorrelationID)
+		if data.Request != nil {
+			o, ok = originOf(*data.Request), true
+		}
+
+		if ok && o.serviceName != "" {
+			data.OriginService = o.serviceName
 		}
 
 		if data.Commit {
+			if !ok {
+				m.customLogger.LogContext(msgCtx, "error", fmt.Sprintf("no origin for %s, its event has no payload", data.CorrelationID), nil)
+			}
+
 			evt := shared.Event{
 				CorrelationID: data.CorrelationID,
 				ExecutionID:   data.ExecutionID,
 				ServiceName:   data.OriginService,
+				Type:          shared.EventTypeCommitted,
+				RequestedAt:   o.requestedAt,
+				ContentType:   o.contentType,
+				Payload:       o.payload,
+				Attributes:    o.attributes,
+				PayloadRef:    o.payloadRef,
 			}
 
-			customLogger.Log("kafka", fmt.Sprintf("received event %s", evt.CorrelationID), nil, evt.CorrelationID, evt.ExecutionID)
+			m.customLogger.LogContext(msgCtx, "kafka", fmt.Sprintf("received event %s from %s (hop %d)", evt.CorrelationID, headers.OriginService, headers.Hops), nil)
 
 			evtData, _ := json.Marshal(evt)
 
-			err := publish("e_topic", []kafka.Message{{
-				Key:       []byte(evt.CorrelationID),
-				Value:     evtData,
-				Partition: 0,
-			}}, customLogger)
+			topic := m.eventRouter.topicFor(evt.ServiceName)
 
-			if err != nil {
-				customLogger.Log("kafka", fmt.Sprintf("error publishing event to event topic: %v", err), err, evt.CorrelationID, evt.ExecutionID)
+			// The origin is kept, and the commit not acknowledged, until
+			// the event is published.
+			if err := m.publishRetrying(msgCtx, topic, broker.Message{
+				Key:     []byte(evt.CorrelationID),
+				Value:   evtData,
+				Headers: headers.Forward(m.cfg.FriendlyName, shared.ContentTypeEvent).Encode(),
+			}); err != nil {
 				continue
 			}
 
-			customLogger.Log("kafka", fmt.Sprintf("published event %s to event topic", evt.CorrelationID), nil, evt.CorrelationID, evt.ExecutionID)
+			m.customLogger.LogContext(msgCtx, "kafka", fmt.Sprintf("published event %s to %s topic", evt.CorrelationID, topic), nil)
 		}
+
+		m.origins.take(data.CorrelationID)
+		sub.Commit(ctx, cmt)
 	}
 }
 
-func publish(topic string, data []kafka.Message, customLogger *logger.CustomLogger) error {
-	k := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{broker},
-		Dialer:  kafka.DefaultDialer,
-		Topic:   topic,
-	})
+func (m *Monitor) processCancelRequests(ctx context.Context, sub broker.Subscription, cancelCh chan broker.Message) {
+	for cnl := range cancelCh {
+
+		headers := shared.ParseHeaders(cnl.Headers)
+
+		var data shared.CommitRequest
+		json.Unmarshal(cnl.Value, &data)
+
+		if headers.CorrelationID != "" {
+			data.CorrelationID = headers.CorrelationID
+		}
+
+		msgCtx := logger.WithIDs(logger.WithHeaders(ctx, headers), logger.IDs{CorrelationID: data.CorrelationID})
+
+		m.origins.take(data.CorrelationID)
+
+		m.customLogger.LogContext(msgCtx, "kafka", fmt.Sprintf("received cancel %s", data.CorrelationID), nil)
+
+		sub.Commit(ctx, cnl)
+	}
+}
+
+// callback calls a producer back, passing on the IDs carried by ctx.
+func (m *Monitor) callback(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	logger.SetHTTPHeaders(ctx, req.Header)
+
+	return m.client.Do(req)
+}
+
+// publishRetrying publishes until it succeeds or ctx is done, backing off
+// like reads do.
+func (m *Monitor) publishRetrying(ctx context.Context, topic string, messages ...broker.Message) error {
+	var backoff time.Duration
+
+	for {
+		err := m.publish(ctx, topic, messages...)
+		if err == nil || ctx.Err() != nil || errors.Is(err, broker.ErrClosed) {
+			return err
+		}
+
+		backoff = min(max(2*backoff, m.cfg.ReadBackoff), m.cfg.MaxReadBackoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
 
-	if err := k.WriteMessages(context.Background(), data...); err != nil {
-		customLogger.Log("error", fmt.Sprintf("Error when publishing to %s topic: %v", topic, err), err, "", "")
+func (m *Monitor) publish(ctx context.Context, topic string, messages ...broker.Message) error {
+	if err := m.bus.Publish(ctx, topic, messages...); err != nil {
+		m.customLogger.LogContext(ctx, "error", fmt.Sprintf("Error when publishing to %s topic: %v", topic, err), err)
 		return err
 	}
 
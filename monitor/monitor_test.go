@@ -0,0 +1,265 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+func startMonitor(t *testing.T, cfg Config) broker.Broker {
+	t.Helper()
+
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	customLogger := logger.New("monitor")
+	customLogger.SetOutput(io.Discard)
+
+	m, err := New(cfg, bus, customLogger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); m.Run(ctx) }()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return bus
+}
+
+func publishControl(t *testing.T, bus broker.Broker, correlationID, callback string) {
+	t.Helper()
+
+	data, _ := json.Marshal(shared.DataRequest{CorrelationID: correlationID, ExecutionID: "exec-" + correlationID, Callback: callback})
+	if err := bus.Publish(context.Background(), "control", broker.Message{Key: []byte(correlationID), Value: data}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+}
+
+func TestMonitorCallsBackConcurrently(t *testing.T) {
+	slowGaveUp := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(slowGaveUp)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+
+	fastCalled := make(chan struct{})
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(fastCalled)
+	}))
+	t.Cleanup(fast.Close)
+
+	bus := startMonitor(t, Config{Group: "monitor", CallbackTimeout: 300 * time.Millisecond})
+
+	publishControl(t, bus, "slow", slow.URL)
+	publishControl(t, bus, "fast", fast.URL)
+
+	select {
+	case <-fastCalled:
+	case <-slowGaveUp:
+		t.Fatal("the fast producer waited for the slow one")
+	case <-time.After(2 * time.Second):
+		t.Fatal("the fast producer was not called back")
+	}
+
+	select {
+	case <-slowGaveUp:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the slow callback didn't time out")
+	}
+}
+
+func TestCommitCarriesOrigin(t *testing.T) {
+	bus := startMonitor(t, Config{Group: "monitor"})
+
+	sub, err := bus.Subscribe("e_topic", broker.SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	defer sub.Close()
+
+	// No control message: the monitor learns the origin from the commit.
+	data, _ := json.Marshal(shared.CommitRequest{
+		CorrelationID: "corr-1",
+		ExecutionID:   "exec-1",
+		Commit:        true,
+		Request:       &shared.DataRequest{ServiceName: "payments", Payload: json.RawMessage(`{"amount":42}`)},
+	})
+	if err := bus.Publish(context.Background(), "commit", broker.Message{Key: []byte("corr-1"), Value: data}); err != nil {
+		t.Fatalf("Publish: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	msg, err := sub.Fetch(ctx)
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+
+	var evt shared.Event
+	json.Unmarshal(msg.Value, &evt)
+
+	if evt.ServiceName != "payments" || string(evt.Payload) != `{"amount":42}` {
+		t.Fatalf("event from %q with payload %s, want the commit's request", evt.ServiceName, evt.Payload)
+	}
+}
+
+func TestMonitorHandlerServesLogLevel(t *testing.T) {
+	customLogger := logger.New("monitor")
+	customLogger.SetOutput(io.Discard)
+
+	m, err := New(Config{}, nil, customLogger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	rec := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/loglevel", strings.NewReader(`{"level": "debug"}`)))
+
+	if rec.Code != http.StatusOK || customLogger.Level() != logger.LevelDebug {
+		t.Fatalf("PUT /loglevel = %d, level %s, want debug", rec.Code, customLogger.Level())
+	}
+}
+
+// scriptedBroker answers the fetches of its subscriptions with its script, a
+// read error or a message for each fetch, recording when they happen. Once
+// the script is over fetches block until ctx is done.
+type scriptedBroker struct {
+	broker.Broker
+
+	mu      sync.Mutex
+	script  []error
+	fetches []time.Time
+	// over is closed by the first fetch past the script.
+	over  chan struct{}
+	ended bool
+}
+
+func newScriptedBroker(script ...error) *scriptedBroker {
+	return &scriptedBroker{script: script, over: make(chan struct{})}
+}
+
+func (b *scriptedBroker) Subscribe(topic string, opts broker.SubscribeOptions) (broker.Subscription, error) {
+	return b, nil
+}
+
+func (b *scriptedBroker) Fetch(ctx context.Context) (broker.Message, error) {
+	b.mu.Lock()
+	b.fetches = append(b.fetches, time.Now())
+
+	if len(b.script) == 0 {
+		if !b.ended {
+			b.ended = true
+			close(b.over)
+		}
+		b.mu.Unlock()
+
+		<-ctx.Done()
+		return broker.Message{}, ctx.Err()
+	}
+
+	err := b.script[0]
+	b.script = b.script[1:]
+	b.mu.Unlock()
+
+	if err != nil {
+		return broker.Message{}, err
+	}
+
+	return broker.Message{Value: []byte("{}")}, nil
+}
+
+func (b *scriptedBroker) Commit(ctx context.Context, msgs ...broker.Message) error {
+	return nil
+}
+
+func (b *scriptedBroker) Close() error {
+	return nil
+}
+
+// waits returns the time between consecutive fetches.
+func (b *scriptedBroker) waits() []time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	waits := make([]time.Duration, len(b.fetches)-1)
+	for i := range waits {
+		waits[i] = b.fetches[i+1].Sub(b.fetches[i])
+	}
+
+	return waits
+}
+
+func TestReadTopicBacksOff(t *testing.T) {
+	down := errors.New("broker unreachable")
+	bus := newScriptedBroker(down, down, down, down, nil, down, down)
+
+	customLogger := logger.New("monitor")
+	customLogger.SetOutput(io.Discard)
+
+	m, err := New(Config{ReadBackoff: 30 * time.Millisecond, MaxReadBackoff: 120 * time.Millisecond}, bus, customLogger)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, msgCh, errCh, err := m.readTopic(ctx, "control")
+	if err != nil {
+		t.Fatalf("readTopic: %v", err)
+	}
+
+	select {
+	case <-bus.over:
+	case <-time.After(5 * time.Second):
+		t.Fatal("script not read")
+	}
+	cancel()
+
+	for range msgCh {
+	}
+	var errs int
+	for range errCh {
+		errs++
+	}
+	if errs != 6 {
+		t.Errorf("reported %d read errors, want 6", errs)
+	}
+
+	// Doubled up to MaxReadBackoff, and from ReadBackoff again after a
+	// message.
+	want := []time.Duration{30, 60, 120, 120, 0, 30, 60}
+	waits := bus.waits()
+	if len(waits) != len(want) {
+		t.Fatalf("waited %v, want %v ms", waits, want)
+	}
+
+	for i, wait := range waits {
+		want := want[i] * time.Millisecond
+		if wait < want || wait >= want+25*time.Millisecond {
+			t.Errorf("waited %s after fetch %d, want %s", wait, i, want)
+		}
+	}
+}
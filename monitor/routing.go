@@ -0,0 +1,221 @@
+package monitor
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// serviceToken is replaced by the originating service name in a route topic.
+const serviceToken = "{service}"
+
+// route sends events whose service name matches pattern to topic.
+type route struct {
+	pattern string
+	topic   string
+}
+
+// router picks the topic an event is published to based on its originating service.
+type router struct {
+	routes       []route
+	defaultTopic string
+}
+
+// parseRoutes parses a comma separated list of pattern=topic rules, e.g.
+// "billing-*=events.billing,producer_a=events.{service}". Patterns use
+// path.Match syntax and are evaluated in order; the first match wins.
+func parseRoutes(spec string, defaultTopic string) (*router, error) {
+	r := &router{defaultTopic: defaultTopic}
+
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		pattern, topic, ok := strings.Cut(rule, "=")
+		pattern, topic = strings.TrimSpace(pattern), strings.TrimSpace(topic)
+		if !ok || pattern == "" || topic == "" {
+			return nil, fmt.Errorf("invalid route %q, expected pattern=topic", rule)
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid route pattern %q: %w", pattern, err)
+		}
+
+		r.routes = append(r.routes, route{pattern: pattern, topic: topic})
+	}
+
+	return r, nil
+}
+
+// topicFor returns the topic for events originating from serviceName.
+func (r *router) topicFor(serviceName string) string {
+	if serviceName == "" {
+		return r.defaultTopic
+	}
+
+	for _, rt := range r.routes {
+		if ok, _ := path.Match(rt.pattern, serviceName); ok {
+			return strings.ReplaceAll(rt.topic, serviceToken, serviceName)
+		}
+	}
+
+	return r.defaultTopic
+}
+
+// origin describes the data request an in-flight execution started from.
+type origin struct {
+	serviceName string
+	requestedAt time.Time
+	contentType string
+	payload     json.RawMessage
+	attributes  map[string]string
+	payloadRef  string
+}
+
+// size approximates the bytes o holds.
+func (o origin) size() int64 {
+	n := len(o.serviceName) + len(o.contentType) + len(o.payload) + len(o.payloadRef)
+	for k, v := range o.attributes {
+		n += len(k) + len(v)
+	}
+
+	return int64(n)
+}
+
+func originOf(data shared.DataRequest) origin {
+	return origin{
+		serviceName: data.ServiceName,
+		requestedAt: data.Timestamp,
+		contentType: data.ContentType,
+		payload:     data.Payload,
+		attributes:  data.Attributes,
+		payloadRef:  data.PayloadRef,
+	}
+}
+
+// originCache remembers the origin of each in-flight request, keyed by
+// correlation ID, between the control and commit/cancel messages. Origins
+// are dropped after a TTL, for requests never committed or cancelled, and
+// the oldest ones once the cache holds its maximum number of origins or of
+// bytes, as origins carry their payloads.
+type originCache struct {
+	ttl      time.Duration
+	max      int
+	maxBytes int64
+	onDrop   func(correlationID string, expired bool)
+	mu       sync.Mutex
+	origins  map[string]*list.Element
+	// order holds the cached entries, oldest first.
+	order *list.List
+	bytes int64
+}
+
+type cachedOrigin struct {
+	correlationID string
+	origin        origin
+	size          int64
+	cachedAt      time.Time
+}
+
+func newOriginCache(ttl time.Duration, max int, maxBytes int64, onDrop func(correlationID string, expired bool)) *originCache {
+	return &originCache{
+		ttl:      ttl,
+		max:      max,
+		maxBytes: maxBytes,
+		onDrop:   onDrop,
+		origins:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *originCache) put(correlationID string, o origin) {
+	if correlationID == "" {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	if el, ok := c.origins[correlationID]; ok {
+		c.remove(el)
+	}
+
+	entry := &cachedOrigin{correlationID: correlationID, origin: o, size: o.size(), cachedAt: now}
+	c.origins[correlationID] = c.order.PushBack(entry)
+	c.bytes += entry.size
+
+	c.sweep(now)
+}
+
+// get returns the origin of correlationID.
+func (c *originCache) get(correlationID string) (origin, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.origins[correlationID]
+	if !ok {
+		return origin{}, false
+	}
+
+	return el.Value.(*cachedOrigin).origin, true
+}
+
+// take returns and forgets the origin of correlationID.
+func (c *originCache) take(correlationID string) (origin, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.origins[correlationID]
+	if !ok {
+		return origin{}, false
+	}
+
+	c.remove(el)
+
+	return el.Value.(*cachedOrigin).origin, true
+}
+
+// remove forgets a cached entry. c.mu must be held.
+func (c *originCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*cachedOrigin)
+	delete(c.origins, entry.correlationID)
+	c.bytes -= entry.size
+}
+
+// len returns the number of cached origins.
+func (c *originCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.origins)
+}
+
+// sweep drops the origins older than the TTL, then the oldest ones over the
+// maximums. c.mu must be held.
+func (c *originCache) sweep(now time.Time) {
+	for el := c.order.Front(); el != nil; el = c.order.Front() {
+		entry := el.Value.(*cachedOrigin)
+
+		expired := now.Sub(entry.cachedAt) > c.ttl
+		full := (c.max > 0 && c.order.Len() > c.max) || (c.maxBytes > 0 && c.bytes > c.maxBytes)
+		if !expired && !full {
+			return
+		}
+
+		c.remove(el)
+
+		if c.onDrop != nil {
+			c.onDrop(entry.correlationID, expired)
+		}
+	}
+}
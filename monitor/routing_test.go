@@ -0,0 +1,88 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOriginCacheExpires(t *testing.T) {
+	var dropped []string
+	cache := newOriginCache(20*time.Millisecond, 0, 0, func(correlationID string, expired bool) {
+		if !expired {
+			t.Errorf("%s dropped without expiring", correlationID)
+		}
+		dropped = append(dropped, correlationID)
+	})
+
+	cache.put("old", origin{serviceName: "a"})
+	time.Sleep(30 * time.Millisecond)
+	cache.put("new", origin{serviceName: "b"})
+
+	if _, ok := cache.take("old"); ok {
+		t.Error("expired origin still cached")
+	}
+	if o, ok := cache.take("new"); !ok || o.serviceName != "b" {
+		t.Errorf("take(new) = %+v, %v", o, ok)
+	}
+	if len(dropped) != 1 || dropped[0] != "old" {
+		t.Errorf("dropped %v, want [old]", dropped)
+	}
+}
+
+func TestOriginCacheBounded(t *testing.T) {
+	var dropped []string
+	cache := newOriginCache(time.Hour, 2, 0, func(correlationID string, expired bool) {
+		dropped = append(dropped, correlationID)
+	})
+
+	cache.put("a", origin{})
+	cache.put("b", origin{})
+	// Putting a again makes it the newest.
+	cache.put("a", origin{})
+	cache.put("c", origin{})
+
+	if n := cache.len(); n != 2 {
+		t.Errorf("len = %d, want 2", n)
+	}
+	if len(dropped) != 1 || dropped[0] != "b" {
+		t.Errorf("dropped %v, want [b]", dropped)
+	}
+	if _, ok := cache.take("a"); !ok {
+		t.Error("a was dropped")
+	}
+}
+
+func TestOriginCacheBoundedByBytes(t *testing.T) {
+	var dropped []string
+	cache := newOriginCache(time.Hour, 0, 100, func(correlationID string, expired bool) {
+		dropped = append(dropped, correlationID)
+	})
+
+	payload := func(n int) origin { return origin{payload: make([]byte, n)} }
+
+	cache.put("a", payload(40))
+	cache.put("b", payload(40))
+	// Replacing b doesn't count its old payload.
+	cache.put("b", payload(50))
+	if len(dropped) != 0 {
+		t.Fatalf("dropped %v under the limit", dropped)
+	}
+
+	cache.put("c", payload(30))
+	if len(dropped) != 1 || dropped[0] != "a" {
+		t.Fatalf("dropped %v, want [a]", dropped)
+	}
+
+	// Taken origins free their bytes.
+	cache.take("b")
+	cache.put("d", payload(60))
+	if len(dropped) != 1 || cache.len() != 2 {
+		t.Fatalf("dropped %v with %d cached, want only a dropped", dropped, cache.len())
+	}
+
+	// An origin over the limit on its own isn't kept.
+	cache.put("e", payload(200))
+	if _, ok := cache.get("e"); ok || cache.len() != 0 {
+		t.Fatalf("kept %d origins, want none", cache.len())
+	}
+}
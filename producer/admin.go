@@ -0,0 +1,282 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Counters are the totals of a producer since it started.
+type Counters struct {
+	Sent              int64 `json:"sent"`
+	SendFailures      int64 `json:"send_failures"`
+	Spooled           int64 `json:"spooled"`
+	Callbacks         int64 `json:"callbacks"`
+	RejectedCallbacks int64 `json:"rejected_callbacks"`
+	InjectedFailures  int64 `json:"injected_failures"`
+	Commits           int64 `json:"commits"`
+	Cancels           int64 `json:"cancels"`
+	DecisionFailures  int64 `json:"decision_failures"`
+}
+
+type counters struct {
+	sent              atomic.Int64
+	sendFailures      atomic.Int64
+	spooled           atomic.Int64
+	callbacks         atomic.Int64
+	rejectedCallbacks atomic.Int64
+	injectedFailures  atomic.Int64
+	commits           atomic.Int64
+	cancels           atomic.Int64
+	decisionFailures  atomic.Int64
+}
+
+func (c *counters) snapshot() Counters {
+	return Counters{
+		Sent:              c.sent.Load(),
+		SendFailures:      c.sendFailures.Load(),
+		Spooled:           c.spooled.Load(),
+		Callbacks:         c.callbacks.Load(),
+		RejectedCallbacks: c.rejectedCallbacks.Load(),
+		InjectedFailures:  c.injectedFailures.Load(),
+		Commits:           c.commits.Load(),
+		Cancels:           c.cancels.Load(),
+		DecisionFailures:  c.decisionFailures.Load(),
+	}
+}
+
+// Status is the runtime state of a producer.
+type Status struct {
+	ServiceName string `json:"service_name"`
+	Paused      bool   `json:"paused"`
+	// Interval is the current interval between data requests.
+	Interval    string   `json:"interval"`
+	Scenario    string   `json:"scenario"`
+	Phase       string   `json:"phase"`
+	Outstanding int      `json:"outstanding"`
+	Pending     int      `json:"pending"`
+	Counters    Counters `json:"counters"`
+}
+
+// Pause stops Run from sending data requests until Resume. Callbacks are
+// still answered.
+func (p *Producer) Pause() {
+	p.paused.Store(true)
+	p.customLogger.Log("admin", "Paused", nil, "", "")
+}
+
+// Resume undoes Pause.
+func (p *Producer) Resume() {
+	p.paused.Store(false)
+	p.customLogger.Log("admin", "Resumed", nil, "", "")
+}
+
+// SetInterval overrides the interval between data requests, including the
+// scenario's. Zero goes back to the configured interval.
+func (p *Producer) SetInterval(interval time.Duration) {
+	p.intervalOverride.Store(int64(interval))
+
+	// Wake Run up so the new interval applies at once.
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+
+	p.customLogger.Log("admin", fmt.Sprintf("Interval set to %s", p.interval()), nil, "", "")
+}
+
+// interval returns the current interval between data requests.
+func (p *Producer) interval() time.Duration {
+	if override := time.Duration(p.intervalOverride.Load()); override > 0 {
+		return override
+	}
+
+	phase, _ := p.phase()
+	if phase.Interval > 0 {
+		return time.Duration(phase.Interval)
+	}
+
+	return p.cfg.Interval
+}
+
+// FireRequest overrides the fields of a data request sent on demand.
+type FireRequest struct {
+	ExecutionID string            `json:"execution_id"`
+	UserID      string            `json:"user_id"`
+	Timestamp   time.Time         `json:"timestamp"`
+	ContentType string            `json:"content_type"`
+	Payload     json.RawMessage   `json:"payload"`
+	Attributes  map[string]string `json:"attributes"`
+}
+
+// Fire sends one data request on demand, whether paused or not, and returns
+// the forwarder's answer.
+func (p *Producer) Fire(ctx context.Context, req FireRequest) (string, string, error) {
+	executionID := req.ExecutionID
+	if executionID == "" {
+		id, _ := uuid.NewUUID()
+		executionID = id.String()
+	}
+
+	data := p.createDataRequest(executionID)
+	if req.UserID != "" {
+		data.UserID = req.UserID
+	}
+	if !req.Timestamp.IsZero() {
+		data.Timestamp = req.Timestamp
+	}
+	if req.Payload != nil {
+		data.ContentType, data.Payload = req.ContentType, req.Payload
+	}
+	if req.Attributes != nil {
+		data.Attributes = req.Attributes
+	}
+
+	receipt, err := p.sendData(ctx, data)
+
+	return executionID, receipt.CorrelationID, err
+}
+
+// Status returns the producer's runtime state and counters.
+func (p *Producer) Status() Status {
+	phase, _ := p.phase()
+
+	return Status{
+		ServiceName: p.cfg.ServiceName,
+		Paused:      p.paused.Load(),
+		Interval:    p.interval().String(),
+		Scenario:    p.cfg.Scenario.Name,
+		Phase:       phase.Name,
+		Outstanding: len(p.outstanding.list()),
+		Pending:     len(p.pending.list()),
+		Counters:    p.counters.snapshot(),
+	}
+}
+
+// adminHandler serves:
+//
+//	GET  /admin/status    runtime state and counters
+//	POST /admin/pause     stop sending data requests
+//	POST /admin/resume    send data requests again
+//	POST /admin/rate      {"interval": "500ms"} or {"rps": 2}, {} to reset
+//	POST /admin/fire      send one data request, optionally with
+//	                      {"execution_id", "user_id", "timestamp",
+//	                      "content_type", "payload", "attributes"}
+//	GET  /admin/loglevel  the log level, PUT {"level": "debug"} to change it
+func (p *Producer) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/admin/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, p.Status())
+	})
+
+	mux.HandleFunc("/admin/pause", p.adminAction(p.Pause))
+	mux.HandleFunc("/admin/resume", p.adminAction(p.Resume))
+	mux.HandleFunc("/admin/rate", p.setRate)
+	mux.HandleFunc("/admin/fire", p.fire)
+	mux.Handle("/admin/loglevel", p.customLogger.LevelHandler())
+
+	return mux
+}
+
+func (p *Producer) adminAction(action func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		action()
+		writeJSON(w, http.StatusOK, p.Status())
+	}
+}
+
+func (p *Producer) setRate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var rate struct {
+		Interval string  `json:"interval"`
+		RPS      float64 `json:"rps"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&rate); err != nil {
+		http.Error(w, fmt.Sprintf("invalid rate: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var interval time.Duration
+	switch {
+	case rate.Interval != "" && rate.RPS != 0:
+		http.Error(w, "set either interval or rps", http.StatusBadRequest)
+		return
+	case rate.Interval != "":
+		var err error
+		if interval, err = time.ParseDuration(rate.Interval); err != nil || interval <= 0 {
+			http.Error(w, fmt.Sprintf("invalid interval %q", rate.Interval), http.StatusBadRequest)
+			return
+		}
+	case rate.RPS < 0:
+		http.Error(w, "rps must be positive", http.StatusBadRequest)
+		return
+	case rate.RPS > 0:
+		interval = time.Duration(float64(time.Second) / rate.RPS)
+	}
+
+	p.SetInterval(interval)
+	writeJSON(w, http.StatusOK, p.Status())
+}
+
+func (p *Producer) fire(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FireRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	executionID, correlationID, err := p.Fire(r.Context(), req)
+
+	res := map[string]interface{}{
+		"execution_id":   executionID,
+		"correlation_id": correlationID,
+	}
+
+	switch {
+	case errors.Is(err, ErrSpooled):
+		res["status"] = "spooled"
+		writeJSON(w, http.StatusAccepted, res)
+	case err != nil:
+		res["status"] = "failed"
+		res["error"] = err.Error()
+		writeJSON(w, http.StatusBadGateway, res)
+	default:
+		res["status"] = "sent"
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,187 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminCall sends body to path on the producer's server and decodes the JSON
+// answer into out, returning the status code.
+func adminCall(t *testing.T, server *httptest.Server, method, path, body string, out interface{}) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+
+	if out != nil && res.Header.Get("Content-Type") == "application/json" {
+		if err := json.NewDecoder(res.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding answer: %v", method, path, err)
+		}
+	}
+
+	return res.StatusCode
+}
+
+func TestAdminRate(t *testing.T) {
+	p := newTestProducer(t, Config{Interval: time.Second})
+	server := httptest.NewServer(p.Handler())
+	t.Cleanup(server.Close)
+
+	tests := []struct {
+		body         string
+		wantCode     int
+		wantInterval string
+	}{
+		{`{"interval": "250ms"}`, http.StatusOK, "250ms"},
+		{`{"rps": 2}`, http.StatusOK, "500ms"},
+		{`{}`, http.StatusOK, "1s"},
+		{`{"interval": "250ms", "rps": 2}`, http.StatusBadRequest, "1s"},
+		{`{"interval": "-1s"}`, http.StatusBadRequest, "1s"},
+		{`{"interval": "soon"}`, http.StatusBadRequest, "1s"},
+		{`{"rps": -1}`, http.StatusBadRequest, "1s"},
+		{`{`, http.StatusBadRequest, "1s"},
+	}
+
+	for _, tt := range tests {
+		if code := adminCall(t, server, http.MethodPost, "/admin/rate", tt.body, nil); code != tt.wantCode {
+			t.Errorf("POST /admin/rate %s = %d, want %d", tt.body, code, tt.wantCode)
+		}
+
+		if interval := p.Status().Interval; interval != tt.wantInterval {
+			t.Errorf("after POST /admin/rate %s interval %s, want %s", tt.body, interval, tt.wantInterval)
+		}
+	}
+
+	if code := adminCall(t, server, http.MethodGet, "/admin/rate", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /admin/rate = %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAdminPauseStopsRequests(t *testing.T) {
+	forwarder := newFakeForwarder(t)
+	p := newTestProducer(t, Config{ForwarderURL: forwarder.URL, Interval: 10 * time.Millisecond})
+	server := httptest.NewServer(p.Handler())
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); p.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	var status Status
+	if code := adminCall(t, server, http.MethodPost, "/admin/pause", "", &status); code != http.StatusOK || !status.Paused {
+		t.Fatalf("POST /admin/pause = %d %+v, want paused", code, status)
+	}
+
+	// A request may have been in flight when pausing.
+	time.Sleep(20 * time.Millisecond)
+	sent := p.Status().Counters.Sent
+
+	time.Sleep(100 * time.Millisecond)
+	if got := p.Status().Counters.Sent; got != sent {
+		t.Fatalf("sent %d requests while paused", got-sent)
+	}
+
+	if code := adminCall(t, server, http.MethodPost, "/admin/resume", "", &status); code != http.StatusOK || status.Paused {
+		t.Fatalf("POST /admin/resume = %d %+v, want resumed", code, status)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); p.Status().Counters.Sent == sent; time.Sleep(5 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("no request sent after resuming")
+		}
+	}
+
+	if code := adminCall(t, server, http.MethodGet, "/admin/pause", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /admin/pause = %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestAdminFire(t *testing.T) {
+	forwarder := newFakeForwarder(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(down.Close)
+
+	tests := []struct {
+		name       string
+		cfg        Config
+		body       string
+		wantCode   int
+		wantStatus string
+	}{
+		{"sent", Config{ForwarderURL: forwarder.URL}, `{"execution_id": "exec-1", "user_id": "42"}`, http.StatusOK, "sent"},
+		{"no body", Config{ForwarderURL: forwarder.URL}, ``, http.StatusOK, "sent"},
+		{"invalid", Config{ForwarderURL: forwarder.URL}, `{`, http.StatusBadRequest, ""},
+		{"spooled", Config{ForwarderURL: down.URL, SpoolDir: t.TempDir()}, `{"execution_id": "exec-1"}`, http.StatusAccepted, "spooled"},
+		{"failed", Config{ForwarderURL: down.URL}, `{"execution_id": "exec-1"}`, http.StatusBadGateway, "failed"},
+	}
+
+	for _, tt := range tests {
+		p := newTestProducer(t, tt.cfg)
+		server := httptest.NewServer(p.Handler())
+
+		var res struct {
+			Status        string `json:"status"`
+			ExecutionID   string `json:"execution_id"`
+			CorrelationID string `json:"correlation_id"`
+		}
+		code := adminCall(t, server, http.MethodPost, "/admin/fire", tt.body, &res)
+		server.Close()
+
+		if code != tt.wantCode || res.Status != tt.wantStatus {
+			t.Errorf("%s: POST /admin/fire = %d %+v, want %d %s", tt.name, code, res, tt.wantCode, tt.wantStatus)
+			continue
+		}
+
+		if tt.wantStatus == "sent" && (res.ExecutionID == "" || res.CorrelationID != "corr-"+res.ExecutionID) {
+			t.Errorf("%s: fired %+v, want the forwarder's correlation ID", tt.name, res)
+		}
+
+		// Fired requests are tracked like the scheduled ones.
+		if tt.wantStatus == "sent" || tt.wantStatus == "spooled" {
+			if outstanding := p.Outstanding(); len(outstanding) != 1 || outstanding[0].ExecutionID != res.ExecutionID {
+				t.Errorf("%s: outstanding %+v, want %s", tt.name, outstanding, res.ExecutionID)
+			}
+		}
+	}
+}
+
+func TestAdminStatusAndLogLevel(t *testing.T) {
+	p := newTestProducer(t, Config{ServiceName: "billing"})
+	server := httptest.NewServer(p.Handler())
+	t.Cleanup(server.Close)
+
+	var status Status
+	if code := adminCall(t, server, http.MethodGet, "/admin/status", "", &status); code != http.StatusOK || status.ServiceName != "billing" || status.Scenario != "default" {
+		t.Errorf("GET /admin/status = %d %+v", code, status)
+	}
+
+	if code := adminCall(t, server, http.MethodPost, "/admin/status", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST /admin/status = %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+
+	var level struct {
+		Level string `json:"level"`
+	}
+	if code := adminCall(t, server, http.MethodPut, "/admin/loglevel", `{"level": "debug"}`, &level); code != http.StatusOK || level.Level != "debug" {
+		t.Errorf("PUT /admin/loglevel = %d %+v, want debug", code, level)
+	}
+}
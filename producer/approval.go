@@ -0,0 +1,165 @@
+package producer
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+)
+
+// Pending is a decision parked until an operator approves or rejects it.
+type Pending struct {
+	ExecutionID   string    `json:"execution_id"`
+	CorrelationID string    `json:"correlation_id"`
+	CalledBackAt  time.Time `json:"called_back_at"`
+}
+
+type pendingDecision struct {
+	Pending
+	decide func(commit bool) error
+	// resolving is set while the decision is being sent, so two operators
+	// can't decide the same execution at once.
+	resolving bool
+}
+
+type pendingQueue struct {
+	mu        sync.Mutex
+	decisions map[string]*pendingDecision
+}
+
+func newPendingQueue() *pendingQueue {
+	return &pendingQueue{decisions: make(map[string]*pendingDecision)}
+}
+
+func (q *pendingQueue) park(p Pending, decide func(commit bool) error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.decisions[p.ExecutionID] = &pendingDecision{Pending: p, decide: decide}
+}
+
+func (q *pendingQueue) list() []Pending {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending := make([]Pending, 0, len(q.decisions))
+	for _, d := range q.decisions {
+		pending = append(pending, d.Pending)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].CalledBackAt.Before(pending[j].CalledBackAt)
+	})
+
+	return pending
+}
+
+// resolve sends the decision of executionID. It stays pending if the
+// forwarder doesn't accept it.
+func (q *pendingQueue) resolve(executionID string, commit bool) error {
+	q.mu.Lock()
+	d, ok := q.decisions[executionID]
+	if !ok {
+		q.mu.Unlock()
+		return ErrUnknownRequest
+	}
+	if d.resolving {
+		q.mu.Unlock()
+		return ErrAlreadyDecided
+	}
+	d.resolving = true
+	q.mu.Unlock()
+
+	err := d.decide(commit)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err != nil {
+		d.resolving = false
+		return err
+	}
+
+	delete(q.decisions, executionID)
+
+	return nil
+}
+
+// Pending returns the decisions awaiting approval, oldest first.
+func (p *Producer) Pending() []Pending {
+	return p.pending.list()
+}
+
+// Approve commits a pending execution.
+func (p *Producer) Approve(executionID string) error {
+	return p.resolve(executionID, true)
+}
+
+// Reject cancels a pending execution.
+func (p *Producer) Reject(executionID string) error {
+	return p.resolve(executionID, false)
+}
+
+func (p *Producer) resolve(executionID string, commit bool) error {
+	if err := p.pending.resolve(executionID, commit); err != nil {
+		return err
+	}
+
+	p.outstanding.decide(executionID)
+
+	return nil
+}
+
+func (p *Producer) getPending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	pending := p.Pending()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total": len(pending),
+		"data":  pending,
+	})
+}
+
+// resolvePending serves POST /pending/{execution_id}/approve and
+// POST /pending/{execution_id}/reject.
+func (p *Producer) resolvePending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	executionID, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/pending/"), "/")
+	ctx := logger.WithIDs(r.Context(), logger.IDs{ExecutionID: executionID})
+
+	var err error
+	var outcome string
+	switch action {
+	case "approve":
+		err, outcome = p.Approve(executionID), "approved"
+	case "reject":
+		err, outcome = p.Reject(executionID), "rejected"
+	default:
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusNotFound)
+		return
+	}
+
+	switch err {
+	case nil:
+		p.customLogger.LogContext(ctx, "approval", fmt.Sprintf("Execution %s by operator", outcome), nil)
+		w.WriteHeader(http.StatusOK)
+	case ErrUnknownRequest:
+		http.Error(w, "execution not pending", http.StatusNotFound)
+	case ErrAlreadyDecided:
+		http.Error(w, "execution being decided", http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	}
+}
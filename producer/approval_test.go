@@ -0,0 +1,148 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPendingQueueResolve(t *testing.T) {
+	q := newPendingQueue()
+
+	errRejected := errors.New("rejected")
+	var decideErr error
+	var decided []bool
+	q.park(Pending{ExecutionID: "exec-1", CalledBackAt: time.Now()}, func(commit bool) error {
+		decided = append(decided, commit)
+		return decideErr
+	})
+
+	if err := q.resolve("exec-2", true); err != ErrUnknownRequest {
+		t.Fatalf("resolve() of an unknown execution = %v, want %v", err, ErrUnknownRequest)
+	}
+
+	// A decision the forwarder doesn't accept stays pending.
+	decideErr = errRejected
+	if err := q.resolve("exec-1", true); err != errRejected {
+		t.Fatalf("resolve() = %v, want %v", err, errRejected)
+	}
+	if pending := q.list(); len(pending) != 1 {
+		t.Fatalf("pending %+v after a failed decision, want exec-1", pending)
+	}
+
+	decideErr = nil
+	if err := q.resolve("exec-1", false); err != nil {
+		t.Fatalf("resolve() = %v", err)
+	}
+	if pending := q.list(); len(pending) != 0 {
+		t.Fatalf("pending %+v after the decision, want none", pending)
+	}
+	if err := q.resolve("exec-1", true); err != ErrUnknownRequest {
+		t.Fatalf("resolve() of a decided execution = %v, want %v", err, ErrUnknownRequest)
+	}
+
+	if len(decided) != 2 || !decided[0] || decided[1] {
+		t.Fatalf("decided %v, want [true false]", decided)
+	}
+}
+
+func TestPendingQueueResolvesOnce(t *testing.T) {
+	q := newPendingQueue()
+
+	sending, release := make(chan struct{}), make(chan struct{})
+	q.park(Pending{ExecutionID: "exec-1"}, func(commit bool) error {
+		close(sending)
+		<-release
+		return nil
+	})
+
+	done := make(chan error)
+	go func() { done <- q.resolve("exec-1", true) }()
+	<-sending
+
+	// Another operator deciding while the decision is being sent.
+	if err := q.resolve("exec-1", false); err != ErrAlreadyDecided {
+		t.Fatalf("concurrent resolve() = %v, want %v", err, ErrAlreadyDecided)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("resolve() = %v", err)
+	}
+}
+
+func TestManualApproval(t *testing.T) {
+	forwarder := newFakeForwarder(t)
+	p := newTestProducer(t, Config{ForwarderURL: forwarder.URL, ManualApproval: true})
+	handler := p.Handler()
+
+	for _, executionID := range []string{"exec-1", "exec-2"} {
+		if _, _, err := p.Fire(context.Background(), FireRequest{ExecutionID: executionID}); err != nil {
+			t.Fatalf("Fire: %v", err)
+		}
+
+		if got := callBack(handler, "/callback", "corr-"+executionID, executionID); got != http.StatusAccepted {
+			t.Fatalf("callback for %s answered %d, want %d", executionID, got, http.StatusAccepted)
+		}
+	}
+
+	if got := callBack(handler, "/callback", "corr-exec-1", "exec-1"); got != http.StatusConflict {
+		t.Fatalf("repeated callback answered %d, want %d", got, http.StatusConflict)
+	}
+
+	var listed struct {
+		Total int       `json:"total"`
+		Data  []Pending `json:"data"`
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/pending", nil))
+	json.NewDecoder(rec.Body).Decode(&listed)
+
+	if listed.Total != 2 || listed.Data[0].ExecutionID != "exec-1" || listed.Data[0].CorrelationID != "corr-exec-1" {
+		t.Fatalf("GET /pending = %+v, want exec-1 and exec-2", listed)
+	}
+
+	resolve := func(method, path string) int {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code
+	}
+
+	steps := []struct {
+		name   string
+		before func()
+		method string
+		path   string
+		want   int
+	}{
+		{"approve", nil, http.MethodPost, "/pending/exec-1/approve", http.StatusOK},
+		{"approve again", nil, http.MethodPost, "/pending/exec-1/approve", http.StatusNotFound},
+		{"unknown action", nil, http.MethodPost, "/pending/exec-2/hold", http.StatusNotFound},
+		{"wrong method", nil, http.MethodGet, "/pending/exec-2/reject", http.StatusMethodNotAllowed},
+		{"forwarder rejects", func() { forwarder.rejectDecisions(http.StatusBadRequest) }, http.MethodPost, "/pending/exec-2/reject", http.StatusBadGateway},
+		{"reject", func() { forwarder.rejectDecisions(0) }, http.MethodPost, "/pending/exec-2/reject", http.StatusOK},
+	}
+
+	for _, step := range steps {
+		if step.before != nil {
+			step.before()
+		}
+
+		if got := resolve(step.method, step.path); got != step.want {
+			t.Errorf("%s: %s %s answered %d, want %d", step.name, step.method, step.path, got, step.want)
+		}
+	}
+
+	decisions := forwarder.received()
+	if len(decisions) != 2 || !decisions[0].Commit || decisions[0].ExecutionID != "exec-1" || decisions[1].Commit || decisions[1].ExecutionID != "exec-2" {
+		t.Fatalf("forwarder received %+v, want exec-1 committed and exec-2 cancelled", decisions)
+	}
+
+	if pending, outstanding := p.Pending(), p.Outstanding(); len(pending) != 0 || len(outstanding) != 0 {
+		t.Fatalf("pending %+v and outstanding %+v, want none once decided", pending, outstanding)
+	}
+}
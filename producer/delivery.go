@@ -0,0 +1,129 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/client"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// ErrSpooled is returned, wrapping the delivery error, for a request that
+// could not be delivered and was spooled to be replayed later.
+var ErrSpooled = errors.New("request spooled")
+
+// spoolFailed spools v, a request to path that failed with err after every
+// attempt, when a spool is configured and the forwarder may take it later.
+func (p *Producer) spoolFailed(ctx context.Context, path string, v interface{}, err error) error {
+	if err == nil || !client.Retryable(err) || p.spool == nil {
+		return err
+	}
+
+	body, marshalErr := json.Marshal(v)
+	if marshalErr != nil {
+		return err
+	}
+
+	var correlationID, executionID string
+	switch r := v.(type) {
+	case shared.DataRequest:
+		executionID = r.ExecutionID
+	case shared.CommitRequest:
+		correlationID, executionID = r.CorrelationID, r.ExecutionID
+	}
+
+	dropped, spoolErr := p.spool.add(spoolEntry{
+		Path:          path,
+		Body:          body,
+		CorrelationID: correlationID,
+		ExecutionID:   executionID,
+		SpooledAt:     time.Now(),
+	})
+
+	for _, name := range dropped {
+		p.customLogger.LogContext(ctx, "error", fmt.Sprintf("Spool full, dropped %s", name), nil)
+	}
+
+	if spoolErr != nil {
+		p.customLogger.LogContext(ctx, "error", fmt.Sprintf("error spooling request to %s: %v", path, spoolErr), spoolErr)
+		return err
+	}
+
+	p.customLogger.LogContext(ctx, "forwarder", fmt.Sprintf("Spooled request to %s: %v", path, err), err)
+
+	return fmt.Errorf("%w: %v", ErrSpooled, err)
+}
+
+// replaySpool delivers the spooled requests, oldest first, every
+// SpoolReplayInterval until ctx is done. A round stops at the first request
+// the forwarder still can't take.
+func (p *Producer) replaySpool(ctx context.Context) {
+	ticker := time.NewTicker(p.cfg.SpoolReplayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for ctx.Err() == nil {
+			name, entry, ok, err := p.spool.oldest()
+			if err != nil {
+				p.customLogger.LogContext(ctx, "error", fmt.Sprintf("error reading spool: %v", err), err)
+				break
+			}
+
+			if !ok {
+				break
+			}
+
+			var dataRes shared.DataResponse
+			var out interface{}
+			if entry.Path == "/request" {
+				out = &dataRes
+			}
+
+			entryCtx := logger.WithIDs(ctx, logger.IDs{
+				CorrelationID: entry.CorrelationID,
+				ExecutionID:   entry.ExecutionID,
+				TraceID:       logger.NewTraceID(),
+			})
+
+			// The request may have expired while spooled, and its callback
+			// can arrive before the forwarder's response.
+			if entry.Path == "/request" {
+				p.outstanding.resend(entry.ExecutionID)
+			}
+
+			err = p.api.PostOnce(entryCtx, entry.Path, entry.Body, out)
+			if err != nil && client.Retryable(err) {
+				break
+			}
+
+			if err != nil {
+				p.customLogger.LogContext(entryCtx, "error", fmt.Sprintf("Dropped spooled request to %s: %v", entry.Path, err), err)
+
+				if entry.Path == "/request" {
+					p.outstanding.forget(entry.ExecutionID)
+				}
+			} else {
+				p.customLogger.LogContext(entryCtx, "forwarder", fmt.Sprintf("Replayed spooled request to %s after %s", entry.Path, time.Since(entry.SpooledAt).Round(time.Millisecond)), nil)
+
+				if entry.Path == "/request" {
+					p.outstanding.correlate(entry.ExecutionID, dataRes.CorrelationID)
+				}
+			}
+
+			if err := p.spool.remove(name); err != nil {
+				p.customLogger.LogContext(entryCtx, "error", fmt.Sprintf("error removing spooled request %s: %v", name, err), err)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// Persona is a virtual service hosted by a Fleet.
+//
+//	personas:
+//	  - name: payments
+//	    interval: 500ms
+//	    scenario_file: scenarios/flaky-callbacks.yaml
+//	  - name: billing
+//	    replicas: 20
+//	    interval: 5s
+//	    scenario:
+//	      phases:
+//	        - commit_ratio: 0.2
+type Persona struct {
+	// Name routes the persona's callbacks, under /{name}/callback.
+	Name string `yaml:"name"`
+	// ServiceName identifies the persona in its data requests, Name by
+	// default.
+	ServiceName string   `yaml:"service_name"`
+	Interval    Duration `yaml:"interval"`
+	// ScenarioFile is a scenario file, relative to the personas file.
+	ScenarioFile string `yaml:"scenario_file"`
+	// Scenario is an inline scenario, used when ScenarioFile is empty.
+	Scenario       *Scenario `yaml:"scenario"`
+	ManualApproval bool      `yaml:"manual_approval"`
+	// Replicas expands the persona into that many personas named
+	// {name}-01, {name}-02 and so on.
+	Replicas int `yaml:"replicas"`
+}
+
+// LoadPersonas reads the personas from a YAML file, expanding replicas and
+// loading their scenarios.
+func LoadPersonas(path string) ([]Persona, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Personas []Persona `yaml:"personas"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing personas %s: %w", path, err)
+	}
+
+	var personas []Persona
+	for _, persona := range file.Personas {
+		if persona.ScenarioFile != "" {
+			scenarioPath := persona.ScenarioFile
+			if !filepath.IsAbs(scenarioPath) {
+				scenarioPath = filepath.Join(filepath.Dir(path), scenarioPath)
+			}
+
+			persona.Scenario, err = LoadScenario(scenarioPath)
+			if err != nil {
+				return nil, fmt.Errorf("persona %s: %w", persona.Name, err)
+			}
+		} else if persona.Scenario != nil {
+			if persona.Scenario.Name == "" {
+				persona.Scenario.Name = persona.Name
+			}
+			if err := persona.Scenario.validate(); err != nil {
+				return nil, fmt.Errorf("persona %s: scenario: %w", persona.Name, err)
+			}
+		}
+
+		if persona.Replicas <= 1 {
+			personas = append(personas, persona)
+			continue
+		}
+
+		for i := 1; i <= persona.Replicas; i++ {
+			replica := persona
+			replica.Name = fmt.Sprintf("%s-%02d", persona.Name, i)
+			if persona.ServiceName != "" {
+				replica.ServiceName = fmt.Sprintf("%s-%02d", persona.ServiceName, i)
+			}
+			personas = append(personas, replica)
+		}
+	}
+
+	return personas, nil
+}
+
+// Fleet hosts many personas in one process, each a Producer with its own
+// service name, rate and behaviour, sharing one callback server.
+type Fleet struct {
+	names     []string
+	producers map[string]*Producer
+}
+
+// NewFleet creates a Producer per persona from base. CallbackURL in base is
+// the shared server's base URL, such as http://producer:8888, and SpoolDir,
+// when set, gets a subdirectory per persona. newLogger returns the logger of
+// each persona.
+func NewFleet(base Config, personas []Persona, newLogger func(name string) *logger.CustomLogger) (*Fleet, error) {
+	if len(personas) == 0 {
+		return nil, fmt.Errorf("no personas")
+	}
+
+	f := &Fleet{producers: make(map[string]*Producer)}
+
+	for _, persona := range personas {
+		if persona.Name == "" || strings.Contains(persona.Name, "/") || persona.Name == "personas" {
+			return nil, fmt.Errorf("invalid persona name %q", persona.Name)
+		}
+
+		if _, ok := f.producers[persona.Name]; ok {
+			return nil, fmt.Errorf("duplicate persona %q", persona.Name)
+		}
+
+		cfg := base
+		cfg.ServiceName = persona.ServiceName
+		if cfg.ServiceName == "" {
+			cfg.ServiceName = persona.Name
+		}
+		cfg.CallbackURL = strings.TrimSuffix(base.CallbackURL, "/") + "/" + persona.Name + "/callback"
+		if persona.Interval > 0 {
+			cfg.Interval = time.Duration(persona.Interval)
+		}
+		cfg.Scenario = persona.Scenario
+		cfg.ManualApproval = persona.ManualApproval
+		if base.SpoolDir != "" {
+			cfg.SpoolDir = filepath.Join(base.SpoolDir, persona.Name)
+		}
+
+		f.names = append(f.names, persona.Name)
+		f.producers[persona.Name] = New(cfg, newLogger(persona.Name))
+	}
+
+	return f, nil
+}
+
+// Producer returns the producer of the named persona.
+func (f *Fleet) Producer(name string) (*Producer, bool) {
+	p, ok := f.producers[name]
+	return p, ok
+}
+
+// Handler routes /{name}/... to the routes of each persona's producer, and
+// lists the personas at /personas.
+func (f *Fleet) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	for _, name := range f.names {
+		prefix := "/" + name
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, f.producers[name].Handler()))
+	}
+
+	mux.HandleFunc("/personas", f.getPersonas)
+
+	return mux
+}
+
+func (f *Fleet) getPersonas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	type persona struct {
+		Name        string `json:"name"`
+		ServiceName string `json:"service_name"`
+		CallbackURL string `json:"callback_url"`
+		Scenario    string `json:"scenario"`
+	}
+
+	personas := make([]persona, 0, len(f.names))
+	for _, name := range f.names {
+		cfg := f.producers[name].cfg
+		personas = append(personas, persona{
+			Name:        name,
+			ServiceName: cfg.ServiceName,
+			CallbackURL: cfg.CallbackURL,
+			Scenario:    cfg.Scenario.Name,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": personas})
+}
+
+// Run runs every persona until ctx is done or all their scenarios are over.
+func (f *Fleet) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+
+	for _, name := range f.names {
+		wg.Add(1)
+		go func(p *Producer) {
+			defer wg.Done()
+			p.Run(ctx)
+		}(f.producers[name])
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,183 @@
+package producer
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadPersonas(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "slow.yaml"), `
+name: slow
+phases:
+  - decision_delay: {distribution: constant, value: 1s}
+`)
+	writeFile(t, filepath.Join(dir, "personas.yaml"), `
+personas:
+  - name: payments
+    interval: 500ms
+    scenario_file: slow.yaml
+  - name: billing
+    service_name: billing-svc
+    replicas: 2
+    manual_approval: true
+    scenario:
+      phases:
+        - commit_ratio: 0.2
+`)
+
+	personas, err := LoadPersonas(filepath.Join(dir, "personas.yaml"))
+	if err != nil {
+		t.Fatalf("LoadPersonas: %v", err)
+	}
+
+	if len(personas) != 3 {
+		t.Fatalf("loaded %d personas, want payments and 2 billing replicas", len(personas))
+	}
+
+	payments := personas[0]
+	if payments.Name != "payments" || payments.Interval != Duration(500*time.Millisecond) || payments.Scenario == nil || payments.Scenario.Name != "slow" {
+		t.Errorf("payments = %+v, want the scenario file relative to the personas file", payments)
+	}
+
+	replicas := []struct{ name, serviceName string }{
+		{"billing-01", "billing-svc-01"},
+		{"billing-02", "billing-svc-02"},
+	}
+
+	for i, want := range replicas {
+		replica := personas[i+1]
+		if replica.Name != want.name || replica.ServiceName != want.serviceName || !replica.ManualApproval {
+			t.Errorf("replica %d = %+v, want %s", i, replica, want.name)
+		}
+
+		// Inline scenarios are named after the persona.
+		if replica.Scenario == nil || replica.Scenario.Name != "billing" {
+			t.Errorf("replica %s scenario = %+v, want billing", want.name, replica.Scenario)
+		}
+	}
+}
+
+func TestLoadPersonasValidatesScenarios(t *testing.T) {
+	dir := t.TempDir()
+
+	writeFile(t, filepath.Join(dir, "personas.yaml"), `
+personas:
+  - name: payments
+    scenario:
+      phases:
+        - commit_ratio: 2
+`)
+
+	if _, err := LoadPersonas(filepath.Join(dir, "personas.yaml")); err == nil {
+		t.Fatal("LoadPersonas accepted a commit_ratio of 2")
+	}
+}
+
+func TestNewFleetWiresPersonas(t *testing.T) {
+	spoolDir := t.TempDir()
+	inline := &Scenario{Name: "inline", Phases: []Phase{{Name: "only"}}}
+
+	var loggers []string
+	newLogger := func(name string) *logger.CustomLogger {
+		loggers = append(loggers, name)
+		customLogger := logger.New(name)
+		customLogger.SetOutput(io.Discard)
+		return customLogger
+	}
+
+	base := Config{ForwarderURL: "http://forwarder:8080", CallbackURL: "http://producer:8888/", Interval: time.Second, SpoolDir: spoolDir}
+	fleet, err := NewFleet(base, []Persona{
+		{Name: "payments", Interval: Duration(500 * time.Millisecond), Scenario: inline},
+		{Name: "billing", ServiceName: "billing-svc", ManualApproval: true},
+	}, newLogger)
+	if err != nil {
+		t.Fatalf("NewFleet: %v", err)
+	}
+
+	payments, ok := fleet.Producer("payments")
+	if !ok {
+		t.Fatal("no payments producer")
+	}
+	if cfg := payments.cfg; cfg.ServiceName != "payments" || cfg.CallbackURL != "http://producer:8888/payments/callback" || cfg.Interval != 500*time.Millisecond || cfg.Scenario != inline || cfg.ManualApproval || cfg.SpoolDir != filepath.Join(spoolDir, "payments") {
+		t.Errorf("payments config %+v", cfg)
+	}
+
+	billing, _ := fleet.Producer("billing")
+	if cfg := billing.cfg; cfg.ServiceName != "billing-svc" || cfg.Interval != time.Second || cfg.Scenario != defaultScenario || !cfg.ManualApproval || cfg.SpoolDir != filepath.Join(spoolDir, "billing") {
+		t.Errorf("billing config %+v", cfg)
+	}
+
+	if len(loggers) != 2 || loggers[0] != "payments" || loggers[1] != "billing" {
+		t.Errorf("loggers created for %v, want [payments billing]", loggers)
+	}
+
+	rec := httptest.NewRecorder()
+	fleet.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/personas", nil))
+
+	var listed struct {
+		Data []struct {
+			Name        string `json:"name"`
+			ServiceName string `json:"service_name"`
+			Scenario    string `json:"scenario"`
+		} `json:"data"`
+	}
+	json.NewDecoder(rec.Body).Decode(&listed)
+
+	if len(listed.Data) != 2 || listed.Data[0].Scenario != "inline" || listed.Data[1].ServiceName != "billing-svc" {
+		t.Errorf("GET /personas = %+v", listed)
+	}
+
+	// Each persona's routes are served under its name.
+	rec = httptest.NewRecorder()
+	fleet.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/billing/admin/status", nil))
+
+	var status Status
+	json.NewDecoder(rec.Body).Decode(&status)
+
+	if rec.Code != http.StatusOK || status.ServiceName != "billing-svc" {
+		t.Errorf("GET /billing/admin/status = %d %+v, want billing-svc", rec.Code, status)
+	}
+}
+
+func TestNewFleetRejectsInvalidPersonas(t *testing.T) {
+	newLogger := func(name string) *logger.CustomLogger {
+		customLogger := logger.New(name)
+		customLogger.SetOutput(io.Discard)
+		return customLogger
+	}
+
+	tests := []struct {
+		name     string
+		personas []Persona
+	}{
+		{"none", nil},
+		{"empty name", []Persona{{}}},
+		{"slash", []Persona{{Name: "a/b"}}},
+		{"reserved", []Persona{{Name: "personas"}}},
+		{"duplicate", []Persona{{Name: "payments"}, {Name: "payments"}}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewFleet(Config{}, tt.personas, newLogger); err == nil {
+			t.Errorf("%s: NewFleet accepted %+v", tt.name, tt.personas)
+		}
+	}
+}
@@ -0,0 +1,480 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/client"
+	"github.com/google/uuid"
+)
+
+// Load profiles.
+const (
+	// ProfileConstant sends RPS requests per second for the whole run.
+	ProfileConstant = "constant"
+	// ProfileRamp grows linearly from zero to RPS over RampUp, then holds.
+	ProfileRamp = "ramp"
+	// ProfileStep raises the rate to RPS in Steps equal steps over the run.
+	ProfileStep = "step"
+	// ProfileSpike sends RPS, except for SpikeDuration from SpikeAt when it
+	// sends SpikeRPS.
+	ProfileSpike = "spike"
+)
+
+// LoadConfig configures a load run.
+type LoadConfig struct {
+	Profile string
+	// RPS is the target rate in requests per second.
+	RPS float64
+	// Concurrency bounds the requests being sent at once. Requests due while
+	// every slot is busy are dropped and reported.
+	Concurrency int
+	Duration    time.Duration
+	RampUp      time.Duration
+	Steps       int
+	SpikeRPS    float64
+	SpikeAt     time.Duration
+	// SpikeDuration defaults to a tenth of Duration.
+	SpikeDuration time.Duration
+	// Drain is how long to wait for outstanding callbacks once the run ends.
+	Drain time.Duration
+}
+
+// Error categories in a load report.
+const (
+	errSend       = "send"
+	errCommit     = "commit"
+	errNoCallback = "no callback"
+	errDropped    = "dropped: concurrency limit"
+)
+
+// rate returns the target rate elapsed into the run.
+func (cfg LoadConfig) rate(elapsed time.Duration) float64 {
+	switch cfg.Profile {
+	case ProfileRamp:
+		if cfg.RampUp > 0 && elapsed < cfg.RampUp {
+			return cfg.RPS * float64(elapsed) / float64(cfg.RampUp)
+		}
+	case ProfileStep:
+		if cfg.Steps > 1 {
+			step := int(elapsed*time.Duration(cfg.Steps)/cfg.Duration) + 1
+			if step > cfg.Steps {
+				step = cfg.Steps
+			}
+			return cfg.RPS * float64(step) / float64(cfg.Steps)
+		}
+	case ProfileSpike:
+		if elapsed >= cfg.SpikeAt && elapsed < cfg.SpikeAt+cfg.SpikeDuration {
+			return cfg.SpikeRPS
+		}
+	}
+
+	return cfg.RPS
+}
+
+func (cfg *LoadConfig) validate() error {
+	switch cfg.Profile {
+	case "":
+		cfg.Profile = ProfileConstant
+	case ProfileConstant, ProfileRamp, ProfileStep, ProfileSpike:
+	default:
+		return fmt.Errorf("unknown load profile %q", cfg.Profile)
+	}
+
+	if cfg.RPS <= 0 {
+		return errors.New("load RPS must be positive")
+	}
+
+	if cfg.Duration <= 0 {
+		return errors.New("load duration must be positive")
+	}
+
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+
+	if cfg.Profile == ProfileSpike && cfg.SpikeDuration <= 0 {
+		cfg.SpikeDuration = cfg.Duration / 10
+	}
+
+	if cfg.Drain <= 0 {
+		cfg.Drain = 10 * time.Second
+	}
+
+	return nil
+}
+
+// Latencies summarizes one latency distribution, in milliseconds.
+type Latencies struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+func summarize(samples []time.Duration) Latencies {
+	if len(samples) == 0 {
+		return Latencies{}
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	var total time.Duration
+	for _, s := range samples {
+		total += s
+	}
+
+	percentile := func(p float64) float64 {
+		i := int(math.Ceil(p*float64(len(samples)))) - 1
+		if i < 0 {
+			i = 0
+		}
+		return ms(samples[i])
+	}
+
+	return Latencies{
+		Count: len(samples),
+		Mean:  ms(total / time.Duration(len(samples))),
+		P50:   percentile(0.50),
+		P95:   percentile(0.95),
+		P99:   percentile(0.99),
+		Max:   ms(samples[len(samples)-1]),
+	}
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// Report is the outcome of a load run.
+type Report struct {
+	Profile     string    `json:"profile"`
+	TargetRPS   float64   `json:"target_rps"`
+	StartedAt   time.Time `json:"started_at"`
+	Duration    float64   `json:"duration_s"`
+	Sent        int       `json:"sent"`
+	Completed   int       `json:"completed"`
+	AchievedRPS float64   `json:"achieved_rps"`
+	// Send is the forwarder's response time to the data request, Callback
+	// the time from sending until the monitor called back, Commit the
+	// forwarder's response time to the decision and Decided the time from
+	// sending until the decision was accepted. Consumers process the event
+	// after that, their acks report the end to end latency.
+	Send     Latencies      `json:"send"`
+	Callback Latencies      `json:"callback"`
+	Commit   Latencies      `json:"commit"`
+	Decided  Latencies      `json:"decided"`
+	Errors   map[string]int `json:"errors"`
+}
+
+// WriteText prints r in a human readable form.
+func (r Report) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "profile %s at %.1f rps for %.1fs: %d sent, %d completed (%.1f rps achieved)\n",
+		r.Profile, r.TargetRPS, r.Duration, r.Sent, r.Completed, r.AchievedRPS)
+
+	fmt.Fprintf(w, "%-12s %8s %10s %10s %10s %10s %10s\n", "latency", "count", "mean", "p50", "p95", "p99", "max")
+	for _, row := range []struct {
+		name string
+		l    Latencies
+	}{
+		{"send", r.Send},
+		{"callback", r.Callback},
+		{"commit", r.Commit},
+		{"decided", r.Decided},
+	} {
+		fmt.Fprintf(w, "%-12s %8d %8.1fms %8.1fms %8.1fms %8.1fms %8.1fms\n",
+			row.name, row.l.Count, row.l.Mean, row.l.P50, row.l.P95, row.l.P99, row.l.Max)
+	}
+
+	if len(r.Errors) == 0 {
+		fmt.Fprintln(w, "no errors")
+		return
+	}
+
+	categories := make([]string, 0, len(r.Errors))
+	for category := range r.Errors {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	fmt.Fprintln(w, "errors:")
+	for _, category := range categories {
+		fmt.Fprintf(w, "  %-40s %d\n", category, r.Errors[category])
+	}
+}
+
+// WriteJSON exports r as JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// loadRun collects the measurements of a load run. The callback handler
+// reports into it while a run is in progress.
+type loadRun struct {
+	mu         sync.Mutex
+	sentAt     map[string]time.Time
+	committing int
+	sent       int
+	send       []time.Duration
+	callback   []time.Duration
+	commit     []time.Duration
+	decided    []time.Duration
+	errors     map[string]int
+}
+
+func newLoadRun() *loadRun {
+	return &loadRun{
+		sentAt: make(map[string]time.Time),
+		errors: make(map[string]int),
+	}
+}
+
+func (r *loadRun) fail(category string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.errors[category]++
+}
+
+// callbackReceived returns when executionID was sent and whether it belongs
+// to the run.
+func (r *loadRun) callbackReceived(executionID string, at time.Time) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sentAt, ok := r.sentAt[executionID]
+	if !ok {
+		return time.Time{}, false
+	}
+
+	delete(r.sentAt, executionID)
+	r.committing++
+	r.callback = append(r.callback, at.Sub(sentAt))
+
+	return sentAt, true
+}
+
+func (r *loadRun) committed(sentAt, start time.Time, err error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.committing--
+
+	if category := classify(errCommit, err); category != "" {
+		r.errors[category]++
+		return
+	}
+
+	r.commit = append(r.commit, now.Sub(start))
+	r.decided = append(r.decided, now.Sub(sentAt))
+}
+
+// failed records a callback that was answered without a decision.
+func (r *loadRun) failed(category string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.committing--
+	r.errors[category]++
+}
+
+func (r *loadRun) outstanding() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.sentAt) + r.committing
+}
+
+// classify returns the error category of a request to the forwarder, or ""
+// if it succeeded.
+func classify(stage string, err error) string {
+	var urlErr interface{ Timeout() bool }
+	var statusErr *client.StatusError
+
+	switch {
+	case errors.Is(err, ErrSpooled):
+		return stage + ": spooled"
+	case err != nil && errors.As(err, &urlErr) && urlErr.Timeout():
+		return stage + ": timeout"
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("%s: HTTP %d", stage, statusErr.StatusCode)
+	case err != nil:
+		return stage + ": connection error"
+	}
+
+	return ""
+}
+
+// RunLoad sends data requests following cfg and reports the latencies and
+// errors observed. The producer's callback handler must be served for the
+// whole run.
+func (p *Producer) RunLoad(ctx context.Context, cfg LoadConfig) (Report, error) {
+	if err := cfg.validate(); err != nil {
+		return Report{}, err
+	}
+
+	run := newLoadRun()
+
+	p.mu.Lock()
+	p.load = run
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.load = nil
+		p.mu.Unlock()
+	}()
+
+	slots := make(chan struct{}, cfg.Concurrency)
+	var wg sync.WaitGroup
+
+	start := time.Now()
+	runCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	p.customLogger.Log("load", fmt.Sprintf("starting %s load at %.1f rps for %s", cfg.Profile, cfg.RPS, cfg.Duration), nil, "", "")
+
+	// The next request is due an interval at the current rate after the
+	// last one. The interval is recomputed at least every maxWait, so a
+	// rate changing during a ramp or step applies right away rather than
+	// after an interval computed at the previous rate.
+	const maxWait = 10 * time.Millisecond
+
+	last := start
+	for runCtx.Err() == nil {
+		now := time.Now()
+
+		rate := cfg.rate(now.Sub(start))
+		if rate <= 0 {
+			last = now
+			sleep(runCtx, maxWait)
+			continue
+		}
+
+		interval := time.Duration(float64(time.Second) / rate)
+		next := last.Add(interval)
+
+		if wait := next.Sub(now); wait > 0 {
+			sleep(runCtx, min(wait, maxWait))
+			continue
+		}
+
+		// Catch up on at most one interval, rather than bursting the
+		// requests owed at the previous rate.
+		last = next
+		if behind := now.Add(-interval); last.Before(behind) {
+			last = behind
+		}
+
+		select {
+		case slots <- struct{}{}:
+		default:
+			run.fail(errDropped)
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			p.sendLoad(run)
+		}()
+	}
+
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	deadline := time.NewTimer(cfg.Drain)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+drain:
+	for run.outstanding() > 0 {
+		select {
+		case <-ctx.Done():
+			break drain
+		case <-deadline.C:
+			break drain
+		case <-ticker.C:
+		}
+	}
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	if n := len(run.sentAt); n > 0 {
+		run.errors[errNoCallback] += n
+	}
+
+	report := Report{
+		Profile:   cfg.Profile,
+		TargetRPS: cfg.RPS,
+		StartedAt: start,
+		Duration:  elapsed.Seconds(),
+		Sent:      run.sent,
+		Completed: len(run.decided),
+		Send:      summarize(run.send),
+		Callback:  summarize(run.callback),
+		Commit:    summarize(run.commit),
+		Decided:   summarize(run.decided),
+		Errors:    run.errors,
+	}
+	report.AchievedRPS = float64(report.Sent) / report.Duration
+
+	return report, nil
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func (p *Producer) sendLoad(run *loadRun) {
+	id, _ := uuid.NewUUID()
+	executionID := id.String()
+	data := p.createDataRequest(executionID)
+
+	// Registered before sending, as the callback may arrive before the
+	// forwarder's response.
+	start := time.Now()
+	run.mu.Lock()
+	run.sentAt[executionID] = start
+	run.sent++
+	run.mu.Unlock()
+
+	_, err := p.sendData(context.Background(), data)
+	elapsed := time.Since(start)
+
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	if category := classify(errSend, err); category != "" {
+		delete(run.sentAt, executionID)
+		run.errors[category]++
+		return
+	}
+
+	run.send = append(run.send, elapsed)
+}
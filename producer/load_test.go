@@ -0,0 +1,51 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+func TestRunLoadFollowsRamp(t *testing.T) {
+	var received atomic.Int32
+
+	forwarder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+		json.NewEncoder(w).Encode(shared.DataResponse{CorrelationID: "corr"})
+	}))
+	t.Cleanup(forwarder.Close)
+
+	customLogger := logger.New("producer")
+	customLogger.SetOutput(io.Discard)
+
+	p := New(Config{ForwarderURL: forwarder.URL, ServiceName: "payments"}, customLogger)
+
+	// Ramping to 100 rps over the whole second sends about 50 requests.
+	report, err := p.RunLoad(context.Background(), LoadConfig{
+		Profile:     ProfileRamp,
+		RPS:         100,
+		RampUp:      time.Second,
+		Duration:    time.Second,
+		Concurrency: 10,
+		Drain:       time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("RunLoad: %v", err)
+	}
+
+	if report.Sent < 35 || report.Sent > 65 {
+		t.Errorf("sent %d requests, want about 50", report.Sent)
+	}
+
+	if n := int(received.Load()); n != report.Sent {
+		t.Errorf("forwarder received %d requests, report says %d", n, report.Sent)
+	}
+}
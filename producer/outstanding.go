@@ -0,0 +1,192 @@
+package producer
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownRequest is returned for a callback about a request the
+	// producer didn't send, or that already expired.
+	ErrUnknownRequest = errors.New("unknown request")
+	// ErrAlreadyDecided is returned for a callback about a request already
+	// committed or cancelled, or being decided.
+	ErrAlreadyDecided = errors.New("request already decided")
+	// ErrCorrelationMismatch is returned for a callback whose correlation ID
+	// differs from the one the forwarder assigned to the request.
+	ErrCorrelationMismatch = errors.New("correlation id mismatch")
+)
+
+// Outstanding is a request sent to the forwarder and not decided yet.
+type Outstanding struct {
+	ExecutionID string `json:"execution_id"`
+	// CorrelationID is empty until the forwarder has answered.
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	SentAt        time.Time `json:"sent_at"`
+	// Deciding is set between the callback and the decision reaching the
+	// forwarder.
+	Deciding bool `json:"deciding"`
+}
+
+// tracker holds the outstanding requests by execution ID, and remembers
+// decided ones for as long as outstanding ones are kept, to reject repeated
+// callbacks.
+type tracker struct {
+	ttl         time.Duration
+	onExpire    func(Outstanding)
+	mu          sync.Mutex
+	requests    map[string]*Outstanding
+	decided     map[string]time.Time
+	lastSweepAt time.Time
+}
+
+func newTracker(ttl time.Duration, onExpire func(Outstanding)) *tracker {
+	return &tracker{
+		ttl:      ttl,
+		onExpire: onExpire,
+		requests: make(map[string]*Outstanding),
+		decided:  make(map[string]time.Time),
+	}
+}
+
+// register records a request about to be sent. It must be called before
+// sending, as the callback may arrive before the forwarder's response.
+func (t *tracker) register(executionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.requests[executionID] = &Outstanding{ExecutionID: executionID, SentAt: now}
+	t.sweep(now)
+}
+
+// resend records a spooled request about to be sent again. Its TTL starts
+// over, as the forwarder hasn't seen it yet, and it is tracked again if it
+// expired while spooled.
+func (t *tracker) resend(executionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.decided[executionID]; ok {
+		return
+	}
+
+	now := time.Now()
+	if req, ok := t.requests[executionID]; ok {
+		req.SentAt = now
+	} else {
+		t.requests[executionID] = &Outstanding{ExecutionID: executionID, SentAt: now}
+	}
+}
+
+// correlate records the correlation ID the forwarder assigned.
+func (t *tracker) correlate(executionID, correlationID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if req, ok := t.requests[executionID]; ok && req.CorrelationID == "" {
+		req.CorrelationID = correlationID
+	}
+}
+
+// forget drops a request the forwarder didn't accept.
+func (t *tracker) forget(executionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.requests, executionID)
+}
+
+// claim marks a request as being decided following a callback.
+func (t *tracker) claim(executionID, correlationID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.decided[executionID]; ok {
+		return ErrAlreadyDecided
+	}
+
+	req, ok := t.requests[executionID]
+	if !ok {
+		return ErrUnknownRequest
+	}
+
+	if req.Deciding {
+		return ErrAlreadyDecided
+	}
+
+	if req.CorrelationID == "" {
+		req.CorrelationID = correlationID
+	} else if req.CorrelationID != correlationID {
+		return ErrCorrelationMismatch
+	}
+
+	req.Deciding = true
+
+	return nil
+}
+
+// release makes a claimed request undecided again, when no decision could
+// be sent.
+func (t *tracker) release(executionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if req, ok := t.requests[executionID]; ok {
+		req.Deciding = false
+	}
+}
+
+// decide records that the decision of a claimed request was accepted.
+func (t *tracker) decide(executionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.requests, executionID)
+	t.decided[executionID] = time.Now()
+}
+
+// list returns the outstanding requests, oldest first.
+func (t *tracker) list() []Outstanding {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(time.Now())
+
+	requests := make([]Outstanding, 0, len(t.requests))
+	for _, req := range t.requests {
+		requests = append(requests, *req)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].SentAt.Before(requests[j].SentAt)
+	})
+
+	return requests
+}
+
+// sweep expires the requests older than the TTL, at most every tenth of the
+// TTL. t.mu must be held.
+func (t *tracker) sweep(now time.Time) {
+	if now.Sub(t.lastSweepAt) < t.ttl/10 {
+		return
+	}
+	t.lastSweepAt = now
+
+	for id, req := range t.requests {
+		if now.Sub(req.SentAt) > t.ttl && !req.Deciding {
+			delete(t.requests, id)
+			if t.onExpire != nil {
+				t.onExpire(*req)
+			}
+		}
+	}
+
+	for id, decidedAt := range t.decided {
+		if now.Sub(decidedAt) > t.ttl {
+			delete(t.decided, id)
+		}
+	}
+}
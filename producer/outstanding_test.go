@@ -0,0 +1,215 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// fakeForwarder answers data requests with the correlation ID corr-{execution
+// ID}, and records the decisions it receives. Decisions are rejected with
+// decisionStatus when set.
+type fakeForwarder struct {
+	*httptest.Server
+
+	mu             sync.Mutex
+	decisions      []shared.CommitRequest
+	decisionStatus int
+}
+
+func newFakeForwarder(t *testing.T) *fakeForwarder {
+	f := &fakeForwarder{}
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/request":
+			var data shared.DataRequest
+			json.NewDecoder(r.Body).Decode(&data)
+			json.NewEncoder(w).Encode(shared.DataResponse{CorrelationID: "corr-" + data.ExecutionID})
+		case "/commit":
+			var decision shared.CommitRequest
+			json.NewDecoder(r.Body).Decode(&decision)
+
+			f.mu.Lock()
+			defer f.mu.Unlock()
+
+			if f.decisionStatus != 0 {
+				w.WriteHeader(f.decisionStatus)
+				return
+			}
+			f.decisions = append(f.decisions, decision)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeForwarder) rejectDecisions(status int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.decisionStatus = status
+}
+
+func (f *fakeForwarder) received() []shared.CommitRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]shared.CommitRequest(nil), f.decisions...)
+}
+
+func newTestProducer(t *testing.T, cfg Config) *Producer {
+	t.Helper()
+
+	customLogger := logger.New("producer")
+	customLogger.SetOutput(io.Discard)
+
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "payments"
+	}
+	if cfg.MaxAttempts == 0 {
+		cfg.MaxAttempts = 1
+	}
+
+	return New(cfg, customLogger)
+}
+
+// callBack sends the monitor's callback for a request to handler.
+func callBack(handler http.Handler, path, correlationID, executionID string) int {
+	query := url.Values{"correlation_id": {correlationID}, "execution_id": {executionID}}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path+"?"+query.Encode(), nil))
+	return rec.Code
+}
+
+func TestTrackerClaim(t *testing.T) {
+	tr := newTracker(time.Minute, nil)
+	tr.register("exec-1")
+	tr.correlate("exec-1", "corr-1")
+
+	steps := []struct {
+		name          string
+		action        func()
+		executionID   string
+		correlationID string
+		want          error
+	}{
+		{"unknown", nil, "exec-2", "corr-2", ErrUnknownRequest},
+		{"mismatch", nil, "exec-1", "corr-2", ErrCorrelationMismatch},
+		{"match", nil, "exec-1", "corr-1", nil},
+		{"while deciding", nil, "exec-1", "corr-1", ErrAlreadyDecided},
+		{"released", func() { tr.release("exec-1") }, "exec-1", "corr-1", nil},
+		{"decided", func() { tr.decide("exec-1") }, "exec-1", "corr-1", ErrAlreadyDecided},
+	}
+
+	for _, step := range steps {
+		if step.action != nil {
+			step.action()
+		}
+
+		if err := tr.claim(step.executionID, step.correlationID); err != step.want {
+			t.Errorf("%s: claim(%s, %s) = %v, want %v", step.name, step.executionID, step.correlationID, err, step.want)
+		}
+	}
+}
+
+func TestTrackerCorrelatesOnCallback(t *testing.T) {
+	tr := newTracker(time.Minute, nil)
+
+	// The callback may arrive before the forwarder's response.
+	tr.register("exec-1")
+	if err := tr.claim("exec-1", "corr-1"); err != nil {
+		t.Fatalf("claim() = %v", err)
+	}
+
+	tr.correlate("exec-1", "corr-other")
+	if got := tr.list()[0].CorrelationID; got != "corr-1" {
+		t.Fatalf("correlation ID %q, want the callback's corr-1", got)
+	}
+}
+
+func TestTrackerExpires(t *testing.T) {
+	var expired []string
+	ttl := 50 * time.Millisecond
+	tr := newTracker(ttl, func(req Outstanding) { expired = append(expired, req.ExecutionID) })
+
+	tr.register("exec-old")
+	tr.register("exec-deciding")
+	tr.claim("exec-deciding", "corr-deciding")
+	tr.register("exec-decided")
+	tr.claim("exec-decided", "corr-decided")
+	tr.decide("exec-decided")
+
+	time.Sleep(ttl + 10*time.Millisecond)
+	tr.register("exec-new")
+
+	if len(expired) != 1 || expired[0] != "exec-old" {
+		t.Fatalf("expired %v, want [exec-old], not the one being decided", expired)
+	}
+
+	var ids []string
+	for _, req := range tr.list() {
+		ids = append(ids, req.ExecutionID)
+	}
+	if len(ids) != 2 || ids[0] != "exec-deciding" || ids[1] != "exec-new" {
+		t.Fatalf("outstanding %v, want [exec-deciding exec-new]", ids)
+	}
+
+	// Decided requests are forgotten after the TTL too, so a late repeated
+	// callback is then unknown rather than already decided.
+	time.Sleep(ttl + 10*time.Millisecond)
+	tr.list()
+
+	if err := tr.claim("exec-decided", "corr-decided"); err != ErrUnknownRequest {
+		t.Fatalf("claim() of an expired decided request = %v, want %v", err, ErrUnknownRequest)
+	}
+}
+
+func TestCallbackRejectsStrayRequests(t *testing.T) {
+	forwarder := newFakeForwarder(t)
+	p := newTestProducer(t, Config{ForwarderURL: forwarder.URL, Scenario: &Scenario{Phases: []Phase{{CommitRatio: new(float64)}}}})
+	handler := p.Handler()
+
+	if _, _, err := p.Fire(context.Background(), FireRequest{ExecutionID: "exec-1"}); err != nil {
+		t.Fatalf("Fire: %v", err)
+	}
+
+	tests := []struct {
+		name          string
+		correlationID string
+		executionID   string
+		want          int
+	}{
+		{"unknown", "corr-exec-2", "exec-2", http.StatusNotFound},
+		{"mismatch", "corr-other", "exec-1", http.StatusConflict},
+		{"match", "corr-exec-1", "exec-1", http.StatusOK},
+		{"repeated", "corr-exec-1", "exec-1", http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		if got := callBack(handler, "/callback", tt.correlationID, tt.executionID); got != tt.want {
+			t.Errorf("%s: callback answered %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	decisions := forwarder.received()
+	if len(decisions) != 1 || decisions[0].ExecutionID != "exec-1" || decisions[0].Commit {
+		t.Fatalf("forwarder received %+v, want exec-1 cancelled once", decisions)
+	}
+
+	if counters := p.Status().Counters; counters.Callbacks != 4 || counters.RejectedCallbacks != 3 || counters.Cancels != 1 {
+		t.Fatalf("counters %+v, want 4 callbacks, 3 rejected and 1 cancel", counters)
+	}
+}
@@ -1,125 +1,369 @@
-package main
+package producer
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net/http"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/assimoes/rtd-sandbox/client"
 	"github.com/assimoes/rtd-sandbox/logger" // Import the logger package
 	"github.com/assimoes/rtd-sandbox/shared"
 	"github.com/google/uuid"
 )
 
-var (
-	forwarderURL  = shared.GetEnv("FORWARDER_URL", "http://localhost:3000")
-	externalName  = shared.GetEnv("EXTERNAL_NAME", "localhost")
-	externalPort  = shared.GetEnv("EXTERNAL_PORT", "8888")
-	tickerTimeout = 5 * time.Second
-	friendlyName  = shared.GetEnv("FRIENDLY_NAME", "producer_a")
-	customLogger  *logger.CustomLogger
-)
+// Config configures a Producer.
+type Config struct {
+	// ForwarderURL is the base URL of the forwarder.
+	ForwarderURL string
+	// ServiceName identifies the producer in its data requests.
+	ServiceName string
+	// CallbackURL is where the monitor calls back for commit decisions.
+	CallbackURL string
+	// Interval between data requests.
+	Interval time.Duration
+	// Scenario scripts the requests and decisions. Without one, requests
+	// are sent every Interval and a fair coin decides immediately.
+	Scenario *Scenario
+	// OutstandingTTL is how long a request waits for its callback before
+	// being expired, 5 minutes by default.
+	OutstandingTTL time.Duration
+	// ManualApproval parks every decision until an operator approves or
+	// rejects it through the pending API, instead of following the scenario.
+	ManualApproval bool
+	// RequestTimeout bounds each request to the forwarder, 10s by default.
+	RequestTimeout time.Duration
+	// MaxAttempts is how many times a request is sent before giving up, 3
+	// by default. Only connection errors, timeouts, 5xx and 429 responses
+	// are retried.
+	MaxAttempts int
+	// RetryBackoff is the wait before the first retry, doubling after each
+	// one, 200ms by default.
+	RetryBackoff time.Duration
+	// SpoolDir is where requests still failing after every attempt are kept
+	// until the forwarder is reachable again. Spooling is off when empty.
+	SpoolDir string
+	// SpoolSize is the most requests kept in the spool, 1000 by default.
+	// The oldest ones are dropped when it is full.
+	SpoolSize int
+	// SpoolReplayInterval is how often Run tries to deliver the spool, 5s
+	// by default.
+	SpoolReplayInterval time.Duration
+}
+
+// Producer sends data requests to the forwarder and commits or cancels them
+// when the monitor calls back, as its scenario dictates.
+type Producer struct {
+	cfg          Config
+	customLogger *logger.CustomLogger
+	startedAt    time.Time
+	outstanding  *tracker
+	pending      *pendingQueue
+	api          *client.Client
+	spool        *spool
+	counters     counters
+
+	paused           atomic.Bool
+	intervalOverride atomic.Int64
+	wake             chan struct{}
+
+	mu   sync.Mutex
+	load *loadRun
+}
+
+func New(cfg Config, customLogger *logger.CustomLogger) *Producer {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+
+	if cfg.Scenario == nil {
+		cfg.Scenario = defaultScenario
+	}
 
-func main() {
+	if cfg.OutstandingTTL <= 0 {
+		cfg.OutstandingTTL = 5 * time.Minute
+	}
+
+	if cfg.SpoolSize <= 0 {
+		cfg.SpoolSize = 1000
+	}
+
+	if cfg.SpoolReplayInterval <= 0 {
+		cfg.SpoolReplayInterval = 5 * time.Second
+	}
 
-	ticker := time.NewTicker(tickerTimeout)
-	defer ticker.Stop()
+	p := &Producer{
+		cfg:          cfg,
+		customLogger: customLogger,
+		startedAt:    time.Now(),
+		pending:      newPendingQueue(),
+		api: client.New(client.Config{
+			ForwarderURL: cfg.ForwarderURL,
+			ServiceName:  cfg.ServiceName,
+			CallbackURL:  cfg.CallbackURL,
+			Timeout:      cfg.RequestTimeout,
+			MaxAttempts:  cfg.MaxAttempts,
+			RetryBackoff: cfg.RetryBackoff,
+			CustomLogger: customLogger,
+		}),
+		wake: make(chan struct{}, 1),
+	}
+
+	if cfg.SpoolDir != "" {
+		p.spool = newSpool(cfg.SpoolDir, cfg.SpoolSize)
+	}
 
-	// Initialize the custom logger with the friendly name.
-	customLogger = logger.New(friendlyName)
+	p.outstanding = newTracker(cfg.OutstandingTTL, func(req Outstanding) {
+		customLogger.Log("error", fmt.Sprintf("Request expired after %s without a callback", cfg.OutstandingTTL), nil, req.CorrelationID, req.ExecutionID)
+	})
+
+	return p
+}
+
+// Handler returns the producer's callback, outstanding requests, pending
+// decisions and admin routes.
+func (p *Producer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/callback", p.api.CallbackHandler(p.decideCallback))
+	mux.HandleFunc("/outstanding", p.getOutstanding)
+	mux.HandleFunc("/pending", p.getPending)
+	mux.HandleFunc("/pending/", p.resolvePending)
+	mux.Handle("/admin/", p.adminHandler())
+	return logger.Middleware(mux)
+}
+
+// Outstanding returns the requests sent and not decided yet, oldest first.
+func (p *Producer) Outstanding() []Outstanding {
+	return p.outstanding.list()
+}
+
+func (p *Producer) getOutstanding(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	requests := p.Outstanding()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"total": len(requests),
+		"data":  requests,
+	})
+}
+
+// Run sends data requests until ctx is done or the scenario is over, unless
+// paused, and replays the spool until ctx is done.
+func (p *Producer) Run(ctx context.Context) {
+	if p.spool != nil {
+		go p.replaySpool(ctx)
+	}
+
+	for {
+		if _, ok := p.phase(); !ok {
+			p.customLogger.LogContext(ctx, "scenario", fmt.Sprintf("scenario %s finished", p.cfg.Scenario.Name), nil)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		case <-time.After(p.interval()):
+			if p.paused.Load() {
+				continue
+			}
 
-	go func() {
-		for range ticker.C {
 			executionID, _ := uuid.NewUUID()
-			data := createDataRequest(executionID.String())
-			if err := sendData(data, customLogger); err != nil {
-				customLogger.Log("forwarder", fmt.Sprintf("Error sending data: %v", err), err, data.CorrelationID, executionID.String())
+			data := p.createDataRequest(executionID.String())
+			reqCtx := logger.WithIDs(ctx, logger.IDs{ExecutionID: data.ExecutionID})
+			if _, err := p.sendData(reqCtx, data); err != nil {
+				p.customLogger.LogContext(reqCtx, "forwarder", fmt.Sprintf("Error sending data: %v", err), err)
 			}
 		}
-	}()
-
-	http.HandleFunc("/callback", callback)
-	err := http.ListenAndServe(":"+externalPort, nil)
-	if err != nil {
-		customLogger.Log("forwarder", fmt.Sprintf("Error starting HTTP server: %v", err), err, "error-correlation-id", "error-execution-id")
 	}
 }
 
-func createDataRequest(executionID string) shared.DataRequest {
+// phase returns the current phase of the scenario, or its last phase and
+// false once the scenario is over.
+func (p *Producer) phase() (Phase, bool) {
+	return p.cfg.Scenario.phaseAt(time.Since(p.startedAt))
+}
+
+func (p *Producer) createDataRequest(executionID string) shared.DataRequest {
+
+	phase, _ := p.phase()
+
+	// Payloads are validated when the scenario is loaded.
+	payload, _ := phase.Payload.payload()
 
 	data := shared.DataRequest{
-		UserID:      strconv.Itoa(rand.Int()),
-		Timestamp:   time.Now(),
-		ServiceName: externalName,
-		Callback:    fmt.Sprintf("http://%s:%s/callback", externalName, externalPort),
+		UserID:      phase.Payload.userID(),
+		Timestamp:   time.Now().Add(time.Duration(phase.Payload.TimestampSkew)),
+		ServiceName: p.cfg.ServiceName,
+		Callback:    p.cfg.CallbackURL,
 		ExecutionID: executionID,
+		ContentType: phase.Payload.ContentType,
+		Payload:     payload,
+		Attributes:  phase.Payload.Attributes,
 	}
 	return data
 }
 
-func callback(w http.ResponseWriter, r *http.Request) {
-	correlationID := r.URL.Query().Get("correlation_id")
-	executionID := r.URL.Query().Get("execution_id")
+// decideCallback decides on a callback from the monitor as the scenario
+// dictates, or parks the decision for an operator with ManualApproval.
+func (p *Producer) decideCallback(ctx context.Context, cb client.Callback) (client.Decision, error) {
+	correlationID, executionID := cb.CorrelationID, cb.ExecutionID
 
-	if correlationID == "" {
-		customLogger.Log("error", "Missing correlation id", nil, "", "")
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	p.counters.callbacks.Add(1)
+
+	if err := p.outstanding.claim(executionID, correlationID); err != nil {
+		p.customLogger.LogContext(ctx, "error", fmt.Sprintf("Rejected callback: %v", err), err)
+		p.counters.rejectedCallbacks.Add(1)
+
+		switch err {
+		case ErrUnknownRequest:
+			return 0, &client.CallbackError{StatusCode: http.StatusNotFound, Err: err}
+		default:
+			return 0, &client.CallbackError{StatusCode: http.StatusConflict, Err: err}
+		}
 	}
 
-	if executionID == "" {
-		customLogger.Log("error", "Missing execution id", nil, "", "")
-		w.WriteHeader(http.StatusBadRequest)
-		return
+	p.mu.Lock()
+	run := p.load
+	p.mu.Unlock()
+
+	var sentAt time.Time
+	measured := false
+	if run != nil {
+		sentAt, measured = run.callbackReceived(executionID, time.Now())
 	}
 
-	cr := shared.CommitRequest{
-		CorrelationID: correlationID,
-		ExecutionID:   executionID,
-		Commit:        randBool(),
+	phase, _ := p.phase()
+
+	switch phase.failure() {
+	case failError:
+		p.customLogger.LogContext(ctx, "scenario", "Injected callback failure", nil)
+		p.counters.injectedFailures.Add(1)
+		if measured {
+			run.failed("callback: injected error")
+		}
+		p.outstanding.release(executionID)
+		return 0, &client.CallbackError{StatusCode: http.StatusInternalServerError, Err: errors.New("injected callback failure")}
+	case failTimeout:
+		p.customLogger.LogContext(ctx, "scenario", "Injected callback timeout", nil)
+		p.counters.injectedFailures.Add(1)
+		if measured {
+			run.failed("callback: injected timeout")
+		}
+		defer p.outstanding.release(executionID)
+		select {
+		case <-ctx.Done():
+		case <-time.After(phase.timeout()):
+		}
+		return 0, &client.CallbackError{StatusCode: http.StatusGatewayTimeout, Err: errors.New("injected callback timeout")}
 	}
 
-	if err := commit(cr, customLogger); err != nil {
-		customLogger.Log("error", fmt.Sprintf("error committing message to forwarder: %v", err), err, correlationID, executionID)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	// Decisions keep the callback's IDs but not its cancellation, as they
+	// may outlive it.
+	ctx = context.WithoutCancel(ctx)
+
+	decide := func(commit bool) error {
+		start := time.Now()
+		err := p.api.Decide(ctx, correlationID, executionID, commit)
+		err = p.spoolFailed(ctx, "/commit", shared.CommitRequest{
+			CorrelationID: correlationID,
+			ExecutionID:   executionID,
+			OriginService: p.cfg.ServiceName,
+			Commit:        commit,
+		}, err)
+
+		if measured {
+			run.committed(sentAt, start, err)
+		}
+
+		if err != nil && !errors.Is(err, ErrSpooled) {
+			p.customLogger.LogContext(ctx, "error", fmt.Sprintf("error committing message to forwarder: %v", err), err)
+			p.counters.decisionFailures.Add(1)
+			return err
+		}
+
+		// A spooled decision counts as taken: it reaches the forwarder
+		// once the spool is replayed.
+		if commit {
+			p.counters.commits.Add(1)
+		} else {
+			p.counters.cancels.Add(1)
+		}
+
+		return nil
 	}
-}
 
-func commit(cr shared.CommitRequest, customLogger *logger.CustomLogger) error {
-	data, err := json.Marshal(cr)
-	if err != nil {
-		return err
+	if p.cfg.ManualApproval {
+		p.pending.park(Pending{
+			ExecutionID:   executionID,
+			CorrelationID: correlationID,
+			CalledBackAt:  time.Now(),
+		}, decide)
+
+		p.customLogger.LogContext(ctx, "approval", "Decision awaiting approval", nil)
+		return client.Later, nil
 	}
 
-	res, err := http.Post(forwarderURL+"/commit", "application/json", bytes.NewBuffer(data))
-	if err != nil {
+	commit := phase.commit()
+	decideAndTrack := func() error {
+		err := decide(commit)
+		if err != nil {
+			p.outstanding.release(executionID)
+		} else {
+			p.outstanding.decide(executionID)
+		}
 		return err
 	}
-	defer res.Body.Close()
 
-	//_, _ = io.ReadAll(res.Body)
-	customLogger.Log("forwarder", fmt.Sprintf("Response code from forwarder: %s", res.Status), nil, cr.CorrelationID, cr.ExecutionID)
+	// Delayed decisions are sent after answering, as a client deciding
+	// asynchronously would.
+	if delay := phase.DecisionDelay.sample(); delay > 0 {
+		time.AfterFunc(delay, func() { decideAndTrack() })
+		return client.Later, nil
+	}
 
-	return nil
+	if err := decideAndTrack(); err != nil {
+		return 0, err
+	}
+
+	return client.Decided, nil
 }
 
-func sendData(data shared.DataRequest, customLogger *logger.CustomLogger) error {
-	dataBytes, _ := json.Marshal(data)
-	res, err := http.Post(forwarderURL+"/request", "application/json", bytes.NewBuffer(dataBytes))
-	if err != nil {
-		return err
+// sendData sends a data request to the forwarder, tracking it as outstanding
+// until decided. The request starts a new trace unless ctx carries one.
+func (p *Producer) sendData(ctx context.Context, data shared.DataRequest) (client.Receipt, error) {
+	p.outstanding.register(data.ExecutionID)
+	p.counters.sent.Add(1)
+
+	ids := logger.IDs{ExecutionID: data.ExecutionID}
+	if logger.IDsFrom(ctx).TraceID == "" {
+		ids.TraceID = logger.NewTraceID()
 	}
-	defer res.Body.Close()
+	ctx = logger.WithIDs(ctx, ids)
 
-	customLogger.Log("forwarder", fmt.Sprintf("Response code from forwarder: %s", res.Status), nil, data.CorrelationID, data.ExecutionID)
+	receipt, err := p.api.Submit(ctx, data)
+	err = p.spoolFailed(ctx, "/request", data, err)
+	if errors.Is(err, ErrSpooled) {
+		// Still outstanding, and correlated once replayed.
+		p.counters.spooled.Add(1)
+		return receipt, err
+	}
 
-	return nil
-}
+	if err != nil {
+		p.outstanding.forget(data.ExecutionID)
+		p.counters.sendFailures.Add(1)
+		return receipt, err
+	}
+
+	p.outstanding.correlate(data.ExecutionID, receipt.CorrelationID)
 
-func randBool() bool {
-	return rand.Intn(2) == 0
+	return receipt, nil
 }
@@ -0,0 +1,344 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Delay distributions.
+const (
+	DistributionConstant    = "constant"
+	DistributionUniform     = "uniform"
+	DistributionNormal      = "normal"
+	DistributionExponential = "exponential"
+)
+
+// User ID strategies of a payload shape. Any other value is sent as is.
+const (
+	UserIDRandom     = "random"
+	UserIDSequential = "sequential"
+	UserIDEmpty      = "empty"
+)
+
+// Scenario scripts how the producer behaves over time, as a sequence of
+// phases.
+//
+//	name: flaky-callbacks
+//	loop: true
+//	phases:
+//	  - name: steady
+//	    duration: 1m
+//	    interval: 500ms
+//	    commit_ratio: 0.9
+//	    decision_delay: {distribution: uniform, min: 50ms, max: 300ms}
+//	  - name: degraded
+//	    duration: 30s
+//	    callback_failures: {error_ratio: 0.2, timeout_ratio: 0.05, timeout: 10s}
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Loop restarts the scenario after its last phase. Otherwise the
+	// producer stops sending once the last phase ends.
+	Loop   bool    `yaml:"loop"`
+	Phases []Phase `yaml:"phases"`
+}
+
+// Phase is one stage of a scenario.
+type Phase struct {
+	Name string `yaml:"name"`
+	// Duration of the phase. Zero means it never ends.
+	Duration Duration `yaml:"duration"`
+	// Interval between data requests, defaulting to Config.Interval.
+	Interval Duration `yaml:"interval"`
+	// CommitRatio is the share of callbacks answered with a commit rather
+	// than a cancel, 0.5 when unset.
+	CommitRatio *float64 `yaml:"commit_ratio"`
+	// DecisionDelay is how long the producer takes to decide after being
+	// called back.
+	DecisionDelay    Delay            `yaml:"decision_delay"`
+	CallbackFailures CallbackFailures `yaml:"callback_failures"`
+	Payload          PayloadShape     `yaml:"payload"`
+}
+
+// Delay is a random delay following a distribution.
+type Delay struct {
+	// Distribution is constant (Value), uniform (Min to Max), normal (Mean
+	// and StdDev) or exponential (Mean). Empty means no delay.
+	Distribution string   `yaml:"distribution"`
+	Value        Duration `yaml:"value"`
+	Min          Duration `yaml:"min"`
+	Max          Duration `yaml:"max"`
+	Mean         Duration `yaml:"mean"`
+	StdDev       Duration `yaml:"stddev"`
+}
+
+// CallbackFailures injects failures into the callback handler.
+type CallbackFailures struct {
+	// ErrorRatio is the share of callbacks answered with a 500.
+	ErrorRatio float64 `yaml:"error_ratio"`
+	// TimeoutRatio is the share of callbacks left unanswered for Timeout,
+	// 30s by default, or until the caller gives up.
+	TimeoutRatio float64  `yaml:"timeout_ratio"`
+	Timeout      Duration `yaml:"timeout"`
+}
+
+// PayloadShape controls the content of the data requests.
+type PayloadShape struct {
+	// UserID is random, sequential, empty or a literal user ID.
+	UserID string `yaml:"user_id"`
+	// TimestampSkew is added to the request timestamp, to simulate clients
+	// with drifting clocks.
+	TimestampSkew Duration `yaml:"timestamp_skew"`
+	// Body is sent as the JSON payload, declared with ContentType.
+	Body        interface{}       `yaml:"body"`
+	ContentType string            `yaml:"content_type"`
+	Attributes  map[string]string `yaml:"attributes"`
+	// SizeBytes, without a Body, sends a filler payload of about that size.
+	SizeBytes int `yaml:"size_bytes"`
+}
+
+// Duration is a time.Duration written as "500ms", "1m" in YAML.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(node *yaml.Node) error {
+	parsed, err := time.ParseDuration(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadScenario reads a scenario from a YAML file.
+func LoadScenario(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Scenario
+	if err := yaml.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parsing scenario %s: %w", path, err)
+	}
+
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("scenario %s: %w", path, err)
+	}
+
+	return &s, nil
+}
+
+func (s *Scenario) validate() error {
+	if len(s.Phases) == 0 {
+		return fmt.Errorf("no phases")
+	}
+
+	for i, ph := range s.Phases {
+		if ph.Duration < 0 {
+			return fmt.Errorf("phase %d: negative duration", i)
+		}
+
+		if ph.Duration == 0 && i != len(s.Phases)-1 {
+			return fmt.Errorf("phase %d: only the last phase may have no duration", i)
+		}
+
+		if ph.Interval < 0 {
+			return fmt.Errorf("phase %d: negative interval", i)
+		}
+
+		if ph.CallbackFailures.Timeout < 0 {
+			return fmt.Errorf("phase %d: negative callback failure timeout", i)
+		}
+
+		if ph.Payload.SizeBytes < 0 {
+			return fmt.Errorf("phase %d: negative payload size_bytes", i)
+		}
+
+		if ph.CommitRatio != nil && !isRatio(*ph.CommitRatio) {
+			return fmt.Errorf("phase %d: commit_ratio must be between 0 and 1", i)
+		}
+
+		if !isRatio(ph.CallbackFailures.ErrorRatio) {
+			return fmt.Errorf("phase %d: error_ratio must be between 0 and 1", i)
+		}
+
+		if !isRatio(ph.CallbackFailures.TimeoutRatio) {
+			return fmt.Errorf("phase %d: timeout_ratio must be between 0 and 1", i)
+		}
+
+		if ph.CallbackFailures.ErrorRatio+ph.CallbackFailures.TimeoutRatio > 1 {
+			return fmt.Errorf("phase %d: callback failure ratios add up to more than 1", i)
+		}
+
+		if _, err := ph.Payload.payload(); err != nil {
+			return fmt.Errorf("phase %d: payload body: %w", i, err)
+		}
+
+		switch ph.DecisionDelay.Distribution {
+		case "", DistributionConstant, DistributionUniform, DistributionNormal, DistributionExponential:
+		default:
+			return fmt.Errorf("phase %d: unknown delay distribution %q", i, ph.DecisionDelay.Distribution)
+		}
+
+		if err := ph.DecisionDelay.validate(); err != nil {
+			return fmt.Errorf("phase %d: decision_delay: %w", i, err)
+		}
+	}
+
+	return nil
+}
+
+// validate rejects negative durations, and a uniform delay whose max is
+// below its min.
+func (d Delay) validate() error {
+	durations := []struct {
+		name  string
+		value Duration
+	}{{"value", d.Value}, {"min", d.Min}, {"max", d.Max}, {"mean", d.Mean}, {"stddev", d.StdDev}}
+
+	for _, duration := range durations {
+		if duration.value < 0 {
+			return fmt.Errorf("negative %s", duration.name)
+		}
+	}
+
+	if d.Distribution == DistributionUniform && d.Max < d.Min {
+		return fmt.Errorf("max is below min")
+	}
+
+	return nil
+}
+
+// isRatio reports whether r is between 0 and 1, which NaN isn't.
+func isRatio(r float64) bool {
+	return r >= 0 && r <= 1
+}
+
+// defaultScenario reproduces the producer's behaviour without a scenario: a
+// fair coin decides immediately.
+var defaultScenario = &Scenario{Name: "default", Phases: []Phase{{Name: "default"}}}
+
+// phaseAt returns the phase elapsed into the scenario. Once a scenario that
+// doesn't loop is over, it returns the last phase and false.
+func (s *Scenario) phaseAt(elapsed time.Duration) (Phase, bool) {
+	var total time.Duration
+	for _, ph := range s.Phases {
+		if ph.Duration == 0 {
+			return ph, true
+		}
+		total += time.Duration(ph.Duration)
+	}
+
+	if elapsed >= total {
+		if !s.Loop {
+			return s.Phases[len(s.Phases)-1], false
+		}
+		elapsed %= total
+	}
+
+	for _, ph := range s.Phases {
+		if elapsed < time.Duration(ph.Duration) {
+			return ph, true
+		}
+		elapsed -= time.Duration(ph.Duration)
+	}
+
+	return s.Phases[len(s.Phases)-1], true
+}
+
+func (ph Phase) commit() bool {
+	ratio := 0.5
+	if ph.CommitRatio != nil {
+		ratio = *ph.CommitRatio
+	}
+
+	return rand.Float64() < ratio
+}
+
+// Callback failures.
+const (
+	failNone = iota
+	failError
+	failTimeout
+)
+
+func (ph Phase) failure() int {
+	r := rand.Float64()
+
+	switch {
+	case r < ph.CallbackFailures.ErrorRatio:
+		return failError
+	case r < ph.CallbackFailures.ErrorRatio+ph.CallbackFailures.TimeoutRatio:
+		return failTimeout
+	}
+
+	return failNone
+}
+
+func (ph Phase) timeout() time.Duration {
+	if ph.CallbackFailures.Timeout > 0 {
+		return time.Duration(ph.CallbackFailures.Timeout)
+	}
+
+	return 30 * time.Second
+}
+
+func (d Delay) sample() time.Duration {
+	var delay time.Duration
+
+	switch d.Distribution {
+	case DistributionConstant:
+		delay = time.Duration(d.Value)
+	case DistributionUniform:
+		delay = time.Duration(d.Min)
+		if d.Max > d.Min {
+			delay += time.Duration(rand.Int63n(int64(d.Max - d.Min)))
+		}
+	case DistributionNormal:
+		delay = time.Duration(rand.NormFloat64()*float64(d.StdDev) + float64(d.Mean))
+	case DistributionExponential:
+		delay = time.Duration(rand.ExpFloat64() * float64(d.Mean))
+	}
+
+	if delay < 0 {
+		return 0
+	}
+
+	return delay
+}
+
+// payload returns the JSON payload of a data request.
+func (p PayloadShape) payload() (json.RawMessage, error) {
+	if p.Body != nil {
+		return json.Marshal(p.Body)
+	}
+
+	if p.SizeBytes > 0 {
+		return json.Marshal(map[string]string{"filler": strings.Repeat("x", p.SizeBytes)})
+	}
+
+	return nil, nil
+}
+
+var sequentialUserID atomic.Int64
+
+func (p PayloadShape) userID() string {
+	switch p.UserID {
+	case "", UserIDRandom:
+		return strconv.Itoa(rand.Int())
+	case UserIDSequential:
+		return strconv.FormatInt(sequentialUserID.Add(1), 10)
+	case UserIDEmpty:
+		return ""
+	}
+
+	return p.UserID
+}
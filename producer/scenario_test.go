@@ -0,0 +1,62 @@
+package producer
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestScenarioValidatesRatios(t *testing.T) {
+	ratio := func(r float64) *float64 { return &r }
+
+	tests := []struct {
+		name  string
+		phase Phase
+		ok    bool
+	}{
+		{"defaults", Phase{}, true},
+		{"commit all", Phase{CommitRatio: ratio(1)}, true},
+		{"failures", Phase{CallbackFailures: CallbackFailures{ErrorRatio: 0.2, TimeoutRatio: 0.8}}, true},
+		{"commit over 1", Phase{CommitRatio: ratio(1.5)}, false},
+		{"commit NaN", Phase{CommitRatio: ratio(math.NaN())}, false},
+		{"negative error", Phase{CallbackFailures: CallbackFailures{ErrorRatio: -0.5, TimeoutRatio: 0.5}}, false},
+		{"negative timeout", Phase{CallbackFailures: CallbackFailures{TimeoutRatio: -1}}, false},
+		{"error over 1", Phase{CallbackFailures: CallbackFailures{ErrorRatio: 2, TimeoutRatio: -1}}, false},
+		{"failures over 1", Phase{CallbackFailures: CallbackFailures{ErrorRatio: 0.6, TimeoutRatio: 0.6}}, false},
+	}
+
+	for _, tt := range tests {
+		s := &Scenario{Phases: []Phase{tt.phase}}
+		if err := s.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: validate() = %v, want ok %t", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestScenarioRejectsNegativeDurations(t *testing.T) {
+	second := Duration(time.Second)
+
+	tests := []struct {
+		name  string
+		phase Phase
+		ok    bool
+	}{
+		{"positive", Phase{Duration: second, Interval: second, DecisionDelay: Delay{Distribution: DistributionUniform, Min: second, Max: 2 * second}}, true},
+		{"duration", Phase{Duration: -second}, false},
+		{"interval", Phase{Interval: -second}, false},
+		{"failure timeout", Phase{CallbackFailures: CallbackFailures{Timeout: -second}}, false},
+		{"payload size", Phase{Payload: PayloadShape{SizeBytes: -1}}, false},
+		{"constant delay", Phase{DecisionDelay: Delay{Distribution: DistributionConstant, Value: -second}}, false},
+		{"uniform min", Phase{DecisionDelay: Delay{Distribution: DistributionUniform, Min: -second, Max: second}}, false},
+		{"uniform max below min", Phase{DecisionDelay: Delay{Distribution: DistributionUniform, Min: 2 * second, Max: second}}, false},
+		{"normal stddev", Phase{DecisionDelay: Delay{Distribution: DistributionNormal, Mean: second, StdDev: -second}}, false},
+		{"exponential mean", Phase{DecisionDelay: Delay{Distribution: DistributionExponential, Mean: -second}}, false},
+	}
+
+	for _, tt := range tests {
+		s := &Scenario{Phases: []Phase{tt.phase}}
+		if err := s.validate(); (err == nil) != tt.ok {
+			t.Errorf("%s: validate() = %v, want ok %t", tt.name, err, tt.ok)
+		}
+	}
+}
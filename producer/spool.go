@@ -0,0 +1,132 @@
+package producer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spoolEntry is a request to the forwarder that could not be delivered.
+type spoolEntry struct {
+	Path          string          `json:"path"`
+	Body          json.RawMessage `json:"body"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	ExecutionID   string          `json:"execution_id"`
+	SpooledAt     time.Time       `json:"spooled_at"`
+}
+
+// spool keeps undelivered requests on disk, one file per request named so
+// that they sort oldest first. It holds at most size entries, dropping the
+// oldest ones when full.
+type spool struct {
+	dir  string
+	size int
+	mu   sync.Mutex
+}
+
+func newSpool(dir string, size int) *spool {
+	return &spool{dir: dir, size: size}
+}
+
+// add stores e and returns the entries dropped to make room for it.
+func (s *spool) add(e spoolEntry) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	names, err := s.names()
+	if err != nil {
+		return nil, err
+	}
+
+	var dropped []string
+	for len(names) >= s.size {
+		if err := os.Remove(filepath.Join(s.dir, names[0])); err != nil && !os.IsNotExist(err) {
+			return dropped, err
+		}
+		dropped = append(dropped, names[0])
+		names = names[1:]
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return dropped, err
+	}
+
+	name := fmt.Sprintf("%020d-%s.json", e.SpooledAt.UnixNano(), e.ExecutionID)
+	tmp := filepath.Join(s.dir, "."+name)
+
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return dropped, err
+	}
+
+	return dropped, os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+// names returns the spooled entries, oldest first. s.mu must be held.
+func (s *spool) names() ([]string, error) {
+	files, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		if !f.IsDir() && !strings.HasPrefix(f.Name(), ".") && strings.HasSuffix(f.Name(), ".json") {
+			names = append(names, f.Name())
+		}
+	}
+
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// oldest returns the oldest entry and its name, or false when the spool is
+// empty.
+func (s *spool) oldest() (string, spoolEntry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names, err := s.names()
+	if err != nil || len(names) == 0 {
+		return "", spoolEntry{}, false, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.dir, names[0]))
+	if err != nil {
+		return names[0], spoolEntry{}, false, err
+	}
+
+	var e spoolEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		// Moved aside so it doesn't block the entries behind it.
+		os.Rename(filepath.Join(s.dir, names[0]), filepath.Join(s.dir, "."+names[0]+".corrupt"))
+		return names[0], spoolEntry{}, false, fmt.Errorf("decoding spooled request %s: %w", names[0], err)
+	}
+
+	return names[0], e, true, nil
+}
+
+func (s *spool) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := os.Remove(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,134 @@
+package producer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+func TestSpoolRoundTrip(t *testing.T) {
+	s := newSpool(t.TempDir(), 2)
+	start := time.Now()
+
+	for i, executionID := range []string{"exec-1", "exec-2", "exec-3"} {
+		dropped, err := s.add(spoolEntry{Path: "/request", Body: json.RawMessage(`{}`), ExecutionID: executionID, SpooledAt: start.Add(time.Duration(i) * time.Second)})
+		if err != nil {
+			t.Fatalf("add(%s): %v", executionID, err)
+		}
+
+		// The spool holds two entries, so the third drops the first.
+		if want := i == 2; (len(dropped) == 1) != want {
+			t.Fatalf("add(%s) dropped %v", executionID, dropped)
+		}
+	}
+
+	for _, want := range []string{"exec-2", "exec-3"} {
+		name, e, ok, err := s.oldest()
+		if err != nil || !ok {
+			t.Fatalf("oldest() = %t, %v, want %s", ok, err, want)
+		}
+
+		if e.ExecutionID != want || e.Path != "/request" || string(e.Body) != "{}" {
+			t.Fatalf("oldest() = %+v, want %s", e, want)
+		}
+
+		if err := s.remove(name); err != nil {
+			t.Fatalf("remove(%s): %v", name, err)
+		}
+	}
+
+	if _, _, ok, err := s.oldest(); ok || err != nil {
+		t.Fatalf("oldest() of an empty spool = %t, %v", ok, err)
+	}
+}
+
+func TestSpoolMovesCorruptEntryAside(t *testing.T) {
+	dir := t.TempDir()
+	s := newSpool(dir, 10)
+
+	if err := os.WriteFile(filepath.Join(dir, "00000000000000000001-exec-1.json"), []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.add(spoolEntry{Path: "/request", ExecutionID: "exec-2", SpooledAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := s.oldest(); err == nil {
+		t.Fatal("oldest() of a corrupt entry succeeded")
+	}
+
+	if _, e, ok, err := s.oldest(); !ok || err != nil || e.ExecutionID != "exec-2" {
+		t.Fatalf("oldest() = %+v, %t, %v, want exec-2 behind the corrupt entry", e, ok, err)
+	}
+}
+
+func TestSpoolReplayTracksExpiredRequest(t *testing.T) {
+	var up atomic.Bool
+
+	forwarder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(shared.DataResponse{CorrelationID: "corr-1"})
+	}))
+	t.Cleanup(forwarder.Close)
+
+	customLogger := logger.New("producer")
+	customLogger.SetOutput(io.Discard)
+
+	ttl := 200 * time.Millisecond
+	p := New(Config{
+		ForwarderURL:        forwarder.URL,
+		ServiceName:         "payments",
+		OutstandingTTL:      ttl,
+		MaxAttempts:         1,
+		SpoolDir:            t.TempDir(),
+		SpoolReplayInterval: 10 * time.Millisecond,
+	}, customLogger)
+
+	if _, err := p.sendData(context.Background(), p.createDataRequest("exec-1")); !errors.Is(err, ErrSpooled) {
+		t.Fatalf("sendData() = %v, want ErrSpooled", err)
+	}
+
+	// The forwarder stays down for longer than the TTL.
+	time.Sleep(ttl + 50*time.Millisecond)
+	if requests := p.outstanding.list(); len(requests) != 0 {
+		t.Fatalf("outstanding %+v, want exec-1 expired", requests)
+	}
+
+	up.Store(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() { defer close(done); p.replaySpool(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(5 * time.Millisecond) {
+		if _, _, ok, _ := p.spool.oldest(); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("spool not replayed")
+		}
+	}
+
+	// The replayed request is tracked again, so its callback is accepted.
+	if err := p.outstanding.claim("exec-1", "corr-1"); err != nil {
+		t.Fatalf("claim() after replay = %v, want nil", err)
+	}
+}
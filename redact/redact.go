@@ -0,0 +1,286 @@
+// Package redact removes personal data from log lines, by masking or
+// hashing the values selected by field path and regular expression rules.
+package redact
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+	"gopkg.in/yaml.v3"
+)
+
+// Actions applied to the values a rule selects.
+const (
+	// ActionMask replaces the value with Mask.
+	ActionMask = "mask"
+	// ActionHash replaces the value with a keyed hash, so equal values can
+	// still be matched without being readable.
+	ActionHash = "hash"
+)
+
+// Mask replaces masked values.
+const Mask = "[redacted]"
+
+var (
+	redactRules = shared.GetEnv("REDACT_RULES", "")
+	redactKey   = shared.GetEnv("REDACT_KEY", "")
+)
+
+// Rule selects the values to redact in a log line.
+type Rule struct {
+	// Path is the dotted path of a field in the log line, such as
+	// fields.user_id. A * segment matches any one field and a ** segment
+	// any number of them. Arrays are traversed without a segment.
+	Path string `yaml:"path"`
+	// Pattern redacts the matches of a regular expression in the string
+	// values under Path, or in every string value without a Path.
+	Pattern string `yaml:"pattern"`
+	// Action is mask, the default, or hash.
+	Action string `yaml:"action"`
+}
+
+// DefaultRules hash user IDs and mask payloads and email addresses
+// anywhere in a log line.
+var DefaultRules = []Rule{
+	{Path: "**.user_id", Action: ActionHash},
+	{Path: "**.payload", Action: ActionMask},
+	{Pattern: `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`, Action: ActionMask},
+}
+
+type rule struct {
+	path    []string
+	pattern *regexp.Regexp
+	hash    bool
+}
+
+// Redactor applies rules to log lines.
+type Redactor struct {
+	rules []rule
+	key   []byte
+}
+
+// New compiles rules. Hashes are keyed with key, when set.
+func New(rules []Rule, key string) (*Redactor, error) {
+	r := &Redactor{key: []byte(key)}
+
+	for i, rl := range rules {
+		if rl.Path == "" && rl.Pattern == "" {
+			return nil, fmt.Errorf("rule %d: no path or pattern", i+1)
+		}
+
+		compiled := rule{}
+
+		if rl.Path != "" {
+			compiled.path = strings.Split(rl.Path, ".")
+		}
+
+		if rl.Pattern != "" {
+			pattern, err := regexp.Compile(rl.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			compiled.pattern = pattern
+		}
+
+		switch rl.Action {
+		case "", ActionMask:
+		case ActionHash:
+			compiled.hash = true
+		default:
+			return nil, fmt.Errorf("rule %d: unknown action %q", i+1, rl.Action)
+		}
+
+		r.rules = append(r.rules, compiled)
+	}
+
+	return r, nil
+}
+
+// LoadRules reads rules from a YAML file:
+//
+//	rules:
+//	  - path: fields.user_id
+//	    action: hash
+//	  - pattern: '\b\d{16}\b'
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file struct {
+		Rules []Rule `yaml:"rules"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing redaction rules %s: %w", path, err)
+	}
+
+	return file.Rules, nil
+}
+
+// FromEnv returns a redactor with the rules in the REDACT_RULES file, or
+// DefaultRules when unset, keyed with REDACT_KEY. Without a key, hashed
+// values can be recovered by hashing guesses, which is warned about once.
+func FromEnv() (*Redactor, error) {
+	rules := DefaultRules
+
+	if redactRules != "" {
+		var err error
+		if rules, err = LoadRules(redactRules); err != nil {
+			return nil, err
+		}
+	}
+
+	r, err := New(rules, redactKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if redactKey == "" && r.hashes() {
+		unkeyedWarning.Do(func() {
+			log.Printf("REDACT_KEY is not set, hashed values can be recovered by hashing guesses")
+		})
+	}
+
+	return r, nil
+}
+
+// unkeyedWarning warns once about hashing without a key.
+var unkeyedWarning sync.Once
+
+// hashes reports whether a rule hashes values.
+func (r *Redactor) hashes() bool {
+	for _, rl := range r.rules {
+		if rl.hash {
+			return true
+		}
+	}
+
+	return false
+}
+
+// JSON redacts a JSON log line.
+func (r *Redactor) JSON(line []byte) ([]byte, error) {
+	if len(r.rules) == 0 {
+		return line, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	var doc interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(r.Value(doc))
+}
+
+// Value redacts a decoded JSON value, returning the redacted value. Maps
+// and slices are redacted in place.
+func (r *Redactor) Value(v interface{}) interface{} {
+	for _, rl := range r.rules {
+		v = r.apply(rl, rl.path, v)
+	}
+
+	return v
+}
+
+// apply redacts the values of v under path.
+func (r *Redactor) apply(rl rule, path []string, v interface{}) interface{} {
+	if values, ok := v.([]interface{}); ok {
+		for i := range values {
+			values[i] = r.apply(rl, path, values[i])
+		}
+		return values
+	}
+
+	if len(path) == 0 {
+		return r.redact(rl, v)
+	}
+
+	fields, ok := v.(map[string]interface{})
+	if !ok {
+		return v
+	}
+
+	segment, rest := path[0], path[1:]
+
+	if segment == "**" {
+		// Match here, skipping the segment, and at any depth below.
+		v = r.apply(rl, rest, v)
+		for key, value := range fields {
+			fields[key] = r.apply(rl, path, value)
+		}
+		return v
+	}
+
+	for key, value := range fields {
+		if segment == "*" || segment == key {
+			fields[key] = r.apply(rl, rest, value)
+		}
+	}
+
+	return v
+}
+
+// redact applies the rule to a selected value: the whole value without a
+// pattern, and the pattern's matches in its strings otherwise.
+func (r *Redactor) redact(rl rule, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+
+	if rl.pattern == nil {
+		return r.replace(rl, v)
+	}
+
+	switch value := v.(type) {
+	case string:
+		return rl.pattern.ReplaceAllStringFunc(value, func(match string) string {
+			return r.replace(rl, match).(string)
+		})
+	case map[string]interface{}:
+		for key := range value {
+			value[key] = r.redact(rl, value[key])
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = r.redact(rl, value[i])
+		}
+	}
+
+	return v
+}
+
+func (r *Redactor) replace(rl rule, v interface{}) interface{} {
+	if !rl.hash {
+		return Mask
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		data, _ := json.Marshal(v)
+		s = string(data)
+	}
+
+	return r.Hash(s)
+}
+
+// Hash returns the keyed hash of s that replaces hashed values.
+func (r *Redactor) Hash(s string) string {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte(s))
+
+	return "hash:" + hex.EncodeToString(mac.Sum(nil))[:16]
+}
@@ -0,0 +1,212 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testKey = "test-key"
+
+func TestRedactorJSON(t *testing.T) {
+	hasher, _ := New(nil, testKey)
+	hash := hasher.Hash
+
+	tests := []struct {
+		name  string
+		rules []Rule
+		line  string
+		want  string
+	}{
+		{
+			name:  "hashes user_id at any depth",
+			rules: []Rule{{Path: "**.user_id", Action: ActionHash}},
+			line:  `{"user_id":"u-1","fields":{"user_id":"u-2","nested":{"user_id":"u-3"}}}`,
+			want:  `{"user_id":"` + hash("u-1") + `","fields":{"user_id":"` + hash("u-2") + `","nested":{"user_id":"` + hash("u-3") + `"}}}`,
+		},
+		{
+			name:  "hashes user_id in arrays",
+			rules: []Rule{{Path: "**.user_id", Action: ActionHash}},
+			line:  `{"fields":{"users":[{"user_id":"u-1"},{"user_id":"u-2"}]}}`,
+			want:  `{"fields":{"users":[{"user_id":"` + hash("u-1") + `"},{"user_id":"` + hash("u-2") + `"}]}}`,
+		},
+		{
+			name:  "hashes non string values",
+			rules: []Rule{{Path: "user_id", Action: ActionHash}},
+			line:  `{"user_id":42}`,
+			want:  `{"user_id":"` + hash("42") + `"}`,
+		},
+		{
+			name:  "star matches one field",
+			rules: []Rule{{Path: "fields.*.secret"}},
+			line:  `{"fields":{"a":{"secret":"s-1"},"b":{"secret":"s-2","other":"o"},"c":{"d":{"secret":"s-3"}}}}`,
+			want:  `{"fields":{"a":{"secret":"[redacted]"},"b":{"secret":"[redacted]","other":"o"},"c":{"d":{"secret":"s-3"}}}}`,
+		},
+		{
+			name:  "arrays are traversed without a segment",
+			rules: []Rule{{Path: "items.card"}},
+			line:  `{"items":[{"card":"4111"},{"card":"4222","sku":"x"}]}`,
+			want:  `{"items":[{"card":"[redacted]"},{"card":"[redacted]","sku":"x"}]}`,
+		},
+		{
+			name:  "masks a whole payload",
+			rules: []Rule{{Path: "**.payload"}},
+			line:  `{"fields":{"payload":{"amount":42,"user":"u-1"}}}`,
+			want:  `{"fields":{"payload":"[redacted]"}}`,
+		},
+		{
+			name:  "masks pattern matches in nested maps and arrays",
+			rules: []Rule{{Pattern: `[a-z]+@example\.com`}},
+			line:  `{"log":{"message":"mail ann@example.com"},"fields":{"to":["bob@example.com","x"],"n":1}}`,
+			want:  `{"log":{"message":"mail [redacted]"},"fields":{"to":["[redacted]","x"],"n":1}}`,
+		},
+		{
+			name:  "pattern under a path",
+			rules: []Rule{{Path: "fields", Pattern: `\d{4}`}},
+			line:  `{"fields":{"card":"card 1234"},"log":{"message":"order 5678"}}`,
+			want:  `{"fields":{"card":"card [redacted]"},"log":{"message":"order 5678"}}`,
+		},
+		{
+			name:  "hashes pattern matches",
+			rules: []Rule{{Pattern: `u-\d+`, Action: ActionHash}},
+			line:  `{"log":{"message":"user u-1 logged in"}}`,
+			want:  `{"log":{"message":"user ` + hash("u-1") + ` logged in"}}`,
+		},
+		{
+			name:  "leaves missing fields and nulls",
+			rules: []Rule{{Path: "**.user_id", Action: ActionHash}},
+			line:  `{"user_id":null,"fields":{"name":"n"}}`,
+			want:  `{"user_id":null,"fields":{"name":"n"}}`,
+		},
+		{
+			name:  "keeps large numbers",
+			rules: []Rule{{Path: "secret"}},
+			line:  `{"secret":"s","id":12345678901234567890}`,
+			want:  `{"secret":"[redacted]","id":12345678901234567890}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r, err := New(test.rules, testKey)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+
+			got, err := r.JSON([]byte(test.line))
+			if err != nil {
+				t.Fatalf("JSON: %v", err)
+			}
+
+			if !equalJSON(t, got, []byte(test.want)) {
+				t.Errorf("got  %s\nwant %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRedactorValue(t *testing.T) {
+	r, err := New(DefaultRules, testKey)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	v := map[string]interface{}{
+		"fields": map[string]interface{}{
+			"user_id": "u-1",
+			"payload": map[string]interface{}{"email": "ann@example.com"},
+			"notes":   []interface{}{"write to ann@example.com"},
+		},
+	}
+
+	r.Value(v)
+
+	fields := v["fields"].(map[string]interface{})
+	if fields["user_id"] != r.Hash("u-1") {
+		t.Errorf("user_id = %v, want its hash", fields["user_id"])
+	}
+	if fields["payload"] != Mask {
+		t.Errorf("payload = %v, want %s", fields["payload"], Mask)
+	}
+	if notes := fields["notes"].([]interface{}); notes[0] != "write to "+Mask {
+		t.Errorf("notes = %v, want the email masked", notes)
+	}
+}
+
+func TestHashIsKeyed(t *testing.T) {
+	a, _ := New(nil, "a")
+	b, _ := New(nil, "b")
+
+	if a.Hash("u-1") != a.Hash("u-1") {
+		t.Error("hash is not stable")
+	}
+	if a.Hash("u-1") == b.Hash("u-1") {
+		t.Error("hashes with different keys are equal")
+	}
+	if a.Hash("u-1") == a.Hash("u-2") {
+		t.Error("hashes of different values are equal")
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	tests := map[string]Rule{
+		"no path or pattern": {Action: ActionMask},
+		"invalid pattern":    {Pattern: "("},
+		"unknown action":     {Path: "user_id", Action: "drop"},
+	}
+
+	for name, rule := range tests {
+		if _, err := New([]Rule{rule}, testKey); err == nil {
+			t.Errorf("%s: New succeeded", name)
+		}
+	}
+}
+
+func TestJSONRejectsInvalidLines(t *testing.T) {
+	r, _ := New(DefaultRules, testKey)
+
+	if _, err := r.JSON([]byte("not json")); err == nil {
+		t.Fatal("JSON of a plain text line succeeded")
+	}
+}
+
+func equalJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	return reflect.DeepEqual(decode(t, a), decode(t, b))
+}
+
+func decode(t *testing.T, data []byte) interface{} {
+	t.Helper()
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+
+	return v
+}
+
+func TestFromEnvRejectsBrokenRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	os.WriteFile(path, []byte("rules:\n  - path: user_id\n    action: drop\n"), 0o644)
+
+	defer func(rules string) { redactRules = rules }(redactRules)
+	redactRules = path
+
+	if _, err := FromEnv(); err == nil {
+		t.Fatal("FromEnv with an unknown action succeeded")
+	}
+
+	redactRules = filepath.Join(t.TempDir(), "missing.yaml")
+
+	if _, err := FromEnv(); err == nil {
+		t.Fatal("FromEnv with a missing rules file succeeded")
+	}
+}
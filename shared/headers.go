@@ -0,0 +1,135 @@
+package shared
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+)
+
+// Header keys written by every publisher in the pipeline.
+const (
+	HeaderExecutionID   = "execution_id"
+	HeaderCorrelationID = "correlation_id"
+	HeaderOriginService = "origin_service"
+	HeaderProducedAt    = "produced_at"
+	HeaderContentType   = "content_type"
+	HeaderHops          = "hops"
+	// HeaderTraceID follows a request across services. It is only written
+	// when set.
+	HeaderTraceID = "trace_id"
+	// HeaderReplayedAt is added to messages re-published by the replay tool.
+	HeaderReplayedAt = "replayed_at"
+)
+
+// HTTP headers carrying the IDs of a request between services.
+const (
+	HTTPHeaderCorrelationID = "X-Correlation-Id"
+	HTTPHeaderExecutionID   = "X-Execution-Id"
+	HTTPHeaderTraceID       = "X-Trace-Id"
+	// HTTPHeaderIdempotencyKey identifies a data request across retries, so
+	// the forwarder starts a single execution for it. Clients send the
+	// execution ID.
+	HTTPHeaderIdempotencyKey = "Idempotency-Key"
+)
+
+// Content types describing the payload carried by each topic.
+const (
+	ContentTypeDataRequest   = "application/vnd.rtd.data-request.v1+json"
+	ContentTypeCommitRequest = "application/vnd.rtd.commit-request.v1+json"
+	ContentTypeEvent         = "application/vnd.rtd.event.v1+json"
+	ContentTypeAck           = "application/vnd.rtd.ack.v1+json"
+)
+
+// Headers is the typed form of the header set carried by every pipeline message.
+type Headers struct {
+	ExecutionID   string
+	CorrelationID string
+	OriginService string
+	ProducedAt    time.Time
+	ContentType   string
+	Hops          int
+	TraceID       string
+	// ReplayedAt is set on messages re-published by the replay tool. It is
+	// not forwarded.
+	ReplayedAt time.Time
+}
+
+// NewHeaders returns the headers for a message entering the pipeline.
+func NewHeaders(originService, contentType, correlationID, executionID string) Headers {
+	return Headers{
+		ExecutionID:   executionID,
+		CorrelationID: correlationID,
+		OriginService: originService,
+		ProducedAt:    time.Now().UTC(),
+		ContentType:   contentType,
+		Hops:          1,
+	}
+}
+
+// Forward returns the headers for a message derived from h by another service,
+// keeping the IDs and counting one more hop.
+func (h Headers) Forward(originService, contentType string) Headers {
+	return Headers{
+		ExecutionID:   h.ExecutionID,
+		CorrelationID: h.CorrelationID,
+		OriginService: originService,
+		ProducedAt:    time.Now().UTC(),
+		ContentType:   contentType,
+		Hops:          h.Hops + 1,
+		TraceID:       h.TraceID,
+	}
+}
+
+// Encode returns the headers as broker message headers.
+func (h Headers) Encode() []broker.Header {
+	headers := []broker.Header{
+		{Key: HeaderExecutionID, Value: []byte(h.ExecutionID)},
+		{Key: HeaderCorrelationID, Value: []byte(h.CorrelationID)},
+		{Key: HeaderOriginService, Value: []byte(h.OriginService)},
+		{Key: HeaderProducedAt, Value: []byte(h.ProducedAt.Format(time.RFC3339Nano))},
+		{Key: HeaderContentType, Value: []byte(h.ContentType)},
+		{Key: HeaderHops, Value: []byte(strconv.Itoa(h.Hops))},
+	}
+
+	if h.TraceID != "" {
+		headers = append(headers, broker.Header{Key: HeaderTraceID, Value: []byte(h.TraceID)})
+	}
+
+	if !h.ReplayedAt.IsZero() {
+		headers = append(headers, broker.Header{Key: HeaderReplayedAt, Value: []byte(h.ReplayedAt.Format(time.RFC3339Nano))})
+	}
+
+	return headers
+}
+
+// ParseHeaders decodes broker message headers. Missing or malformed values are
+// left at their zero value.
+func ParseHeaders(headers []broker.Header) Headers {
+	var h Headers
+
+	for _, header := range headers {
+		value := string(header.Value)
+
+		switch header.Key {
+		case HeaderExecutionID:
+			h.ExecutionID = value
+		case HeaderCorrelationID:
+			h.CorrelationID = value
+		case HeaderOriginService:
+			h.OriginService = value
+		case HeaderProducedAt:
+			h.ProducedAt, _ = time.Parse(time.RFC3339Nano, value)
+		case HeaderContentType:
+			h.ContentType = value
+		case HeaderHops:
+			h.Hops, _ = strconv.Atoi(value)
+		case HeaderTraceID:
+			h.TraceID = value
+		case HeaderReplayedAt:
+			h.ReplayedAt, _ = time.Parse(time.RFC3339Nano, value)
+		}
+	}
+
+	return h
+}
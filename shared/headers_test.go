@@ -0,0 +1,97 @@
+package shared
+
+import (
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+)
+
+func TestHeadersRoundTrip(t *testing.T) {
+	h := Headers{
+		ExecutionID:   "exec-1",
+		CorrelationID: "corr-1",
+		OriginService: "forwarder",
+		ProducedAt:    time.Date(2024, 5, 1, 12, 30, 0, 123456789, time.UTC),
+		ContentType:   ContentTypeDataRequest,
+		Hops:          3,
+		TraceID:       "trace-1",
+		ReplayedAt:    time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC),
+	}
+
+	got := ParseHeaders(h.Encode())
+	if !got.ProducedAt.Equal(h.ProducedAt) {
+		t.Errorf("ProducedAt = %s, want %s", got.ProducedAt, h.ProducedAt)
+	}
+	if !got.ReplayedAt.Equal(h.ReplayedAt) {
+		t.Errorf("ReplayedAt = %s, want %s", got.ReplayedAt, h.ReplayedAt)
+	}
+
+	got.ProducedAt, got.ReplayedAt = h.ProducedAt, h.ReplayedAt
+	if got != h {
+		t.Errorf("ParseHeaders(Encode()) = %+v, want %+v", got, h)
+	}
+}
+
+func TestHeadersEncodeOmitsEmptyTrace(t *testing.T) {
+	h := NewHeaders("forwarder", ContentTypeDataRequest, "corr-1", "exec-1")
+
+	for _, header := range h.Encode() {
+		if header.Key == HeaderTraceID {
+			t.Errorf("Encode() wrote %s without a trace ID", HeaderTraceID)
+		}
+	}
+
+	if got := ParseHeaders(h.Encode()); got.TraceID != "" {
+		t.Errorf("TraceID = %q, want empty", got.TraceID)
+	}
+}
+
+func TestParseHeadersMalformed(t *testing.T) {
+	h := ParseHeaders([]broker.Header{
+		{Key: HeaderCorrelationID, Value: []byte("corr-1")},
+		{Key: HeaderHops, Value: []byte("many")},
+		{Key: HeaderProducedAt, Value: []byte("yesterday")},
+	})
+
+	want := Headers{CorrelationID: "corr-1"}
+	if h != want {
+		t.Errorf("ParseHeaders() = %+v, want %+v", h, want)
+	}
+}
+
+func TestHeadersForward(t *testing.T) {
+	h := NewHeaders("forwarder", ContentTypeCommitRequest, "corr-1", "exec-1")
+	h.TraceID = "trace-1"
+
+	if h.Hops != 1 {
+		t.Fatalf("NewHeaders hops = %d, want 1", h.Hops)
+	}
+
+	for hop := 2; hop <= 4; hop++ {
+		h = h.Forward("monitor", ContentTypeEvent)
+
+		if h.Hops != hop {
+			t.Errorf("hops after forward = %d, want %d", h.Hops, hop)
+		}
+	}
+
+	want := Headers{
+		ExecutionID:   "exec-1",
+		CorrelationID: "corr-1",
+		OriginService: "monitor",
+		ContentType:   ContentTypeEvent,
+		Hops:          4,
+		TraceID:       "trace-1",
+	}
+
+	got := h
+	got.ProducedAt = time.Time{}
+	if got != want {
+		t.Errorf("Forward() = %+v, want %+v", got, want)
+	}
+
+	if h.ProducedAt.IsZero() {
+		t.Error("Forward() left ProducedAt unset")
+	}
+}
@@ -1,6 +1,7 @@
 package shared
 
 import (
+	"encoding/json"
 	"os"
 	"time"
 )
@@ -10,6 +11,9 @@ type CommitRequest struct {
 	ExecutionID   string `json:"execution_id"`
 	OriginService string `json:"origin_service"`
 	Commit        bool   `json:"commit"`
+	// Request is the data request being decided, attached by a forwarder
+	// with a blob store, so the monitor doesn't depend on remembering it.
+	Request *DataRequest `json:"request,omitempty"`
 }
 
 type DataRequest struct {
@@ -19,6 +23,14 @@ type DataRequest struct {
 	Callback      string    `json:"callback"`
 	CorrelationID string    `json:"correlation_id"`
 	ExecutionID   string    `json:"execution_id"`
+	// ContentType declares the JSON media type of Payload, such as
+	// application/vnd.acme.order+json.
+	ContentType string            `json:"content_type,omitempty"`
+	Payload     json.RawMessage   `json:"payload,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	// PayloadRef is the blob store key of a payload the forwarder offloaded
+	// because it was too large to publish. Payload is empty when it is set.
+	PayloadRef string `json:"payload_ref,omitempty"`
 }
 
 type EventRequest struct {
@@ -32,10 +44,45 @@ type DataResponse struct {
 	CorrelationID string `json:"correlation_id"`
 }
 
+// EventTypeCommitted is the type of events published for committed requests.
+const EventTypeCommitted = "committed"
+
 type Event struct {
-	CorrelationID string `json:"correlation_id"`
-	ExecutionID   string `json:"execution_id"`
-	ServiceName   string `json:"service_name"`
+	CorrelationID string    `json:"correlation_id"`
+	ExecutionID   string    `json:"execution_id"`
+	ServiceName   string    `json:"service_name"`
+	Type          string    `json:"type"`
+	RequestedAt   time.Time `json:"requested_at"`
+	// ContentType, Payload, Attributes and PayloadRef are copied from the
+	// data request.
+	ContentType string            `json:"content_type,omitempty"`
+	Payload     json.RawMessage   `json:"payload,omitempty"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	PayloadRef  string            `json:"payload_ref,omitempty"`
+}
+
+// Ack statuses published by consumers once they are done with an event.
+const (
+	AckProcessed = "processed"
+	AckUnhandled = "unhandled"
+	AckFailed    = "failed"
+)
+
+// Ack reports the end of an execution: a consumer finished processing its event.
+type Ack struct {
+	CorrelationID string    `json:"correlation_id"`
+	ExecutionID   string    `json:"execution_id"`
+	ServiceName   string    `json:"service_name"`
+	Consumer      string    `json:"consumer"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	RequestedAt   time.Time `json:"requested_at"`
+	CompletedAt   time.Time `json:"completed_at"`
+	// ProcessingMs is the time the consumer spent on the event.
+	ProcessingMs float64 `json:"processing_ms"`
+	// EndToEndMs is the time from the producer's request to completion, when
+	// the request time is known.
+	EndToEndMs float64 `json:"end_to_end_ms,omitempty"`
 }
 
 type Message struct {
@@ -50,14 +97,47 @@ type LogEntry struct {
 
 // LogData contains the log data to be stored.
 type LogData struct {
-	Container     string      `json:"container"`
-	CorrelationID string      `json:"correlation_id"`
-	ExecutionID   string      `json:"execution_id"`
-	FriendlyName  string      `json:"friendly_name"`
-	Timestamp     string      `json:"timestamp"`
-	Target        string      `json:"target"`
-	Log           LogEntry    `json:"log"`
-	Error         interface{} `json:"error,omitempty"`
+	Container     string `json:"container"`
+	CorrelationID string `json:"correlation_id"`
+	ExecutionID   string `json:"execution_id"`
+	FriendlyName  string `json:"friendly_name"`
+	Timestamp     string `json:"timestamp"`
+	Target        string `json:"target"`
+	TraceID       string `json:"trace_id,omitempty"`
+	// Level is debug, info, warn or error.
+	Level string   `json:"level,omitempty"`
+	Log   LogEntry `json:"log"`
+	// Fields are the structured key/value pairs logged with the message.
+	Fields map[string]interface{} `json:"fields,omitempty"`
+	Error  *ErrorData             `json:"error,omitempty"`
+}
+
+// ErrorData is a logged error.
+type ErrorData struct {
+	Message string `json:"message"`
+	// Type is the Go type of the error, such as *url.Error.
+	Type string `json:"type,omitempty"`
+	// Chain holds the errors it wraps, outermost first.
+	Chain []ErrorData `json:"chain,omitempty"`
+	// Stack is where the error was logged, when stacks are enabled.
+	Stack string `json:"stack,omitempty"`
+	// HTTPStatus is the status code of a failed HTTP call, if any.
+	HTTPStatus int `json:"http_status,omitempty"`
+	// KafkaCode is the Kafka protocol error code, if any.
+	KafkaCode int `json:"kafka_code,omitempty"`
+}
+
+// UnmarshalJSON also accepts a plain string, as logged before errors were
+// structured.
+func (e *ErrorData) UnmarshalJSON(data []byte) error {
+	var message string
+	if err := json.Unmarshal(data, &message); err == nil {
+		*e = ErrorData{Message: message}
+		return nil
+	}
+
+	type errorData ErrorData
+	return json.Unmarshal(data, (*errorData)(e))
 }
 
 func GetEnv(key, defaultValue string) string {
@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/assimoes/rtd-sandbox/redact"
+	"github.com/assimoes/rtd-sandbox/shared"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+)
+
+const pageSize = 25
+
+// New returns the dashboard app serving the frontend from staticDir and the
+// execution API from store.
+func New(store Store, staticDir string) *fiber.App {
+
+	app := fiber.New()
+
+	s := &server{store: store, redactor: newRedactor()}
+
+	// Logging for each request
+	app.Use(logger.New())
+
+	// Serve static content app
+	app.Static("/", staticDir)
+
+	// Serve api endpoints
+	app.Get("/api/executions", s.getExecIds)
+	app.Get("/api/executions/:execution_id", s.getExecDetails)
+
+	// Log entries sent by the services' http log sinks
+	if ingester, ok := store.(Ingester); ok {
+		app.Post("/api/logs", func(c *fiber.Ctx) error {
+			return s.ingestLogs(c, ingester)
+		})
+	}
+
+	return app
+}
+
+type server struct {
+	store    Store
+	redactor *redact.Redactor
+}
+
+// newRedactor returns the redactor of redact.FromEnv, exiting when its rules
+// can't be loaded rather than store lines redacted by other rules.
+func newRedactor() *redact.Redactor {
+	redactor, err := redact.FromEnv()
+	if err != nil {
+		log.Fatalf("error loading redaction rules: %v", err)
+	}
+
+	return redactor
+}
+
+// logEntries decodes shared.LogData JSON lines, redacted again in case they
+// were sent without redaction. Lines that are not log data are skipped.
+func logEntries(redactor *redact.Redactor, lines [][]byte) []shared.LogData {
+	var entries []shared.LogData
+
+	for _, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		line, err := redactor.JSON(line)
+		if err != nil {
+			continue
+		}
+
+		var data shared.LogData
+		if err := json.Unmarshal(line, &data); err != nil {
+			continue
+		}
+
+		entries = append(entries, data)
+	}
+
+	return entries
+}
+
+// ingestLogs stores newline delimited shared.LogData JSON lines, see
+// logEntries.
+func (s *server) ingestLogs(c *fiber.Ctx, ingester Ingester) error {
+	entries := logEntries(s.redactor, bytes.Split(c.Body(), []byte("\n")))
+
+	if err := ingester.Ingest(c.UserContext(), entries); err != nil {
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return c.SendStatus(http.StatusNoContent)
+}
+
+func (s *server) getExecIds(c *fiber.Ctx) error {
+	pageParam := c.Query("page")
+	page, err := strconv.Atoi(pageParam)
+
+	if err != nil || page < 1 {
+		return c.Status(http.StatusBadRequest).SendString("invalid page number")
+	}
+
+	executionIDs, totalCount, err := s.store.ExecutionIDs(c.UserContext(), (page-1)*pageSize, pageSize)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	totalPages := totalCount / pageSize
+
+	if totalCount%pageSize != 0 {
+		totalPages++
+	}
+
+	response := map[string]interface{}{
+		"page":        page,
+		"total_pages": totalPages,
+		"data":        executionIDs,
+	}
+
+	return c.JSON(response)
+}
+
+func (s *server) getExecDetails(c *fiber.Ctx) error {
+	executionID := c.Params("execution_id")
+
+	if executionID == "" {
+		return c.Status(http.StatusBadRequest).SendString("invalid execution id")
+	}
+
+	executionDetails, err := s.store.ExecutionDetails(c.UserContext(), executionID)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).SendString(err.Error())
+	}
+
+	response := map[string]interface{}{
+		"execution_id": executionID,
+		"data":         executionDetails,
+	}
+
+	return c.JSON(response)
+}
@@ -0,0 +1,67 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// recordingStore keeps the entries it ingests.
+type recordingStore struct {
+	mu      sync.Mutex
+	entries []shared.LogData
+}
+
+func (s *recordingStore) ExecutionIDs(ctx context.Context, skip, limit int) ([]string, int, error) {
+	return nil, 0, nil
+}
+
+func (s *recordingStore) ExecutionDetails(ctx context.Context, executionID string) ([]map[string]interface{}, error) {
+	return nil, nil
+}
+
+func (s *recordingStore) Ingest(ctx context.Context, entries []shared.LogData) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func TestIngestLogsRedacts(t *testing.T) {
+	store := &recordingStore{}
+	app := New(store, t.TempDir())
+
+	body := strings.Join([]string{
+		`{"execution_id":"exec-1","log":{"message":"mailing ann@example.com"},"fields":{"user_id":"user-1234","payload":{"card":"4111111111111111"}}}`,
+		`not a log line`,
+		`{"execution_id":"exec-1","log":{"message":"done"},"error":{"message":"no account for bob@example.com"}}`,
+	}, "\n")
+
+	req := httptest.NewRequest(http.MethodPost, "/api/logs", strings.NewReader(body))
+	res, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Test: %v", err)
+	}
+	if res.StatusCode != http.StatusNoContent {
+		t.Fatalf("status %d, want 204", res.StatusCode)
+	}
+
+	if len(store.entries) != 2 {
+		t.Fatalf("stored %d entries, want 2", len(store.entries))
+	}
+
+	stored, _ := json.Marshal(store.entries)
+
+	for _, raw := range []string{"user-1234", "4111111111111111", "ann@example.com", "bob@example.com"} {
+		if strings.Contains(string(stored), raw) {
+			t.Errorf("stored %s, containing %q", stored, raw)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package backend
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/redact"
+)
+
+const (
+	// logBatchSize bounds the lines ingested at once from the log topic.
+	logBatchSize = 500
+	// logBatchWait is how long a batch waits for more lines once it has one.
+	logBatchWait = 200 * time.Millisecond
+	// maxLogBackoff bounds the wait between failed reads or ingests.
+	maxLogBackoff = 10 * time.Second
+)
+
+// LogConsumer stores the log lines the services' broker sinks publish.
+type LogConsumer struct {
+	bus      broker.Broker
+	topic    string
+	group    string
+	ingester Ingester
+	redactor *redact.Redactor
+}
+
+// NewLogConsumer returns a consumer of topic in group, storing its lines in
+// ingester.
+func NewLogConsumer(bus broker.Broker, topic, group string, ingester Ingester) *LogConsumer {
+	return &LogConsumer{
+		bus:      bus,
+		topic:    topic,
+		group:    group,
+		ingester: ingester,
+		redactor: newRedactor(),
+	}
+}
+
+// Run ingests the log lines in batches until ctx is done, committing each
+// batch once stored.
+func (c *LogConsumer) Run(ctx context.Context) error {
+	sub, err := c.bus.Subscribe(c.topic, broker.SubscribeOptions{Group: c.group})
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	var backoff time.Duration
+
+	for {
+		msgs, err := c.fetch(ctx, sub)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if err != nil {
+			log.Printf("error reading from %s topic: %v", c.topic, err)
+
+			backoff = min(max(2*backoff, 100*time.Millisecond), maxLogBackoff)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		backoff = 0
+
+		lines := make([][]byte, len(msgs))
+		for i, msg := range msgs {
+			lines[i] = msg.Value
+		}
+
+		if err := c.ingest(ctx, lines); err != nil {
+			return err
+		}
+
+		if err := sub.Commit(ctx, msgs...); err != nil {
+			log.Printf("error committing %s topic: %v", c.topic, err)
+		}
+	}
+}
+
+// fetch waits for a message, then collects those following it for up to
+// logBatchWait.
+func (c *LogConsumer) fetch(ctx context.Context, sub broker.Subscription) ([]broker.Message, error) {
+	msg, err := sub.Fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []broker.Message{msg}
+
+	batchCtx, cancel := context.WithTimeout(ctx, logBatchWait)
+	defer cancel()
+
+	for len(msgs) < logBatchSize {
+		msg, err := sub.Fetch(batchCtx)
+		if err != nil {
+			break
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, nil
+}
+
+// ingest stores lines, retrying until they are stored or ctx is done, so
+// lines aren't lost while the store is down.
+func (c *LogConsumer) ingest(ctx context.Context, lines [][]byte) error {
+	entries := logEntries(c.redactor, lines)
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var backoff time.Duration
+
+	for {
+		err := c.ingester.Ingest(ctx, entries)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		log.Printf("error storing %d log entries: %v", len(entries), err)
+
+		backoff = min(max(2*backoff, 100*time.Millisecond), maxLogBackoff)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
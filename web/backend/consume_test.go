@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/assimoes/rtd-sandbox/broker"
+	"github.com/assimoes/rtd-sandbox/logger"
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// flakyIngester fails its first Ingest, then keeps the entries.
+type flakyIngester struct {
+	mu      sync.Mutex
+	failed  bool
+	entries []shared.LogData
+}
+
+func (f *flakyIngester) Ingest(ctx context.Context, entries []shared.LogData) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !f.failed {
+		f.failed = true
+		return errors.New("store down")
+	}
+
+	f.entries = append(f.entries, entries...)
+	return nil
+}
+
+func (f *flakyIngester) stored() []shared.LogData {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]shared.LogData(nil), f.entries...)
+}
+
+func TestLogConsumerStoresTopic(t *testing.T) {
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	for _, line := range []string{
+		`{"execution_id":"exec-1","log":{"message":"one"},"fields":{"user_id":"u-1"}}`,
+		`not a log line`,
+		`{"execution_id":"exec-1","log":{"message":"two"}}`,
+	} {
+		if err := bus.Publish(context.Background(), "logs", broker.Message{Value: []byte(line)}); err != nil {
+			t.Fatalf("Publish: %v", err)
+		}
+	}
+
+	ingester := &flakyIngester{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewLogConsumer(bus, "logs", "backend", ingester).Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ingester.stored()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("stored %d entries, want 2", len(ingester.stored()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entries := ingester.stored()
+	if entries[0].Log.Message != "one" || entries[1].Log.Message != "two" {
+		t.Errorf("stored %+v, want one then two", entries)
+	}
+
+	if entries[0].Fields["user_id"] == "u-1" {
+		t.Error("user_id stored unredacted")
+	}
+}
+
+func TestLogConsumerStoresBrokerSinkLines(t *testing.T) {
+	bus := broker.NewMemory(broker.MemoryConfig{})
+	t.Cleanup(func() { bus.Close() })
+
+	customLogger := logger.New("forwarder")
+	customLogger.SetSinks(logger.NewBrokerSink(bus, "logs"))
+	customLogger.Log("kafka", "request forwarded", nil, "corr-1", "exec-1")
+
+	ingester := &flakyIngester{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewLogConsumer(bus, "logs", "backend", ingester).Run(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ingester.stored()) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("stored no entry of the broker sink")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	entry := ingester.stored()[0]
+	if entry.FriendlyName != "forwarder" || entry.ExecutionID != "exec-1" || entry.Log.Message != "request forwarded" {
+		t.Errorf("stored %+v, want the forwarder's line", entry)
+	}
+}
@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// MemoryStore keeps log documents in memory. It implements io.Writer so
+// loggers in the same process can write their JSON lines straight into it.
+type MemoryStore struct {
+	mu         sync.RWMutex
+	documents  map[string][]map[string]interface{}
+	firstSeen  map[string]string
+	executions []string
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		documents: make(map[string][]map[string]interface{}),
+		firstSeen: make(map[string]string),
+	}
+}
+
+// Write ingests newline separated shared.LogData JSON lines. Lines that are
+// not log data are ignored.
+func (s *MemoryStore) Write(p []byte) (int, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(p))
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	for scanner.Scan() {
+		var data shared.LogData
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			continue
+		}
+
+		s.Add(data)
+	}
+
+	return len(p), nil
+}
+
+// Ingest stores log entries, see Add.
+func (s *MemoryStore) Ingest(ctx context.Context, entries []shared.LogData) error {
+	for _, data := range entries {
+		s.Add(data)
+	}
+
+	return nil
+}
+
+// Add stores a log entry in the same document shape the docker log collector
+// writes to Mongo.
+func (s *MemoryStore) Add(data shared.LogData) {
+	if data.ExecutionID == "" {
+		return
+	}
+
+	doc := document(data)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.firstSeen[data.ExecutionID]; !ok {
+		s.firstSeen[data.ExecutionID] = data.Timestamp
+		s.executions = append(s.executions, data.ExecutionID)
+	}
+
+	s.documents[data.ExecutionID] = append(s.documents[data.ExecutionID], doc)
+}
+
+func (s *MemoryStore) ExecutionIDs(ctx context.Context, skip, limit int) ([]string, int, error) {
+	s.mu.RLock()
+	executions := append([]string(nil), s.executions...)
+	sort.SliceStable(executions, func(i, j int) bool {
+		return s.firstSeen[executions[i]] < s.firstSeen[executions[j]]
+	})
+	s.mu.RUnlock()
+
+	total := len(executions)
+
+	if skip >= total {
+		return nil, total, nil
+	}
+
+	end := skip + limit
+	if end > total {
+		end = total
+	}
+
+	return executions[skip:end], total, nil
+}
+
+func (s *MemoryStore) ExecutionDetails(ctx context.Context, executionID string) ([]map[string]interface{}, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	docs := append([]map[string]interface{}(nil), s.documents[executionID]...)
+	sort.SliceStable(docs, func(i, j int) bool {
+		return docs[i]["timestamp"].(string) < docs[j]["timestamp"].(string)
+	})
+
+	return docs, nil
+}
@@ -0,0 +1,143 @@
+package backend
+
+import (
+	"context"
+	"errors"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore reads the logs stored by the docker log collector.
+type MongoStore struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+}
+
+func NewMongoStore(ctx context.Context, uri, dbName, collectionName string) (*MongoStore, error) {
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MongoStore{
+		client:     client,
+		collection: client.Database(dbName).Collection(collectionName),
+	}, nil
+}
+
+// Ingest inserts log entries with an execution ID.
+func (s *MongoStore) Ingest(ctx context.Context, entries []shared.LogData) error {
+	var docs []interface{}
+	for _, data := range entries {
+		if data.ExecutionID != "" {
+			docs = append(docs, document(data))
+		}
+	}
+
+	if len(docs) == 0 {
+		return nil
+	}
+
+	_, err := s.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (s *MongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect(ctx)
+}
+
+func (s *MongoStore) ExecutionIDs(ctx context.Context, skip, limit int) ([]string, int, error) {
+	matchStage := bson.D{
+		{Key: "$match", Value: bson.D{
+			{Key: "log.executionid", Value: bson.D{
+				{Key: "$ne", Value: ""},
+			}},
+		}},
+	}
+
+	groupStage := bson.D{
+		{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$log.executionid"},
+			{Key: "execution_id", Value: bson.D{
+				{Key: "$first", Value: "$log.executionid"},
+			}},
+			{Key: "count", Value: bson.D{
+				{Key: "$sum", Value: 1},
+			}},
+			{Key: "timestamp", Value: bson.D{
+				{Key: "$first", Value: "$timestamp"},
+			}},
+		}},
+	}
+
+	sortStage := bson.D{
+		{Key: "$sort", Value: bson.D{
+			{Key: "timestamp", Value: 1},
+		}},
+	}
+
+	skipStage := bson.D{
+		{Key: "$skip", Value: int64(skip)},
+	}
+
+	limitStage := bson.D{
+		{Key: "$limit", Value: int64(limit)},
+	}
+
+	cursor, err := s.collection.Aggregate(ctx, mongo.Pipeline{matchStage, groupStage, sortStage, skipStage, limitStage})
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var executionIDs []string
+
+	for cursor.Next(ctx) {
+
+		var result struct {
+			ID string `bson:"_id"`
+		}
+
+		if err := cursor.Decode(&result); err != nil {
+			return nil, 0, errors.New("error decoding execution id")
+		}
+
+		executionIDs = append(executionIDs, result.ID)
+	}
+
+	// Calculate the total count of execution IDs
+	totalCount, err := s.collection.Distinct(ctx, "log.executionid", bson.D{})
+	if err != nil {
+		return nil, 0, errors.New("error counting execution IDs")
+	}
+
+	return executionIDs, len(totalCount), nil
+}
+
+func (s *MongoStore) ExecutionDetails(ctx context.Context, executionID string) ([]map[string]interface{}, error) {
+	filter := bson.M{"log.executionid": executionID}
+
+	opts := options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}})
+
+	cursor, err := s.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, errors.New("error fetching execution details from mongo")
+	}
+	defer cursor.Close(ctx)
+
+	var executionDetails []map[string]interface{}
+
+	for cursor.Next(ctx) {
+		var result map[string]interface{}
+		if err := cursor.Decode(&result); err != nil {
+			return nil, errors.New("error decoding execution details")
+		}
+
+		executionDetails = append(executionDetails, result)
+	}
+
+	return executionDetails, nil
+}
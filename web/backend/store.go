@@ -0,0 +1,64 @@
+package backend
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/assimoes/rtd-sandbox/shared"
+)
+
+// Store is the read side of the collected logs.
+type Store interface {
+	// ExecutionIDs returns a page of execution IDs ordered by their first log
+	// entry, together with the total number of execution IDs.
+	ExecutionIDs(ctx context.Context, skip, limit int) ([]string, int, error)
+	// ExecutionDetails returns every log document of an execution in
+	// timestamp order.
+	ExecutionDetails(ctx context.Context, executionID string) ([]map[string]interface{}, error)
+}
+
+// Ingester is implemented by stores that take log entries sent straight by
+// the services, rather than collected from docker.
+type Ingester interface {
+	Ingest(ctx context.Context, entries []shared.LogData) error
+}
+
+// document returns a log entry in the document shape the docker log
+// collector writes to Mongo.
+func document(data shared.LogData) map[string]interface{} {
+	msg, _ := json.Marshal(data.Log)
+
+	doc := map[string]interface{}{
+		"container":    data.Container,
+		"friendlyname": data.FriendlyName,
+		"timestamp":    data.Timestamp,
+		"target":       data.Target,
+		"log": map[string]interface{}{
+			"executionid":   data.ExecutionID,
+			"correlationid": data.CorrelationID,
+			"message":       string(msg),
+		},
+	}
+
+	if data.TraceID != "" {
+		doc["log"].(map[string]interface{})["traceid"] = data.TraceID
+	}
+
+	if data.Level != "" {
+		doc["level"] = data.Level
+	}
+
+	if data.Fields != nil {
+		doc["fields"] = data.Fields
+	}
+
+	if data.Error != nil {
+		// Stored with its JSON field names, as the collector does.
+		var logError map[string]interface{}
+		if raw, err := json.Marshal(data.Error); err == nil && json.Unmarshal(raw, &logError) == nil {
+			doc["error"] = logError
+		}
+	}
+
+	return doc
+}
@@ -1,4 +1,4 @@
-package main
+package ws
 
 import (
 	"log"
@@ -14,10 +14,24 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-var clients = make(map[*websocket.Conn]bool) // connected clients
-var lock = sync.RWMutex{}                    // lock for concurrent access to the clients map
+// Hub broadcasts every message received from a client to all connected clients.
+type Hub struct {
+	clients map[*websocket.Conn]bool // connected clients
+	lock    sync.RWMutex             // lock for concurrent access to the clients map
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: make(map[*websocket.Conn]bool)}
+}
+
+// Handler returns the hub's routes.
+func (h *Hub) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", h.handleConnections)
+	return mux
+}
 
-func handleConnections(w http.ResponseWriter, r *http.Request) {
+func (h *Hub) handleConnections(w http.ResponseWriter, r *http.Request) {
 	ws, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Println("Error during WebSocket upgrade:", err)
@@ -25,16 +39,16 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Register the new client
-	lock.Lock()
-	clients[ws] = true
-	lock.Unlock()
+	h.lock.Lock()
+	h.clients[ws] = true
+	h.lock.Unlock()
 
 	log.Println("Client connected")
 
 	defer func() {
-		lock.Lock()
-		delete(clients, ws)
-		lock.Unlock()
+		h.lock.Lock()
+		delete(h.clients, ws)
+		h.lock.Unlock()
 		ws.Close()
 		log.Println("Client disconnected")
 	}()
@@ -46,27 +60,23 @@ func handleConnections(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		// Broadcast the message to all connected clients
-		lock.RLock()
-		for client := range clients {
-			if err := client.WriteMessage(messageType, p); err != nil {
-				log.Println("Error while writing message:", err)
-				lock.Lock()
-				delete(clients, client)
-				lock.Unlock()
-			}
-		}
-		lock.RUnlock()
+		h.Broadcast(messageType, p)
 
 		log.Println("Received and broadcasted message")
 	}
 }
 
-func main() {
-	http.HandleFunc("/ws", handleConnections)
-	log.Println("WebSocket server started on :8081")
-	err := http.ListenAndServe(":8899", nil)
-	if err != nil {
-		panic("Error starting server: " + err.Error())
+// Broadcast sends a message to all connected clients, dropping clients that
+// can't be written to. Writes are serialized since a connection supports only
+// one concurrent writer.
+func (h *Hub) Broadcast(messageType int, p []byte) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for client := range h.clients {
+		if err := client.WriteMessage(messageType, p); err != nil {
+			log.Println("Error while writing message:", err)
+			delete(h.clients, client)
+		}
 	}
 }